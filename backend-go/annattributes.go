@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 类别属性：类别 mate 中的 attributes 定义该类别标注可带的属性，标注值存放在 data.attributes
+//
+//	{"attributes": [
+//	  {"name": "occluded", "type": "bool"},
+//	  {"name": "color", "type": "enum", "values": ["red", "white"], "required": true},
+//	  {"name": "plate", "type": "string"}
+//	]}
+//
+// 导出时原样随类别 mate 和标注 data 交给插件，由插件映射为各格式的字段
+const (
+	attributeTypeBool   = "bool"
+	attributeTypeEnum   = "enum"
+	attributeTypeString = "string"
+)
+
+// 属性定义的上限
+const (
+	maxCategoryAttributes  = 64
+	maxAttributeNameLen    = 64
+	maxAttributeEnumValues = 256
+	maxAttributeStringLen  = 1024
+)
+
+// attributeFilterPrefix 按标注属性筛选的参数前缀，如 attr.occluded=true
+const attributeFilterPrefix = "attr."
+
+// sqlCategoriesWithBoolAttribute 定义了指定名称布尔属性的类别 ID；
+// 用 CASE 先排除不是合法 JSON 的 mate 和不是对象的属性项，避免 json_extract 报错
+const sqlCategoriesWithBoolAttribute = `SELECT c.id FROM categories c WHERE CASE WHEN json_valid(c.mate) THEN EXISTS (
+	SELECT 1 FROM json_each(c.mate, '$.attributes') d
+	WHERE CASE WHEN d.type = 'object' THEN json_extract(d.value, '$.name') = ? AND json_extract(d.value, '$.type') = 'bool' ELSE 0 END
+) ELSE 0 END`
+
+// categoryAttribute 一个属性的定义
+type categoryAttribute struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Values   []string `json:"values,omitempty"`
+	Required bool     `json:"required,omitempty"`
+}
+
+// validAttributeName 属性名只允许字母、数字、下划线和连字符，便于拼进 JSON 路径和各导出格式
+func validAttributeName(name string) bool {
+	if name == "" || len(name) > maxAttributeNameLen {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c == '-' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCategoryAttributes 解析并检查 mate 中的属性定义；mate 为空或没有 attributes 时返回 nil
+func parseCategoryAttributes(mate string) ([]categoryAttribute, string) {
+	mate = strings.TrimSpace(mate)
+	if mate == "" {
+		return nil, ""
+	}
+	var m struct {
+		Attributes []categoryAttribute `json:"attributes"`
+	}
+	if err := json.Unmarshal([]byte(mate), &m); err != nil {
+		// mate 不是对象的旧数据不含属性定义
+		return nil, ""
+	}
+	if len(m.Attributes) > maxCategoryAttributes {
+		return nil, "attributes_too_many"
+	}
+	seen := make(map[string]bool, len(m.Attributes))
+	for _, a := range m.Attributes {
+		if !validAttributeName(a.Name) || seen[a.Name] {
+			return nil, "attribute_name_invalid"
+		}
+		seen[a.Name] = true
+		switch a.Type {
+		case attributeTypeBool, attributeTypeString:
+			if len(a.Values) > 0 {
+				return nil, "attribute_values_invalid"
+			}
+		case attributeTypeEnum:
+			if len(a.Values) == 0 || len(a.Values) > maxAttributeEnumValues {
+				return nil, "attribute_values_invalid"
+			}
+			vals := make(map[string]bool, len(a.Values))
+			for _, v := range a.Values {
+				if strings.TrimSpace(v) == "" || vals[v] {
+					return nil, "attribute_values_invalid"
+				}
+				vals[v] = true
+			}
+		default:
+			return nil, "attribute_type_invalid"
+		}
+	}
+	return m.Attributes, ""
+}
+
+// validateAnnotationAttributes 按类别的属性定义检查 data.attributes；类别未定义属性时不允许带属性
+func validateAnnotationAttributes(data map[string]interface{}, defs []categoryAttribute, add func(field, code string)) {
+	raw, present := data["attributes"]
+	var values map[string]interface{}
+	if present && raw != nil {
+		var ok bool
+		if values, ok = raw.(map[string]interface{}); !ok {
+			add("data.attributes", "invalid_attributes")
+			return
+		}
+	}
+	byName := make(map[string]categoryAttribute, len(defs))
+	for _, d := range defs {
+		byName[d.Name] = d
+		if _, ok := values[d.Name]; d.Required && !ok {
+			add("data.attributes."+d.Name, "missing_attribute")
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := "data.attributes." + name
+		d, ok := byName[name]
+		if !ok {
+			add(field, "unknown_attribute")
+			continue
+		}
+		switch v := values[name].(type) {
+		case bool:
+			if d.Type != attributeTypeBool {
+				add(field, "attribute_type_mismatch")
+			}
+		case string:
+			switch {
+			case d.Type == attributeTypeBool:
+				add(field, "attribute_type_mismatch")
+			case d.Type == attributeTypeEnum && !containsString(d.Values, v):
+				add(field, "attribute_value_invalid")
+			case len(v) > maxAttributeStringLen:
+				add(field, "attribute_too_long")
+			}
+		default:
+			add(field, "attribute_type_mismatch")
+		}
+	}
+}
+
+// mergeImportedAttributes 把导入类别 Meta 中的属性定义并入已有类别的 mate，同名属性保留已有定义；
+// 没有新属性，或任一方无法解析、合并后超出限制时 changed 为 false
+func mergeImportedAttributes(mate string, meta map[string]interface{}) (string, bool) {
+	raw, ok := meta["attributes"]
+	if !ok {
+		return mate, false
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return mate, false
+	}
+	var imported []categoryAttribute
+	if err := json.Unmarshal(b, &imported); err != nil || len(imported) == 0 {
+		return mate, false
+	}
+	existing, code := parseCategoryAttributes(mate)
+	if code != "" {
+		return mate, false
+	}
+	names := make(map[string]bool, len(existing))
+	for _, a := range existing {
+		names[a.Name] = true
+	}
+	merged := existing
+	for _, a := range imported {
+		if !names[a.Name] {
+			names[a.Name] = true
+			merged = append(merged, a)
+		}
+	}
+	if len(merged) == len(existing) {
+		return mate, false
+	}
+	out, err := setCategoryMateField(mate, "attributes", merged)
+	if err != nil {
+		return mate, false
+	}
+	if _, code := parseCategoryAttributes(out); code != "" {
+		return mate, false
+	}
+	return out, true
+}
+
+// setCategoryMateField 设置 mate 中的一个字段，保留其余字段；mate 为空时新建对象
+func setCategoryMateField(mate, key string, value interface{}) (string, error) {
+	m := map[string]interface{}{}
+	if s := strings.TrimSpace(mate); s != "" {
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return "", err
+		}
+		if m == nil {
+			m = map[string]interface{}{}
+		}
+	}
+	m[key] = value
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAttributeFilter 解析 attr.<name>=... 为作用于标注 a 的条件
+// 值为逗号分隔的候选，任一匹配即可；true/false 匹配布尔属性，false 也匹配所属类别定义了该布尔属性但未设置的标注；
+// * 表示设置了该属性
+func parseAttributeFilter(key, s string) (string, []interface{}, string) {
+	name := strings.TrimPrefix(key, attributeFilterPrefix)
+	if !validAttributeName(name) {
+		return "", nil, "attribute_invalid"
+	}
+	path := fmt.Sprintf(`$.attributes."%s"`, name)
+	if s == "*" {
+		return "a.data -> ? IS NOT NULL", []interface{}{path}, ""
+	}
+	var conds []string
+	var args []interface{}
+	var literals []interface{}
+	for _, val := range strings.Split(s, ",") {
+		if val = strings.TrimSpace(val); val == "" {
+			continue
+		}
+		// -> 返回 JSON 文本：布尔为 true/false，字符串带引号；"true" 同时匹配布尔和同名字符串
+		b, _ := json.Marshal(val)
+		literals = append(literals, string(b))
+		switch val {
+		case "true":
+			literals = append(literals, "true")
+		case "false":
+			literals = append(literals, "false")
+			conds = append(conds, "(a.data -> ? IS NULL AND a.category_id IN ("+sqlCategoriesWithBoolAttribute+"))")
+			args = append(args, path, name)
+		}
+	}
+	if len(literals) == 0 {
+		return "", nil, "attribute_invalid"
+	}
+	conds = append(conds, fmt.Sprintf("a.data -> ? IN (%s)", placeholders(len(literals))))
+	args = append(args, path)
+	args = append(args, literals...)
+	return "(" + strings.Join(conds, " OR ") + ")", args, ""
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCategoryAttributes(t *testing.T) {
+	tests := []struct {
+		name      string
+		mate      string
+		wantNames []string
+		wantErr   string
+	}{
+		{name: "empty mate", mate: ""},
+		{name: "legacy non-object mate", mate: `[1,2]`},
+		{name: "no attributes", mate: `{"keypoints":["a"]}`},
+		{
+			name:      "all types",
+			mate:      `{"attributes":[{"name":"occluded","type":"bool"},{"name":"color","type":"enum","values":["red","blue"]},{"name":"plate-no","type":"string","required":true}]}`,
+			wantNames: []string{"occluded", "color", "plate-no"},
+		},
+		{name: "invalid name", mate: `{"attributes":[{"name":"a b","type":"bool"}]}`, wantErr: "attribute_name_invalid"},
+		{name: "duplicate name", mate: `{"attributes":[{"name":"a","type":"bool"},{"name":"a","type":"string"}]}`, wantErr: "attribute_name_invalid"},
+		{name: "unknown type", mate: `{"attributes":[{"name":"a","type":"int"}]}`, wantErr: "attribute_type_invalid"},
+		{name: "enum without values", mate: `{"attributes":[{"name":"a","type":"enum"}]}`, wantErr: "attribute_values_invalid"},
+		{name: "enum with duplicate values", mate: `{"attributes":[{"name":"a","type":"enum","values":["x","x"]}]}`, wantErr: "attribute_values_invalid"},
+		{name: "enum with blank value", mate: `{"attributes":[{"name":"a","type":"enum","values":[" "]}]}`, wantErr: "attribute_values_invalid"},
+		{name: "bool with values", mate: `{"attributes":[{"name":"a","type":"bool","values":["x"]}]}`, wantErr: "attribute_values_invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, code := parseCategoryAttributes(tt.mate)
+			if code != tt.wantErr {
+				t.Fatalf("code = %q, want %q", code, tt.wantErr)
+			}
+			var names []string
+			for _, a := range attrs {
+				names = append(names, a.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestParseAttributeFilter(t *testing.T) {
+	db := openTestProjectDB(t)
+	setup := []string{
+		`INSERT INTO image_index (id, filename, original_rel_path, thumb_rel_path, created_at) VALUES (1, 'a', 'a', 'a', 't');`,
+		`INSERT INTO categories (id, name, type, color, sort_order, mate) VALUES
+			(1, 'car', 'bbox', '#ffffff', 0, '{"attributes":[{"name":"occluded","type":"bool"},{"name":"color","type":"enum","values":["red","blue"]}]}'),
+			(2, 'person', 'bbox', '#ffffff', 1, '{}'),
+			(3, 'legacy', 'bbox', '#ffffff', 2, 'not json');`,
+		`INSERT INTO annotations (id, image_id, category_id, type, data, created_at, updated_at) VALUES
+			(1, 1, 1, 'bbox', '{"attributes":{"occluded":true,"color":"red"}}', 't', 't'),
+			(2, 1, 1, 'bbox', '{"attributes":{"occluded":false}}', 't', 't'),
+			(3, 1, 1, 'bbox', '{}', 't', 't'),
+			(4, 1, 2, 'bbox', '{}', 't', 't'),
+			(5, 1, 3, 'bbox', '{}', 't', 't'),
+			(6, 1, 1, 'bbox', '{"attributes":{"occluded":"true"}}', 't', 't');`,
+	}
+	for _, q := range setup {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		want    []int64
+		wantErr string
+	}{
+		{name: "true matches bool and string", key: "attr.occluded", value: "true", want: []int64{1, 6}},
+		// 未设置按 false 处理只限定义了该布尔属性的类别
+		{name: "false matches unset in defining category", key: "attr.occluded", value: "false", want: []int64{2, 3}},
+		{name: "either value", key: "attr.occluded", value: "true, false", want: []int64{1, 2, 3, 6}},
+		{name: "any set value", key: "attr.occluded", value: "*", want: []int64{1, 2, 6}},
+		{name: "enum value", key: "attr.color", value: "red,blue", want: []int64{1}},
+		{name: "false on non-bool attribute", key: "attr.color", value: "false", want: nil},
+		{name: "invalid name", key: "attr.a b", value: "true", wantErr: "attribute_invalid"},
+		{name: "no values", key: "attr.occluded", value: " , ", wantErr: "attribute_invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args, code := parseAttributeFilter(tt.key, tt.value)
+			if code != tt.wantErr {
+				t.Fatalf("code = %q, want %q", code, tt.wantErr)
+			}
+			if code != "" {
+				return
+			}
+			rows, err := db.Query(`SELECT a.id FROM annotations a WHERE `+cond+` ORDER BY a.id;`, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []int64
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, id)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
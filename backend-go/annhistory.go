@@ -0,0 +1,847 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 标注变更日志：标注接口的写入，以及删除/合并类别、导入这类批量写入，都在同一事务中记录每条标注的前后状态，
+// 据此可以查看单张图片的历史、恢复到任意历史状态，或撤销最近的若干次变更（重启后依然有效）
+const (
+	annChangeSourceSave    = "save"
+	annChangeSourcePatch   = "patch"
+	annChangeSourceCreate  = "create"
+	annChangeSourceDelete  = "delete"
+	annChangeSourceRestore = "restore"
+	annChangeSourceUndo    = "undo"
+	// 以下为不经标注接口的批量写入
+	annChangeSourceCategoryDelete = "category_delete"
+	annChangeSourceCategoryMerge  = "category_merge"
+	annChangeSourceImport         = "import"
+
+	defaultAnnotationActor = "local"
+	maxAnnotationActorLen  = 64
+	defaultAnnHistoryLimit = 50
+	maxAnnHistoryLimit     = 500
+	maxAnnotationUndoCount = 100
+)
+
+// sqlInsertAnnotationByID 按原 ID 写回标注，保留 created_at
+const sqlInsertAnnotationByID = `INSERT OR REPLACE INTO annotations (id, image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?);`
+
+// errAnnotationUndoConflict 标注在目标变更之后又被日志以外的写入修改过，或要写回的类别已被删除，无法安全撤销
+var errAnnotationUndoConflict = errors.New("annotation changed outside the change log")
+
+// annotationChange 一次变更记录
+type annotationChange struct {
+	ID           int64                  `json:"id"`
+	ImageID      int64                  `json:"imageId"`
+	Actor        string                 `json:"actor"`
+	Source       string                 `json:"source"`
+	StatusBefore string                 `json:"statusBefore"`
+	StatusAfter  string                 `json:"statusAfter"`
+	Reverts      int64                  `json:"reverts,omitempty"`
+	UndoneBy     int64                  `json:"undoneBy,omitempty"`
+	Revision     int64                  `json:"revision"`
+	CreatedAt    string                 `json:"createdAt"`
+	Items        []annotationChangeItem `json:"items"`
+}
+
+// annotationChangeItem 单条标注的前后状态，nil 表示不存在（新建或删除）
+type annotationChangeItem struct {
+	AnnotationID int64           `json:"annotationId"`
+	Before       *AnnotationData `json:"before"`
+	After        *AnnotationData `json:"after"`
+}
+
+// normalizeAnnotationActor 去掉首尾空白并截断，空值记为 local
+func normalizeAnnotationActor(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return defaultAnnotationActor
+	}
+	if utf8.RuneCountInString(s) > maxAnnotationActorLen {
+		s = string([]rune(s)[:maxAnnotationActorLen])
+	}
+	return s
+}
+
+// annotationStatusFor 根据标注数得到图片状态；没有标注时沿用 negative 标记
+func annotationStatusFor(count int, fallback string) string {
+	if count > 0 {
+		return "annotated"
+	}
+	if fallback == "negative" {
+		return "negative"
+	}
+	return "none"
+}
+
+// loadImageAnnotationsTx 在事务内读取图片当前的全部标注，按 ID 索引
+func loadImageAnnotationsTx(tx *sql.Tx, imageID int64) (map[int64]AnnotationData, error) {
+	rows, err := tx.Query(sqlAnnotationsByImage, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	anns := map[int64]AnnotationData{}
+	for rows.Next() {
+		var a AnnotationData
+		if err := rows.Scan(&a.ID, &a.ImageID, &a.CategoryID, &a.Type, &a.Data, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		anns[a.ID] = a
+	}
+	return anns, rows.Err()
+}
+
+// readImageAnnotationStatus 读取图片的标注状态和是否已移入回收站
+func readImageAnnotationStatus(tx *sql.Tx, imageID int64) (string, bool, error) {
+	var status string
+	var deleted int
+	err := tx.QueryRow(`SELECT COALESCE(annotation_status, 'none'), deleted_in_project FROM image_index WHERE id = ?;`, imageID).Scan(&status, &deleted)
+	return status, deleted != 0, err
+}
+
+// recordAnnotationChange 比较前后状态并写入变更记录，同时把图片的 revision 加一；
+// 标注和状态都没变时不记录，返回 0
+func recordAnnotationChange(tx *sql.Tx, imageID int64, actor, source, statusBefore, statusAfter string, before, after map[int64]AnnotationData, reverts int64) (int64, error) {
+	var items []annotationChangeItem
+	for id, b := range before {
+		b := b
+		if a, ok := after[id]; ok {
+			if a == b {
+				continue
+			}
+			a := a
+			items = append(items, annotationChangeItem{AnnotationID: id, Before: &b, After: &a})
+		} else {
+			items = append(items, annotationChangeItem{AnnotationID: id, Before: &b})
+		}
+	}
+	for id, a := range after {
+		if _, ok := before[id]; ok {
+			continue
+		}
+		a := a
+		items = append(items, annotationChangeItem{AnnotationID: id, After: &a})
+	}
+	if len(items) == 0 && statusBefore == statusAfter {
+		return 0, nil
+	}
+
+	revision, err := bumpAnnotationRevision(tx, imageID)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := tx.Exec(`INSERT INTO annotation_changes (image_id, actor, source, status_before, status_after, reverts, revision, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`,
+		imageID, actor, source, statusBefore, statusAfter, reverts, revision, now)
+	if err != nil {
+		return 0, err
+	}
+	changeID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		beforeJSON, err := marshalAnnotationSnapshot(item.Before)
+		if err != nil {
+			return 0, err
+		}
+		afterJSON, err := marshalAnnotationSnapshot(item.After)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`INSERT INTO annotation_change_items (change_id, annotation_id, before_json, after_json) VALUES (?, ?, ?, ?);`,
+			changeID, item.AnnotationID, beforeJSON, afterJSON); err != nil {
+			return 0, err
+		}
+	}
+	return changeID, nil
+}
+
+// annotationChangeBatch 批量写入（删除、合并类别，导入）的变更日志：
+// 写入前用 capture 记下受影响图片的标注和状态，写入后 record 逐张图片记录变更
+type annotationChangeBatch struct {
+	source string
+	order  []int64
+	before map[int64]map[int64]AnnotationData
+	status map[int64]string
+}
+
+func newAnnotationChangeBatch(source string) *annotationChangeBatch {
+	return &annotationChangeBatch{
+		source: source,
+		before: map[int64]map[int64]AnnotationData{},
+		status: map[int64]string{},
+	}
+}
+
+// capture 记下图片写入前的状态，同一图片只记第一次
+func (b *annotationChangeBatch) capture(tx *sql.Tx, imageID int64) error {
+	if _, ok := b.before[imageID]; ok {
+		return nil
+	}
+	anns, err := loadImageAnnotationsTx(tx, imageID)
+	if err != nil {
+		return err
+	}
+	status, _, err := readImageAnnotationStatus(tx, imageID)
+	if err != nil {
+		return err
+	}
+	b.before[imageID] = anns
+	b.status[imageID] = status
+	b.order = append(b.order, imageID)
+	return nil
+}
+
+// captureQuery 对查询返回的每个图片 ID 调用 capture
+func (b *annotationChangeBatch) captureQuery(tx *sql.Tx, query string, args ...interface{}) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := b.capture(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record 读取写入后的状态并逐张记录变更，revision 随之加一
+func (b *annotationChangeBatch) record(tx *sql.Tx) error {
+	for _, imageID := range b.order {
+		after, err := loadImageAnnotationsTx(tx, imageID)
+		if err != nil {
+			return err
+		}
+		status, _, err := readImageAnnotationStatus(tx, imageID)
+		if err != nil {
+			return err
+		}
+		if _, err := recordAnnotationChange(tx, imageID, defaultAnnotationActor, b.source, b.status[imageID], status, b.before[imageID], after, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalAnnotationSnapshot nil 存为 SQL NULL
+func marshalAnnotationSnapshot(a *AnnotationData) (sql.NullString, error) {
+	if a == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// unmarshalAnnotationSnapshot SQL NULL 还原为 nil
+func unmarshalAnnotationSnapshot(s sql.NullString) (*AnnotationData, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var a AnnotationData
+	if err := json.Unmarshal([]byte(s.String), &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// loadAnnotationChangeItems 读取一次变更的全部条目
+func loadAnnotationChangeItems(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, changeID int64) ([]annotationChangeItem, error) {
+	rows, err := q.Query(`SELECT annotation_id, before_json, after_json FROM annotation_change_items WHERE change_id = ? ORDER BY annotation_id;`, changeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []annotationChangeItem{}
+	for rows.Next() {
+		var item annotationChangeItem
+		var beforeJSON, afterJSON sql.NullString
+		if err := rows.Scan(&item.AnnotationID, &beforeJSON, &afterJSON); err != nil {
+			return nil, err
+		}
+		if item.Before, err = unmarshalAnnotationSnapshot(beforeJSON); err != nil {
+			return nil, err
+		}
+		if item.After, err = unmarshalAnnotationSnapshot(afterJSON); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// applyAnnotationState 把图片的标注改写为 target：删除多余的，按原 ID 写回缺失或不同的
+func applyAnnotationState(tx *sql.Tx, imageID int64, current, target map[int64]AnnotationData, status string) error {
+	for id := range current {
+		if _, ok := target[id]; ok {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM annotations WHERE id = ?;`, id); err != nil {
+			return err
+		}
+	}
+	for id, a := range target {
+		if c, ok := current[id]; ok && c == a {
+			continue
+		}
+		if _, err := tx.Exec(sqlInsertAnnotationByID, id, imageID, a.CategoryID, a.Type, a.Data, a.CreatedAt, a.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(sqlUpdateImageAnnState, status, imageID)
+	return err
+}
+
+// annotationStateAt 重建图片在变更 changeID 之后（after=true）或之前的标注和状态
+// 每条标注取截止点前最后一次记录的 after，截止点前没有记录的取之后第一次记录的 before；
+// 从未出现在日志中的标注自那以后没有变过，直接取当前值
+func annotationStateAt(tx *sql.Tx, imageID, changeID int64, after bool) (map[int64]AnnotationData, string, error) {
+	var statusBefore, statusAfter string
+	err := tx.QueryRow(`SELECT status_before, status_after FROM annotation_changes WHERE id = ? AND image_id = ?;`, changeID, imageID).Scan(&statusBefore, &statusAfter)
+	if err != nil {
+		return nil, "", err
+	}
+	cut := changeID
+	status := statusAfter
+	if !after {
+		cut = changeID - 1
+		status = statusBefore
+	}
+
+	current, err := loadImageAnnotationsTx(tx, imageID)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := tx.Query(`SELECT c.id, i.annotation_id, i.before_json, i.after_json
+FROM annotation_change_items i JOIN annotation_changes c ON c.id = i.change_id
+WHERE c.image_id = ? ORDER BY c.id ASC;`, imageID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	type snapshotAt struct {
+		value *AnnotationData
+		set   bool
+	}
+	resolved := map[int64]snapshotAt{}
+	for rows.Next() {
+		var id, annID int64
+		var beforeJSON, afterJSON sql.NullString
+		if err := rows.Scan(&id, &annID, &beforeJSON, &afterJSON); err != nil {
+			return nil, "", err
+		}
+		if id <= cut {
+			a, err := unmarshalAnnotationSnapshot(afterJSON)
+			if err != nil {
+				return nil, "", err
+			}
+			resolved[annID] = snapshotAt{value: a, set: true}
+			continue
+		}
+		if _, ok := resolved[annID]; ok {
+			continue
+		}
+		b, err := unmarshalAnnotationSnapshot(beforeJSON)
+		if err != nil {
+			return nil, "", err
+		}
+		resolved[annID] = snapshotAt{value: b, set: true}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	state := map[int64]AnnotationData{}
+	for id, a := range current {
+		if _, ok := resolved[id]; !ok {
+			state[id] = a
+		}
+	}
+	for id, s := range resolved {
+		if s.value != nil {
+			state[id] = *s.value
+		}
+	}
+	return state, annotationStatusFor(len(state), status), nil
+}
+
+// undoAnnotationChange 撤销一次变更并记录对应的 undo 变更，返回新变更 ID
+func undoAnnotationChange(tx *sql.Tx, changeID int64, actor string) (int64, error) {
+	var imageID int64
+	var statusBefore string
+	err := tx.QueryRow(`SELECT image_id, status_before FROM annotation_changes WHERE id = ?;`, changeID).Scan(&imageID, &statusBefore)
+	if err != nil {
+		return 0, err
+	}
+	currentStatus, deleted, err := readImageAnnotationStatus(tx, imageID)
+	if err != nil {
+		return 0, err
+	}
+	if deleted {
+		return 0, errAnnotationUndoConflict
+	}
+	items, err := loadAnnotationChangeItems(tx, changeID)
+	if err != nil {
+		return 0, err
+	}
+	current, err := loadImageAnnotationsTx(tx, imageID)
+	if err != nil {
+		return 0, err
+	}
+
+	target := make(map[int64]AnnotationData, len(current))
+	for id, a := range current {
+		target[id] = a
+	}
+	for _, item := range items {
+		c, exists := current[item.AnnotationID]
+		if (item.After == nil) != !exists || (item.After != nil && *item.After != c) {
+			return 0, errAnnotationUndoConflict
+		}
+		if item.Before == nil {
+			delete(target, item.AnnotationID)
+		} else {
+			target[item.AnnotationID] = *item.Before
+		}
+	}
+	// 撤销是整条变更的逆操作，不能像恢复那样跳过部分标注
+	skipped, err := skipMissingCategoryAnnotations(tx, current, target)
+	if err != nil {
+		return 0, err
+	}
+	if len(skipped) > 0 {
+		return 0, errAnnotationUndoConflict
+	}
+
+	status := annotationStatusFor(len(target), statusBefore)
+	if err := applyAnnotationState(tx, imageID, current, target, status); err != nil {
+		return 0, err
+	}
+	undoID, err := recordAnnotationChange(tx, imageID, actor, annChangeSourceUndo, currentStatus, status, current, target, changeID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE annotation_changes SET undone_by = ? WHERE id = ?;`, undoID, changeID); err != nil {
+		return 0, err
+	}
+	return undoID, nil
+}
+
+// handleAnnotationHistory 按时间倒序列出图片的标注变更
+// GET ?projectId=&imageId=&limit=&beforeId=（beforeId 用于向前翻页）
+func handleAnnotationHistory(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	projectID := strings.TrimSpace(q.Get("projectId"))
+	imageID, err := strconv.ParseInt(strings.TrimSpace(q.Get("imageId")), 10, 64)
+	if projectID == "" || err != nil || imageID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_and_image_required"}`))
+		return
+	}
+	limit := defaultAnnHistoryLimit
+	if s := q.Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if limit > maxAnnHistoryLimit {
+		limit = maxAnnHistoryLimit
+	}
+	var beforeID int64
+	if s := q.Get("beforeId"); s != "" {
+		beforeID, _ = strconv.ParseInt(s, 10, 64)
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+	dbPath := filepath.Join(cfg.DataPath, "project_item", projectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	query := `SELECT id, image_id, actor, source, status_before, status_after, reverts, undone_by, revision, created_at FROM annotation_changes WHERE image_id = ?`
+	args := []interface{}{imageID}
+	if beforeID > 0 {
+		query += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?;`
+	args = append(args, limit+1)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("annotation history: query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	changes := []annotationChange{}
+	for rows.Next() {
+		var c annotationChange
+		if err := rows.Scan(&c.ID, &c.ImageID, &c.Actor, &c.Source, &c.StatusBefore, &c.StatusAfter, &c.Reverts, &c.UndoneBy, &c.Revision, &c.CreatedAt); err == nil {
+			changes = append(changes, c)
+		}
+	}
+	rows.Close()
+
+	hasMore := len(changes) > limit
+	if hasMore {
+		changes = changes[:limit]
+	}
+	for i := range changes {
+		items, err := loadAnnotationChangeItems(db, changes[i].ID)
+		if err != nil {
+			log.Printf("annotation history: items of change %d failed: %v", changes[i].ID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+			return
+		}
+		changes[i].Items = items
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"changes": changes, "hasMore": hasMore})
+}
+
+// restoreAnnotationsRequest 把图片恢复到某次变更之后（或 before=true 时之前）的状态
+type restoreAnnotationsRequest struct {
+	ProjectID string `json:"projectId"`
+	ImageID   int64  `json:"imageId"`
+	ChangeID  int64  `json:"changeId"`
+	Before    bool   `json:"before"`
+	Actor     string `json:"actor"`
+}
+
+// handleRestoreAnnotations 恢复图片的历史标注状态，恢复本身也记为一次变更；类别已删除的标注不恢复，ID 列在 skipped 中
+func handleRestoreAnnotations(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req restoreAnnotationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+		return
+	}
+	if req.ProjectID == "" || req.ImageID <= 0 || req.ChangeID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_image_and_change_required"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_begin_failed"}`))
+		return
+	}
+	defer tx.Rollback()
+
+	currentStatus, deleted, err := readImageAnnotationStatus(tx, req.ImageID)
+	if err != nil || deleted {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"image_not_found"}`))
+		return
+	}
+	target, status, err := annotationStateAt(tx, req.ImageID, req.ChangeID, !req.Before)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"change_not_found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("restore annotations: rebuild state failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	current, err := loadImageAnnotationsTx(tx, req.ImageID)
+	var skipped []int64
+	if err == nil {
+		skipped, err = skipMissingCategoryAnnotations(tx, current, target)
+		status = annotationStatusFor(len(target), status)
+	}
+	if err == nil {
+		err = applyAnnotationState(tx, req.ImageID, current, target, status)
+	}
+	var changeID, revision int64
+	if err == nil {
+		changeID, err = recordAnnotationChange(tx, req.ImageID, normalizeAnnotationActor(req.Actor), annChangeSourceRestore, currentStatus, status, current, target, 0)
+	}
+	if err == nil {
+		revision, err = readAnnotationRevision(tx, req.ImageID)
+	}
+	if err != nil {
+		log.Printf("restore annotations: apply failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"update_failed"}`))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
+		return
+	}
+	go refreshProjectCounts(cfg.DataPath, req.ProjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"changeId":    changeID,
+		"status":      status,
+		"revision":    revision,
+		"annotations": sortedAnnotations(target),
+		"skipped":     skipped,
+	})
+}
+
+// skipMissingCategoryAnnotations 历史状态中类别已被删除的标注不恢复：当前存在的保持原样，否则不写回；
+// 返回被跳过的标注 ID
+func skipMissingCategoryAnnotations(tx *sql.Tx, current, target map[int64]AnnotationData) ([]int64, error) {
+	exists := map[int64]bool{}
+	skipped := []int64{}
+	for id, a := range target {
+		ok, checked := exists[a.CategoryID]
+		if !checked {
+			var n int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM categories WHERE id = ?;`, a.CategoryID).Scan(&n); err != nil {
+				return nil, err
+			}
+			ok = n > 0
+			exists[a.CategoryID] = ok
+		}
+		if ok {
+			continue
+		}
+		skipped = append(skipped, id)
+		if c, found := current[id]; found {
+			target[id] = c
+		} else {
+			delete(target, id)
+		}
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i] < skipped[j] })
+	return skipped, nil
+}
+
+// undoAnnotationsRequest 撤销最近 count 次尚未撤销的变更；给出 imageId 时只看该图片
+type undoAnnotationsRequest struct {
+	ProjectID string `json:"projectId"`
+	ImageID   int64  `json:"imageId"`
+	Count     int    `json:"count"`
+	Actor     string `json:"actor"`
+}
+
+// handleUndoAnnotations 按从新到旧撤销变更；undo 记录本身不会被再次撤销，
+// 因此连续调用会依次回退更早的变更。任一变更无法撤销时整批回滚并返回 409
+func handleUndoAnnotations(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req undoAnnotationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+		return
+	}
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	if req.Count <= 0 {
+		req.Count = 1
+	}
+	if req.Count > maxAnnotationUndoCount {
+		req.Count = maxAnnotationUndoCount
+	}
+	actor := normalizeAnnotationActor(req.Actor)
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_begin_failed"}`))
+		return
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id FROM annotation_changes WHERE undone_by = 0 AND source != ?`
+	args := []interface{}{annChangeSourceUndo}
+	if req.ImageID > 0 {
+		query += ` AND image_id = ?`
+		args = append(args, req.ImageID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?;`
+	args = append(args, req.Count)
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		log.Printf("undo annotations: query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	var targets []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			targets = append(targets, id)
+		}
+	}
+	rows.Close()
+
+	undone := make([]int64, 0, len(targets))
+	undoChanges := make([]int64, 0, len(targets))
+	for _, changeID := range targets {
+		undoID, err := undoAnnotationChange(tx, changeID, actor)
+		if errors.Is(err, errAnnotationUndoConflict) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": "undo_conflict", "changeId": changeID})
+			return
+		}
+		if err != nil {
+			log.Printf("undo annotations: change %d failed: %v", changeID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"update_failed"}`))
+			return
+		}
+		undone = append(undone, changeID)
+		undoChanges = append(undoChanges, undoID)
+	}
+	if err := tx.Commit(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
+		return
+	}
+	if len(undone) > 0 {
+		go refreshProjectCounts(cfg.DataPath, req.ProjectID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "undone": undone, "changes": undoChanges})
+}
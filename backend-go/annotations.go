@@ -16,6 +16,8 @@ type CreateAnnotationRequest struct {
 	CategoryID int64       `json:"categoryId"`
 	Type       string      `json:"type"`
 	Data       interface{} `json:"data"`
+	Actor      string      `json:"actor,omitempty"`
+	Validation string      `json:"validation,omitempty"` // strict（默认）或 lenient
 }
 
 // handleAnnotations 处理单条标注的增删
@@ -25,6 +27,12 @@ func handleAnnotations(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if r.Method == http.MethodDelete || r.Method == http.MethodPost {
+		if !beginProjectWrite(w) {
+			return
+		}
+		defer endProjectWrite()
+	}
 
 	// DELETE /api/annotations/{id}
 	if r.Method == http.MethodDelete {
@@ -63,27 +71,69 @@ func handleAnnotations(w http.ResponseWriter, r *http.Request) {
 
 		projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
 		dbPath := filepath.Join(projectRoot, "db", "project.db")
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 			return
 		}
-		defer db.Close()
+		defer db.Release()
+
+		tx, err := db.Begin()
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"tx_begin_failed"}`))
+			return
+		}
+		defer tx.Rollback()
 
 		// 获取标注对应的 imageId 用于更新状态
 		var imageID int64
-		err = db.QueryRow(`SELECT image_id FROM annotations WHERE id = ?`, annID).Scan(&imageID)
+		err = tx.QueryRow(`SELECT image_id FROM annotations WHERE id = ?`, annID).Scan(&imageID)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusNotFound)
 			_, _ = w.Write([]byte(`{"error":"annotation_not_found"}`))
 			return
 		}
+		before, err := loadImageAnnotationsTx(tx, imageID)
+		var oldStatus string
+		if err == nil {
+			oldStatus, _, err = readImageAnnotationStatus(tx, imageID)
+		}
+		var oldRevision int64
+		if err == nil {
+			oldRevision, err = readAnnotationRevision(tx, imageID)
+		}
+		// 可选的 revision：过期时只要这条标注没被别人改过仍然可以删除
+		var conflicts []int64
+		mergeable := true
+		if s := r.URL.Query().Get("revision"); s != "" && err == nil {
+			baseRevision, perr := strconv.ParseInt(s, 10, 64)
+			if perr != nil {
+				mergeable = false
+			} else {
+				_, conflicts, mergeable, err = mergeAnnotationEdits(tx, imageID, baseRevision, oldRevision, before, func(map[int64]AnnotationData) annotationPatch {
+					return annotationPatch{Delete: []int64{annID}}
+				})
+			}
+		}
+		if err != nil {
+			log.Printf("delete annotation: load previous state failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+			return
+		}
+		if !mergeable || len(conflicts) > 0 {
+			writeAnnotationRevisionConflict(w, oldRevision, oldStatus, before, conflicts)
+			return
+		}
 
 		// 删除标注
-		_, err = db.Exec(`DELETE FROM annotations WHERE id = ?`, annID)
+		_, err = tx.Exec(`DELETE FROM annotations WHERE id = ?`, annID)
 		if err != nil {
 			log.Printf("delete annotation failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -93,15 +143,41 @@ func handleAnnotations(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 更新图片状态
-		var count int
-		db.QueryRow(`SELECT COUNT(*) FROM annotations WHERE image_id = ?`, imageID).Scan(&count)
-		if count == 0 {
-			db.Exec(`UPDATE image_index SET annotation_status = 'none' WHERE id = ?`, imageID)
+		after := make(map[int64]AnnotationData, len(before))
+		for id, a := range before {
+			if id != annID {
+				after[id] = a
+			}
+		}
+		newStatus := oldStatus
+		if len(after) == 0 {
+			newStatus = "none"
+			tx.Exec(`UPDATE image_index SET annotation_status = 'none' WHERE id = ?`, imageID)
+		}
+
+		actor := normalizeAnnotationActor(r.URL.Query().Get("actor"))
+		_, err = recordAnnotationChange(tx, imageID, actor, annChangeSourceDelete, oldStatus, newStatus, before, after, 0)
+		var newRevision int64
+		if err == nil {
+			newRevision, err = readAnnotationRevision(tx, imageID)
+		}
+		if err != nil {
+			log.Printf("delete annotation: record change failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"history_failed"}`))
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"success":true}`))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "revision": newRevision})
 		return
 	}
 
@@ -142,14 +218,14 @@ func handleAnnotations(w http.ResponseWriter, r *http.Request) {
 
 		projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
 		dbPath := filepath.Join(projectRoot, "db", "project.db")
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 			return
 		}
-		defer db.Close()
+		defer db.Release()
 
 		// 序列化 data
 		dataJSON := "{}"
@@ -159,8 +235,42 @@ func handleAnnotations(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		tx, err := db.Begin()
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"tx_begin_failed"}`))
+			return
+		}
+		defer tx.Rollback()
+
+		before, err := loadImageAnnotationsTx(tx, req.ImageID)
+		var oldStatus string
+		if err == nil {
+			oldStatus, _, err = readImageAnnotationStatus(tx, req.ImageID)
+		}
+		var cats map[int64]annotationCategoryInfo
+		if err == nil {
+			cats, err = loadAnnotationCategories(tx)
+		}
+		if err != nil {
+			log.Printf("create annotation: load previous state failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+			return
+		}
+		var warnings []annotationValidationResult
+		if issues := validateAnnotation(AnnotationData{CategoryID: req.CategoryID, Type: req.Type, Data: dataJSON}, cats); len(issues) > 0 {
+			warnings = []annotationValidationResult{{Issues: issues}}
+			if normalizeAnnotationValidation(req.Validation) == annotationValidationStrict {
+				writeInvalidAnnotations(w, warnings)
+				return
+			}
+		}
+
 		now := time.Now().UTC().Format(time.RFC3339)
-		result, err := db.Exec(`INSERT INTO annotations (image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		result, err := tx.Exec(`INSERT INTO annotations (image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
 			req.ImageID, req.CategoryID, req.Type, dataJSON, now, now)
 		if err != nil {
 			log.Printf("create annotation failed: %v", err)
@@ -173,11 +283,35 @@ func handleAnnotations(w http.ResponseWriter, r *http.Request) {
 		newID, _ := result.LastInsertId()
 
 		// 更新图片状态为 annotated
-		db.Exec(`UPDATE image_index SET annotation_status = 'annotated' WHERE id = ?`, req.ImageID)
+		tx.Exec(`UPDATE image_index SET annotation_status = 'annotated' WHERE id = ?`, req.ImageID)
+
+		after := make(map[int64]AnnotationData, len(before)+1)
+		for id, a := range before {
+			after[id] = a
+		}
+		after[newID] = AnnotationData{ID: newID, ImageID: req.ImageID, CategoryID: req.CategoryID, Type: req.Type, Data: dataJSON, CreatedAt: now, UpdatedAt: now}
+		_, err = recordAnnotationChange(tx, req.ImageID, normalizeAnnotationActor(req.Actor), annChangeSourceCreate, oldStatus, "annotated", before, after, 0)
+		var newRevision int64
+		if err == nil {
+			newRevision, err = readAnnotationRevision(tx, req.ImageID)
+		}
+		if err != nil {
+			log.Printf("create annotation: record change failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"history_failed"}`))
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": newID})
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": newID, "revision": newRevision, "warnings": warnings})
 		return
 	}
 
@@ -193,13 +327,13 @@ func findProjectByImageID(dataPath string, imageID int64) (string, error) {
 	}
 
 	for _, dbPath := range entries {
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			continue
 		}
 		var count int
 		err = db.QueryRow(`SELECT COUNT(*) FROM image_index WHERE id = ?`, imageID).Scan(&count)
-		db.Close()
+		db.Release()
 		if err == nil && count > 0 {
 			// 提取项目ID
 			projectRoot := filepath.Dir(filepath.Dir(dbPath))
@@ -225,6 +359,9 @@ type SaveAnnotationsRequest struct {
 	ProjectID   string `json:"projectId"`
 	ImageID     int64  `json:"imageId"`
 	IsNegative  bool   `json:"isNegative"`
+	Revision    *int64 `json:"revision"`
+	Actor       string `json:"actor,omitempty"`
+	Validation  string `json:"validation,omitempty"` // strict（默认）或 lenient
 	Annotations []struct {
 		ID         string `json:"id,omitempty"`
 		CategoryID int64  `json:"categoryId"`
@@ -268,16 +405,33 @@ func handleProjectAnnotations(w http.ResponseWriter, r *http.Request) {
 
 		projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
 		dbPath := filepath.Join(projectRoot, "db", "project.db")
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 			return
 		}
-		defer db.Close()
+		defer db.Release()
+
+		// revision 先于标注读取：中间有写入时客户端拿到的是旧 revision，保存时会被发现
+		revision, err := readAnnotationRevision(db, imageID)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"image_not_found"}`))
+			return
+		}
 
-		rows, err := db.Query(`SELECT id, image_id, category_id, type, data, created_at, updated_at FROM annotations WHERE image_id = ?;`, imageID)
+		stmt, err := db.Prepared(sqlAnnotationsByImage)
+		if err != nil {
+			log.Printf("annotations get: prepare failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+			return
+		}
+		rows, err := stmt.Query(imageID)
 		if err != nil {
 			log.Printf("annotations get: query failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -298,14 +452,16 @@ func handleProjectAnnotations(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{"annotations": annotations})
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"annotations": annotations, "revision": revision})
 		return
 	}
 
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
 
-// handleSaveAnnotations 保存标注
+// handleSaveAnnotations 保存图片的全部标注；id 能对应到已保存标注（"12" 或 "db_12"）的原地更新，
+// 响应中的 ids 给出新建标注的请求 id 到服务端 ID 的映射。
+// 必须带上读取时拿到的 revision，过期且无法自动合并时返回 409
 func handleSaveAnnotations(w http.ResponseWriter, r *http.Request) {
 	withCORS(w)
 	if r.Method == http.MethodOptions {
@@ -316,6 +472,10 @@ func handleSaveAnnotations(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 
 	var req SaveAnnotationsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -331,6 +491,12 @@ func handleSaveAnnotations(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"project_and_image_required"}`))
 		return
 	}
+	if req.Revision == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		_, _ = w.Write([]byte(`{"error":"revision_required"}`))
+		return
+	}
 
 	cfg, err := loadPathsConfig()
 	if err != nil {
@@ -342,14 +508,14 @@ func handleSaveAnnotations(w http.ResponseWriter, r *http.Request) {
 
 	projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
 	dbPath := filepath.Join(projectRoot, "db", "project.db")
-	db, err := openProjectDB(dbPath)
+	db, err := acquireProjectDB(dbPath)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
-	defer db.Close()
+	defer db.Release()
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -360,50 +526,113 @@ func handleSaveAnnotations(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(`DELETE FROM annotations WHERE image_id = ?;`, req.ImageID)
+	stmts, err := prepareAnnotationWrites(db, tx)
 	if err != nil {
-		log.Printf("save annotations: delete old failed: %v", err)
+		log.Printf("save annotations: prepare failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"delete_failed"}`))
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
 
-	now := time.Now().UTC().Format(time.RFC3339)
+	// 变更前的状态用于计算差异和写入变更日志
+	before, err := loadImageAnnotationsTx(tx, req.ImageID)
+	var oldStatus string
+	var oldRevision int64
+	if err == nil {
+		oldStatus, _, err = readImageAnnotationStatus(tx, req.ImageID)
+	}
+	if err == nil {
+		oldRevision, err = readAnnotationRevision(tx, req.ImageID)
+	}
+	var patch annotationPatch
+	var conflicts []int64
+	mergeable := false
+	if err == nil {
+		patch, conflicts, mergeable, err = mergeAnnotationEdits(tx, req.ImageID, *req.Revision, oldRevision, before, func(base map[int64]AnnotationData) annotationPatch {
+			return annotationPatchFromFullSave(req, base)
+		})
+	}
+	if err != nil {
+		log.Printf("save annotations: load previous state failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	if !mergeable || len(conflicts) > 0 {
+		writeAnnotationRevisionConflict(w, oldRevision, oldStatus, before, conflicts)
+		return
+	}
 
-	for _, ann := range req.Annotations {
-		_, err = tx.Exec(`INSERT INTO annotations (image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?);`,
-			req.ImageID, ann.CategoryID, ann.Type, ann.Data, now, now)
-		if err != nil {
-			log.Printf("save annotations: insert failed: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"insert_failed"}`))
-			return
-		}
+	// 按差异写入，已有标注保留 ID 和 created_at
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := applyAnnotationPatch(stmts, req.ImageID, before, patch, now)
+	if err != nil {
+		log.Printf("save annotations: write failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"insert_failed"}`))
+		return
+	}
+	warnings, err := validateWrittenAnnotations(tx, res)
+	if err != nil {
+		log.Printf("save annotations: load categories failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	if len(warnings) > 0 && normalizeAnnotationValidation(req.Validation) == annotationValidationStrict {
+		writeInvalidAnnotations(w, warnings)
+		return
 	}
 
 	var newStatus string
-	if len(req.Annotations) > 0 {
+	if len(res.After) > 0 {
 		newStatus = "annotated"
 	} else if req.IsNegative {
 		newStatus = "negative"
 	} else {
 		newStatus = "none"
 	}
-	_, err = tx.Exec(`UPDATE image_index SET annotation_status = ? WHERE id = ?;`, newStatus, req.ImageID)
+	_, err = stmts.status.Exec(newStatus, req.ImageID)
 	if err != nil {
 		log.Printf("save annotations: update status failed: %v", err)
 	}
 
+	if _, err := recordAnnotationChange(tx, req.ImageID, normalizeAnnotationActor(req.Actor), annChangeSourceSave, oldStatus, newStatus, before, res.After, 0); err != nil {
+		log.Printf("save annotations: record change failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"history_failed"}`))
+		return
+	}
+	newRevision, err := readAnnotationRevision(tx, req.ImageID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
 		return
 	}
+	// 项目列表中的缓存计数在后台刷新，不拖慢保存
+	go refreshProjectCounts(cfg.DataPath, req.ProjectID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"success":true,"status":"` + newStatus + `"}`))
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"status":   newStatus,
+		"ids":      res.IDs,
+		"revision": newRevision,
+		"merged":   *req.Revision != oldRevision,
+		"warnings": warnings,
+	})
 }
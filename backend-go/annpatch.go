@@ -0,0 +1,409 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 标注按差异写入：新建、按 ID 更新、删除三类操作在同一事务内完成，
+// 已有标注的 ID 和 created_at 保持不变，只有内容真正变化的行才更新 updated_at
+
+// annotationPatchCreate 新建标注；clientId 由调用方生成，用于在响应中取回服务端 ID，省略时用其在 create 中的下标
+type annotationPatchCreate struct {
+	ClientID   string `json:"clientId"`
+	CategoryID int64  `json:"categoryId"`
+	Type       string `json:"type"`
+	Data       string `json:"data"`
+}
+
+// annotationPatchUpdate 更新已有标注；零值字段（categoryId 为 0、type 或 data 为空）保持原值
+type annotationPatchUpdate struct {
+	ID         int64  `json:"id"`
+	CategoryID int64  `json:"categoryId"`
+	Type       string `json:"type"`
+	Data       string `json:"data"`
+}
+
+// annotationPatch 一次差异写入
+type annotationPatch struct {
+	Create []annotationPatchCreate `json:"create"`
+	Update []annotationPatchUpdate `json:"update"`
+	Delete []int64                 `json:"delete"`
+}
+
+// PatchAnnotationsRequest 差异保存请求；isNegative 省略时没有标注的图片沿用原来的负样本标记
+type PatchAnnotationsRequest struct {
+	ProjectID  string `json:"projectId"`
+	ImageID    int64  `json:"imageId"`
+	IsNegative *bool  `json:"isNegative,omitempty"`
+	Revision   *int64 `json:"revision"`
+	Actor      string `json:"actor,omitempty"`
+	Validation string `json:"validation,omitempty"` // strict（默认）或 lenient
+	annotationPatch
+}
+
+// annotationPatchResult 写入后的状态
+type annotationPatchResult struct {
+	After   map[int64]AnnotationData
+	IDs     map[string]int64
+	Created []int64
+	Updated []int64
+	Deleted []int64
+}
+
+// annotationWriteStmts 绑定到事务的标注写入语句
+type annotationWriteStmts struct {
+	insert *sql.Stmt
+	update *sql.Stmt
+	delete *sql.Stmt
+	status *sql.Stmt
+}
+
+// prepareAnnotationWrites 取缓存的预编译语句并绑定到事务
+func prepareAnnotationWrites(db *projectDBHandle, tx *sql.Tx) (*annotationWriteStmts, error) {
+	var stmts [4]*sql.Stmt
+	for i, query := range []string{sqlInsertAnnotation, sqlUpdateAnnotation, sqlDeleteAnnotation, sqlUpdateImageAnnState} {
+		stmt, err := db.Prepared(query)
+		if err != nil {
+			return nil, err
+		}
+		stmts[i] = tx.Stmt(stmt)
+	}
+	return &annotationWriteStmts{insert: stmts[0], update: stmts[1], delete: stmts[2], status: stmts[3]}, nil
+}
+
+// parseAnnotationRef 解析前端传回的标注 ID（"12" 或 "db_12"），不是已保存的 ID 时返回 false
+func parseAnnotationRef(s string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(s), "db_"), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// annotationPatchFromFullSave 把整图保存转换为相对 current 的差异：能对应到现有标注且内容有变化的
+// 按原 ID 更新，对应不上的新建，请求中没有出现的现有标注删除
+func annotationPatchFromFullSave(req SaveAnnotationsRequest, current map[int64]AnnotationData) annotationPatch {
+	var p annotationPatch
+	kept := map[int64]bool{}
+	for i, ann := range req.Annotations {
+		if id, ok := parseAnnotationRef(ann.ID); ok && !kept[id] {
+			if c, exists := current[id]; exists {
+				kept[id] = true
+				if c.CategoryID != ann.CategoryID || c.Type != ann.Type || c.Data != ann.Data {
+					p.Update = append(p.Update, annotationPatchUpdate{ID: id, CategoryID: ann.CategoryID, Type: ann.Type, Data: ann.Data})
+				}
+				continue
+			}
+		}
+		clientID := ann.ID
+		if clientID == "" {
+			clientID = strconv.Itoa(i)
+		}
+		p.Create = append(p.Create, annotationPatchCreate{ClientID: clientID, CategoryID: ann.CategoryID, Type: ann.Type, Data: ann.Data})
+	}
+	for id := range current {
+		if !kept[id] {
+			p.Delete = append(p.Delete, id)
+		}
+	}
+	sort.Slice(p.Delete, func(i, j int) bool { return p.Delete[i] < p.Delete[j] })
+	return p
+}
+
+// validateAnnotationPatch 检查差异是否能作用于当前标注；失败时返回错误码和相关 ID
+func validateAnnotationPatch(p annotationPatch, current map[int64]AnnotationData) (string, []int64) {
+	clientIDs := map[string]bool{}
+	for _, c := range p.Create {
+		if c.CategoryID <= 0 || c.Type == "" {
+			return "missing_required_fields", nil
+		}
+		if c.ClientID != "" {
+			if clientIDs[c.ClientID] {
+				return "duplicate_client_id", nil
+			}
+			clientIDs[c.ClientID] = true
+		}
+	}
+	touched := map[int64]bool{}
+	var unknown, duplicate []int64
+	check := func(id int64) {
+		if _, ok := current[id]; !ok {
+			unknown = append(unknown, id)
+		} else if touched[id] {
+			duplicate = append(duplicate, id)
+		}
+		touched[id] = true
+	}
+	for _, u := range p.Update {
+		check(u.ID)
+	}
+	for _, id := range p.Delete {
+		check(id)
+	}
+	if len(unknown) > 0 {
+		return "annotation_not_found", unknown
+	}
+	if len(duplicate) > 0 {
+		return "duplicate_annotation_id", duplicate
+	}
+	return "", nil
+}
+
+// applyAnnotationPatch 在事务中执行差异写入，返回写入后的标注
+func applyAnnotationPatch(stmts *annotationWriteStmts, imageID int64, current map[int64]AnnotationData, p annotationPatch, now string) (*annotationPatchResult, error) {
+	res := &annotationPatchResult{
+		After: make(map[int64]AnnotationData, len(current)+len(p.Create)),
+		IDs:   map[string]int64{},
+	}
+	for id, a := range current {
+		res.After[id] = a
+	}
+
+	for _, id := range p.Delete {
+		if _, err := stmts.delete.Exec(id, imageID); err != nil {
+			return nil, err
+		}
+		delete(res.After, id)
+		res.Deleted = append(res.Deleted, id)
+	}
+
+	for _, u := range p.Update {
+		a := res.After[u.ID]
+		next := a
+		if u.CategoryID > 0 {
+			next.CategoryID = u.CategoryID
+		}
+		if u.Type != "" {
+			next.Type = u.Type
+		}
+		if u.Data != "" {
+			next.Data = u.Data
+		}
+		if next == a {
+			continue
+		}
+		next.UpdatedAt = now
+		if _, err := stmts.update.Exec(next.CategoryID, next.Type, next.Data, next.UpdatedAt, u.ID, imageID); err != nil {
+			return nil, err
+		}
+		res.After[u.ID] = next
+		res.Updated = append(res.Updated, u.ID)
+	}
+
+	for i, c := range p.Create {
+		r, err := stmts.insert.Exec(imageID, c.CategoryID, c.Type, c.Data, now, now)
+		if err != nil {
+			return nil, err
+		}
+		id, err := r.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		res.After[id] = AnnotationData{ID: id, ImageID: imageID, CategoryID: c.CategoryID, Type: c.Type, Data: c.Data, CreatedAt: now, UpdatedAt: now}
+		clientID := c.ClientID
+		if clientID == "" {
+			clientID = strconv.Itoa(i)
+		}
+		res.IDs[clientID] = id
+		res.Created = append(res.Created, id)
+	}
+	return res, nil
+}
+
+// handlePatchAnnotations 差异保存单张图片的标注（POST 或 PATCH）
+// 请求体 {projectId, imageId, revision, isNegative?, actor?, create: [...], update: [...], delete: [ids]}
+func handlePatchAnnotations(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req PatchAnnotationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+		return
+	}
+	if req.ProjectID == "" || req.ImageID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_and_image_required"}`))
+		return
+	}
+	if req.Revision == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		_, _ = w.Write([]byte(`{"error":"revision_required"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_begin_failed"}`))
+		return
+	}
+	defer tx.Rollback()
+
+	stmts, err := prepareAnnotationWrites(db, tx)
+	if err != nil {
+		log.Printf("patch annotations: prepare failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	before, err := loadImageAnnotationsTx(tx, req.ImageID)
+	var oldStatus string
+	var oldRevision int64
+	var deleted bool
+	if err == nil {
+		oldStatus, deleted, err = readImageAnnotationStatus(tx, req.ImageID)
+	}
+	if err == nil {
+		oldRevision, err = readAnnotationRevision(tx, req.ImageID)
+	}
+	if errors.Is(err, sql.ErrNoRows) || deleted {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"image_not_found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("patch annotations: load previous state failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+
+	patch, conflicts, mergeable, err := mergeAnnotationEdits(tx, req.ImageID, *req.Revision, oldRevision, before, func(map[int64]AnnotationData) annotationPatch {
+		return req.annotationPatch
+	})
+	if err != nil {
+		log.Printf("patch annotations: merge failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	if !mergeable || len(conflicts) > 0 {
+		writeAnnotationRevisionConflict(w, oldRevision, oldStatus, before, conflicts)
+		return
+	}
+	if code, ids := validateAnnotationPatch(patch, before); code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": code, "ids": ids})
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := applyAnnotationPatch(stmts, req.ImageID, before, patch, now)
+	if err != nil {
+		log.Printf("patch annotations: write failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"update_failed"}`))
+		return
+	}
+	warnings, err := validateWrittenAnnotations(tx, res)
+	if err != nil {
+		log.Printf("patch annotations: load categories failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	if len(warnings) > 0 && normalizeAnnotationValidation(req.Validation) == annotationValidationStrict {
+		writeInvalidAnnotations(w, warnings)
+		return
+	}
+
+	fallback := oldStatus
+	if req.IsNegative != nil {
+		fallback = "none"
+		if *req.IsNegative {
+			fallback = "negative"
+		}
+	}
+	newStatus := annotationStatusFor(len(res.After), fallback)
+	if _, err := stmts.status.Exec(newStatus, req.ImageID); err != nil {
+		log.Printf("patch annotations: update status failed: %v", err)
+	}
+	if _, err := recordAnnotationChange(tx, req.ImageID, normalizeAnnotationActor(req.Actor), annChangeSourcePatch, oldStatus, newStatus, before, res.After, 0); err != nil {
+		log.Printf("patch annotations: record change failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"history_failed"}`))
+		return
+	}
+	newRevision, err := readAnnotationRevision(tx, req.ImageID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
+		return
+	}
+	go refreshProjectCounts(cfg.DataPath, req.ProjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"status":   newStatus,
+		"ids":      res.IDs,
+		"updated":  nonNilIDs(res.Updated),
+		"deleted":  nonNilIDs(res.Deleted),
+		"revision": newRevision,
+		"merged":   *req.Revision != oldRevision,
+		"warnings": warnings,
+	})
+}
+
+// nonNilIDs 让空列表编码为 [] 而不是 null
+func nonNilIDs(ids []int64) []int64 {
+	if ids == nil {
+		return []int64{}
+	}
+	return ids
+}
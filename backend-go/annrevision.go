@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// 标注的乐观并发控制：image_index.annotation_revision 在每次标注写入时加一，
+// 读取标注时一并返回，保存时带回。revision 过期时，如果客户端改动的标注在此期间没有被别人改过，
+// 就把客户端的改动合并到当前状态上；否则返回 409 和服务端当前状态，由客户端处理
+
+// bumpAnnotationRevision 图片的 revision 加一并返回新值
+func bumpAnnotationRevision(tx *sql.Tx, imageID int64) (int64, error) {
+	if _, err := tx.Exec(`UPDATE image_index SET annotation_revision = annotation_revision + 1 WHERE id = ?;`, imageID); err != nil {
+		return 0, err
+	}
+	return readAnnotationRevision(tx, imageID)
+}
+
+// readAnnotationRevision 读取图片当前的 revision
+func readAnnotationRevision(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, imageID int64) (int64, error) {
+	var revision int64
+	err := q.QueryRow(`SELECT annotation_revision FROM image_index WHERE id = ?;`, imageID).Scan(&revision)
+	return revision, err
+}
+
+// sortedAnnotations 按 ID 升序排列标注
+func sortedAnnotations(anns map[int64]AnnotationData) []AnnotationData {
+	list := make([]AnnotationData, 0, len(anns))
+	for _, a := range anns {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// mergeAnnotationEdits 得到可以作用于当前状态的差异
+// build 根据客户端编辑时看到的状态生成差异；baseRevision 等于当前 revision 时直接用当前状态。
+// 过期时从变更日志重建客户端看到的状态，客户端改动的标注如果在此期间被别人改过则记为冲突，
+// 双方结果相同的（都删了，或改成了一样的内容）不算冲突。
+// 区间内有不经变更日志的写入、无法重建时 ok 为 false
+func mergeAnnotationEdits(tx *sql.Tx, imageID, baseRevision, currentRevision int64, current map[int64]AnnotationData, build func(base map[int64]AnnotationData) annotationPatch) (annotationPatch, []int64, bool, error) {
+	if baseRevision == currentRevision {
+		return build(current), nil, true, nil
+	}
+	if baseRevision < 0 || baseRevision > currentRevision {
+		return annotationPatch{}, nil, false, nil
+	}
+
+	rows, err := tx.Query(`SELECT id, revision FROM annotation_changes WHERE image_id = ? AND revision > ? ORDER BY revision ASC;`, imageID, baseRevision)
+	if err != nil {
+		return annotationPatch{}, nil, false, err
+	}
+	var changeIDs []int64
+	expected := baseRevision + 1
+	contiguous := true
+	for rows.Next() {
+		var id, revision int64
+		if err := rows.Scan(&id, &revision); err != nil {
+			rows.Close()
+			return annotationPatch{}, nil, false, err
+		}
+		if revision != expected {
+			contiguous = false
+		}
+		expected++
+		changeIDs = append(changeIDs, id)
+	}
+	rows.Close()
+	if !contiguous || expected != currentRevision+1 {
+		return annotationPatch{}, nil, false, nil
+	}
+
+	touched := map[int64]bool{}
+	for _, id := range changeIDs {
+		items, err := loadAnnotationChangeItems(tx, id)
+		if err != nil {
+			return annotationPatch{}, nil, false, err
+		}
+		for _, item := range items {
+			touched[item.AnnotationID] = true
+		}
+	}
+	base, _, err := annotationStateAt(tx, imageID, changeIDs[0], false)
+	if err != nil {
+		return annotationPatch{}, nil, false, err
+	}
+
+	p := build(base)
+	merged := annotationPatch{Create: p.Create}
+	var conflicts []int64
+	for _, u := range p.Update {
+		if !touched[u.ID] {
+			merged.Update = append(merged.Update, u)
+			continue
+		}
+		c, ok := current[u.ID]
+		if ok && (u.CategoryID == 0 || u.CategoryID == c.CategoryID) && (u.Type == "" || u.Type == c.Type) && (u.Data == "" || u.Data == c.Data) {
+			continue
+		}
+		conflicts = append(conflicts, u.ID)
+	}
+	for _, id := range p.Delete {
+		if !touched[id] {
+			merged.Delete = append(merged.Delete, id)
+			continue
+		}
+		if _, ok := current[id]; ok {
+			conflicts = append(conflicts, id)
+		}
+	}
+	return merged, conflicts, true, nil
+}
+
+// writeAnnotationRevisionConflict 返回 409 和服务端当前的标注、状态与 revision
+func writeAnnotationRevisionConflict(w http.ResponseWriter, revision int64, status string, current map[int64]AnnotationData, conflicts []int64) {
+	if conflicts == nil {
+		conflicts = []int64{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "revision_conflict",
+		"revision":    revision,
+		"status":      status,
+		"annotations": sortedAnnotations(current),
+		"conflicts":   conflicts,
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeAnnotationEdits(t *testing.T) {
+	db := openTestProjectDB(t)
+	ann := func(id int64, data string) AnnotationData {
+		return AnnotationData{ID: id, ImageID: 1, CategoryID: 1, Type: "bbox", Data: data, CreatedAt: "t", UpdatedAt: "t"}
+	}
+	mustExec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustExec(`INSERT INTO image_index (id, filename, original_rel_path, thumb_rel_path, created_at) VALUES (1, 'a', 'a', 'a', 't'), (2, 'b', 'b', 'b', 't');`)
+	for _, a := range []AnnotationData{ann(1, "a1"), ann(2, "a2"), ann(3, "a3")} {
+		mustExec(`INSERT INTO annotations (id, image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+			a.ID, a.ImageID, a.CategoryID, a.Type, a.Data, a.CreatedAt, a.UpdatedAt)
+	}
+
+	// 别人在 revision 0 之后改了 2（rev 1）、删了 3（rev 2）
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := map[int64]AnnotationData{2: ann(2, "a2"), 3: ann(3, "a3")}
+	mustTx := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := tx.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustTx(`UPDATE annotations SET data = 'b2' WHERE id = 2;`)
+	if _, err := recordAnnotationChange(tx, 1, "", annChangeSourcePatch, "annotated", "annotated", map[int64]AnnotationData{2: before[2]}, map[int64]AnnotationData{2: ann(2, "b2")}, 0); err != nil {
+		t.Fatal(err)
+	}
+	mustTx(`DELETE FROM annotations WHERE id = 3;`)
+	if _, err := recordAnnotationChange(tx, 1, "", annChangeSourcePatch, "annotated", "annotated", map[int64]AnnotationData{3: before[3]}, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// 图片 2 的 revision 没有经过变更日志
+	mustTx(`UPDATE image_index SET annotation_revision = 1 WHERE id = 2;`)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	create := annotationPatchCreate{ClientID: "c1", CategoryID: 1, Type: "bbox", Data: "new"}
+	tests := []struct {
+		name          string
+		imageID       int64
+		baseRevision  int64
+		patch         annotationPatch
+		wantBase      map[int64]string // build 收到的状态，id -> data
+		want          annotationPatch
+		wantConflicts []int64
+		wantOK        bool
+	}{
+		{
+			name:         "current revision uses current state",
+			imageID:      1,
+			baseRevision: 2,
+			patch:        annotationPatch{Update: []annotationPatchUpdate{{ID: 2, Data: "c2"}}},
+			wantBase:     map[int64]string{1: "a1", 2: "b2"},
+			want:         annotationPatch{Update: []annotationPatchUpdate{{ID: 2, Data: "c2"}}},
+			wantOK:       true,
+		},
+		{
+			name:         "stale edit of untouched annotation merges",
+			imageID:      1,
+			baseRevision: 0,
+			patch:        annotationPatch{Create: []annotationPatchCreate{create}, Update: []annotationPatchUpdate{{ID: 1, Data: "c1"}}},
+			wantBase:     map[int64]string{1: "a1", 2: "a2", 3: "a3"},
+			want:         annotationPatch{Create: []annotationPatchCreate{create}, Update: []annotationPatchUpdate{{ID: 1, Data: "c1"}}},
+			wantOK:       true,
+		},
+		{
+			name:          "stale edit of changed annotation conflicts",
+			imageID:       1,
+			baseRevision:  0,
+			patch:         annotationPatch{Update: []annotationPatchUpdate{{ID: 2, Data: "c2"}}},
+			wantConflicts: []int64{2},
+			wantOK:        true,
+		},
+		{
+			name:         "same result on both sides is not a conflict",
+			imageID:      1,
+			baseRevision: 0,
+			patch:        annotationPatch{Update: []annotationPatchUpdate{{ID: 2, Data: "b2"}}, Delete: []int64{3}},
+			wantOK:       true,
+		},
+		{
+			name:          "deleting a changed annotation conflicts",
+			imageID:       1,
+			baseRevision:  0,
+			patch:         annotationPatch{Delete: []int64{1, 2}},
+			want:          annotationPatch{Delete: []int64{1}},
+			wantConflicts: []int64{2},
+			wantOK:        true,
+		},
+		{
+			name:          "editing an annotation deleted since base conflicts",
+			imageID:       1,
+			baseRevision:  1,
+			wantBase:      map[int64]string{1: "a1", 2: "b2", 3: "a3"},
+			patch:         annotationPatch{Update: []annotationPatchUpdate{{ID: 3, Data: "c3"}}},
+			wantConflicts: []int64{3},
+			wantOK:        true,
+		},
+		{
+			name:         "revision ahead of server",
+			imageID:      1,
+			baseRevision: 3,
+			wantOK:       false,
+		},
+		{
+			name:         "revision bumped outside the change log",
+			imageID:      2,
+			baseRevision: 0,
+			wantOK:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			current, err := loadImageAnnotationsTx(tx, tt.imageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			currentRevision, err := readAnnotationRevision(tx, tt.imageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, conflicts, ok, err := mergeAnnotationEdits(tx, tt.imageID, tt.baseRevision, currentRevision, current, func(base map[int64]AnnotationData) annotationPatch {
+				if tt.wantBase != nil {
+					gotBase := map[int64]string{}
+					for id, a := range base {
+						gotBase[id] = a.Data
+					}
+					if !reflect.DeepEqual(gotBase, tt.wantBase) {
+						t.Errorf("base = %v, want %v", gotBase, tt.wantBase)
+					}
+				}
+				return tt.patch
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// 标注几何校验：bbox、polygon、keypoint 的 data 按类型解析检查，并核对类别类型是否匹配，
+// data.attributes 按类别的属性定义检查。
+// strict（默认）时有问题的标注整批拒绝；lenient 用于旧数据，照常写入，问题作为 warnings 返回。
+// 只校验本次新建或内容有变化的标注，没动过的旧标注不会因为历史数据不规范而阻止保存
+const (
+	annotationValidationStrict  = "strict"
+	annotationValidationLenient = "lenient"
+)
+
+// annotationCoordEpsilon 归一化坐标允许的浮点误差
+const annotationCoordEpsilon = 1e-6
+
+// annotationIssue 一条校验问题，field 为 data 内的路径
+type annotationIssue struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+}
+
+// annotationValidationResult 一条标注的全部问题；已保存的标注给出 annotationId，新建的给出 clientId
+type annotationValidationResult struct {
+	AnnotationID int64             `json:"annotationId,omitempty"`
+	ClientID     string            `json:"clientId,omitempty"`
+	Issues       []annotationIssue `json:"issues"`
+}
+
+// annotationCategoryInfo 校验需要的类别信息；KeypointCount 为 -1 表示未配置关键点
+// KeypointCategoryID 为矩形框类别在 mate 中绑定的关键点类别，0 表示未绑定
+type annotationCategoryInfo struct {
+	Type               string
+	KeypointCount      int
+	KeypointCategoryID int64
+	Attributes         []categoryAttribute
+}
+
+// normalizeAnnotationValidation 校验模式，未知值按 strict 处理
+func normalizeAnnotationValidation(s string) string {
+	if s == annotationValidationLenient {
+		return annotationValidationLenient
+	}
+	return annotationValidationStrict
+}
+
+// loadAnnotationCategories 读取项目全部类别
+func loadAnnotationCategories(tx *sql.Tx) (map[int64]annotationCategoryInfo, error) {
+	rows, err := tx.Query(`SELECT id, type, COALESCE(mate, '') FROM categories;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cats := map[int64]annotationCategoryInfo{}
+	for rows.Next() {
+		var id int64
+		var catType, mate string
+		if err := rows.Scan(&id, &catType, &mate); err != nil {
+			return nil, err
+		}
+		info := annotationCategoryInfo{Type: catType, KeypointCount: -1}
+		// 定义不合法的按未定义处理
+		info.Attributes, _ = parseCategoryAttributes(mate)
+		if mate != "" {
+			var m struct {
+				Keypoints          []json.RawMessage `json:"keypoints"`
+				KeypointCategoryID int64             `json:"keypointCategoryId"`
+			}
+			if json.Unmarshal([]byte(mate), &m) == nil {
+				if catType == "keypoint" && len(m.Keypoints) > 0 {
+					info.KeypointCount = len(m.Keypoints)
+				}
+				if catType == "bbox" {
+					info.KeypointCategoryID = m.KeypointCategoryID
+				}
+			}
+		}
+		cats[id] = info
+	}
+	return cats, rows.Err()
+}
+
+// validateAnnotation 检查一条标注，没有问题时返回 nil
+func validateAnnotation(a AnnotationData, cats map[int64]annotationCategoryInfo) []annotationIssue {
+	var issues []annotationIssue
+	add := func(field, code string) {
+		issues = append(issues, annotationIssue{Field: field, Code: code})
+	}
+
+	cat, ok := cats[a.CategoryID]
+	if !ok {
+		add("categoryId", "category_not_found")
+	} else if cat.Type != a.Type {
+		add("type", "category_type_mismatch")
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(a.Data), &data); err != nil || data == nil {
+		add("data", "invalid_json")
+		return issues
+	}
+	if ok {
+		validateAnnotationAttributes(data, cat.Attributes, add)
+	}
+
+	switch a.Type {
+	case "bbox":
+		validateBboxData(data, cat, cats, add)
+	case "polygon":
+		points, ok := data["points"].([]interface{})
+		if !ok {
+			add("data.points", "missing_field")
+			break
+		}
+		if len(points) < 3 {
+			add("data.points", "too_few_points")
+		}
+		for i, p := range points {
+			validatePointData(p, 2, fmt.Sprintf("data.points[%d]", i), add)
+		}
+	case "keypoint":
+		// 独立关键点标注用 points，与 bbox 上的 keypoints 格式相同
+		field := "data.points"
+		points, ok := data["points"].([]interface{})
+		if !ok {
+			field = "data.keypoints"
+			points, ok = data["keypoints"].([]interface{})
+		}
+		if !ok {
+			add("data.points", "missing_field")
+			break
+		}
+		count := -1
+		if cat, ok := cats[a.CategoryID]; ok {
+			count = cat.KeypointCount
+		}
+		validateKeypointList(points, count, field, add)
+	case "category":
+		// 分类标注没有几何信息
+	default:
+		add("type", "unknown_type")
+	}
+	return issues
+}
+
+// validateBboxData x、y 为左上角，宽高为正，整个框在 [0,1] 内；旋转框只要求中心在图内
+// 关键点未带 keypointCategoryId 时与画布一样取矩形框类别绑定的关键点类别（推理结果就是这样保存的）
+func validateBboxData(data map[string]interface{}, cat annotationCategoryInfo, cats map[int64]annotationCategoryInfo, add func(field, code string)) {
+	var v [4]float64
+	valid := true
+	for i, key := range []string{"x", "y", "width", "height"} {
+		n, ok := finiteNumber(data[key])
+		if !ok {
+			if data[key] == nil {
+				add("data."+key, "missing_field")
+			} else {
+				add("data."+key, "not_finite")
+			}
+			valid = false
+			continue
+		}
+		v[i] = n
+	}
+	rotation := 0.0
+	if raw, present := data["rotation"]; present && raw != nil {
+		n, ok := finiteNumber(raw)
+		if !ok {
+			add("data.rotation", "not_finite")
+		}
+		rotation = n
+	}
+	if valid {
+		x, y, w, h := v[0], v[1], v[2], v[3]
+		if w <= 0 || h <= 0 {
+			add("data", "non_positive_size")
+		} else if rotation != 0 {
+			if !inUnitRange(x+w/2) || !inUnitRange(y+h/2) {
+				add("data", "out_of_range")
+			}
+		} else if !inUnitRange(x) || !inUnitRange(y) || !inUnitRange(x+w) || !inUnitRange(y+h) {
+			add("data", "out_of_range")
+		}
+	}
+
+	raw, present := data["keypoints"]
+	if !present || raw == nil {
+		return
+	}
+	points, ok := raw.([]interface{})
+	if !ok {
+		add("data.keypoints", "invalid_keypoints")
+		return
+	}
+	count := -1
+	kpCatID, ok := finiteNumber(data["keypointCategoryId"])
+	if !ok && data["keypointCategoryId"] == nil && cat.KeypointCategoryID > 0 {
+		kpCatID, ok = float64(cat.KeypointCategoryID), true
+	}
+	if !ok {
+		add("data.keypointCategoryId", "missing_field")
+	} else if kpCat, found := cats[int64(kpCatID)]; !found || kpCat.Type != "keypoint" {
+		add("data.keypointCategoryId", "keypoint_category_invalid")
+	} else {
+		count = kpCat.KeypointCount
+		if count < 0 {
+			add("data.keypointCategoryId", "keypoint_category_not_configured")
+		}
+	}
+	validateKeypointList(points, count, "data.keypoints", add)
+}
+
+// validateKeypointList 每个点为 [x, y, v]，v ∈ {0,1,2}；v 为 0 的点不检查坐标范围
+// count >= 0 时点数必须与类别配置一致
+func validateKeypointList(points []interface{}, count int, field string, add func(field, code string)) {
+	if count >= 0 && len(points) != count {
+		add(field, "keypoint_count_mismatch")
+	}
+	for i, p := range points {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		pt, ok := p.([]interface{})
+		if !ok || len(pt) != 3 {
+			add(name, "invalid_point")
+			continue
+		}
+		vis, ok := finiteNumber(pt[2])
+		if !ok || (vis != 0 && vis != 1 && vis != 2) {
+			add(name, "invalid_visibility")
+			continue
+		}
+		if vis == 0 {
+			continue
+		}
+		validatePointData(pt[:2], 2, name, add)
+	}
+}
+
+// validatePointData 检查点的前 dims 个坐标是有限数且在 [0,1] 内
+func validatePointData(p interface{}, dims int, field string, add func(field, code string)) {
+	pt, ok := p.([]interface{})
+	if !ok || len(pt) < dims {
+		add(field, "invalid_point")
+		return
+	}
+	for _, c := range pt[:dims] {
+		n, ok := finiteNumber(c)
+		if !ok {
+			add(field, "not_finite")
+			return
+		}
+		if !inUnitRange(n) {
+			add(field, "out_of_range")
+			return
+		}
+	}
+}
+
+// finiteNumber JSON 没有 NaN/Inf，前端序列化时会变成 null，这里统一当作无效数值
+func finiteNumber(v interface{}) (float64, bool) {
+	n, ok := toFloat(v)
+	if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, false
+	}
+	return n, true
+}
+
+func inUnitRange(n float64) bool {
+	return n >= -annotationCoordEpsilon && n <= 1+annotationCoordEpsilon
+}
+
+// validateWrittenAnnotations 校验本次新建和更新的标注
+func validateWrittenAnnotations(tx *sql.Tx, res *annotationPatchResult) ([]annotationValidationResult, error) {
+	if len(res.Created) == 0 && len(res.Updated) == 0 {
+		return nil, nil
+	}
+	cats, err := loadAnnotationCategories(tx)
+	if err != nil {
+		return nil, err
+	}
+	clientIDs := make(map[int64]string, len(res.IDs))
+	for clientID, id := range res.IDs {
+		clientIDs[id] = clientID
+	}
+	var results []annotationValidationResult
+	check := func(id int64, created bool) {
+		issues := validateAnnotation(res.After[id], cats)
+		if len(issues) == 0 {
+			return
+		}
+		r := annotationValidationResult{Issues: issues}
+		if created {
+			r.ClientID = clientIDs[id]
+		} else {
+			r.AnnotationID = id
+		}
+		results = append(results, r)
+	}
+	for _, id := range res.Updated {
+		check(id, false)
+	}
+	for _, id := range res.Created {
+		check(id, true)
+	}
+	return results, nil
+}
+
+// writeInvalidAnnotations strict 模式下返回 422 和每条标注的问题
+func writeInvalidAnnotations(w http.ResponseWriter, results []annotationValidationResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": "invalid_annotations", "annotations": results})
+}
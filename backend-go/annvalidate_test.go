@@ -0,0 +1,193 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateAnnotation(t *testing.T) {
+	cats := map[int64]annotationCategoryInfo{
+		1: {Type: "bbox", KeypointCount: -1},
+		2: {Type: "polygon", KeypointCount: -1},
+		3: {Type: "keypoint", KeypointCount: 2},
+		4: {Type: "keypoint", KeypointCount: -1},
+		5: {Type: "category", KeypointCount: -1},
+		6: {Type: "bbox", KeypointCount: -1, KeypointCategoryID: 3},
+	}
+	issue := func(field, code string) annotationIssue {
+		return annotationIssue{Field: field, Code: code}
+	}
+	tests := []struct {
+		name       string
+		categoryID int64
+		annType    string
+		data       string
+		want       []annotationIssue
+	}{
+		{
+			name:       "valid bbox",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5}`,
+		},
+		{
+			name:       "bbox touching the edge within epsilon",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0,"y":0,"width":1.0000001,"height":1}`,
+		},
+		{
+			name:       "bbox out of range",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.8,"y":0.1,"width":0.5,"height":0.5}`,
+			want:       []annotationIssue{issue("data", "out_of_range")},
+		},
+		{
+			name:       "rotated bbox only needs its center inside",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.8,"y":0.1,"width":0.3,"height":0.5,"rotation":30}`,
+		},
+		{
+			name:       "bbox with null and missing fields",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":null,"y":"0.1","width":0.5}`,
+			want: []annotationIssue{
+				issue("data.x", "missing_field"),
+				issue("data.y", "not_finite"),
+				issue("data.height", "missing_field"),
+			},
+		},
+		{
+			name:       "bbox with non-positive size",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0,"height":0.5}`,
+			want:       []annotationIssue{issue("data", "non_positive_size")},
+		},
+		{
+			name:       "bbox keypoints need a configured keypoint category",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5,"keypointCategoryId":4,"keypoints":[[0.2,0.2,2]]}`,
+			want:       []annotationIssue{issue("data.keypointCategoryId", "keypoint_category_not_configured")},
+		},
+		{
+			name:       "bbox keypoints checked against the keypoint category",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5,"keypointCategoryId":3,"keypoints":[[0.2,0.2,2],[5,5,0],[0.3,0.3,3]]}`,
+			want: []annotationIssue{
+				issue("data.keypoints", "keypoint_count_mismatch"),
+				issue("data.keypoints[2]", "invalid_visibility"),
+			},
+		},
+		{
+			name:       "bbox keypoints with a non-keypoint category",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5,"keypointCategoryId":2,"keypoints":[]}`,
+			want:       []annotationIssue{issue("data.keypointCategoryId", "keypoint_category_invalid")},
+		},
+		{
+			name:       "bbox keypoints fall back to the category binding",
+			categoryID: 6,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5,"keypoints":[[0.2,0.2,2],[0.3,0.3,1]]}`,
+		},
+		{
+			name:       "bbox keypoints checked against the bound category",
+			categoryID: 6,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5,"keypoints":[[0.2,0.2,2]]}`,
+			want:       []annotationIssue{issue("data.keypoints", "keypoint_count_mismatch")},
+		},
+		{
+			name:       "bbox keypoints without any keypoint category",
+			categoryID: 1,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5,"keypoints":[[0.2,0.2,2]]}`,
+			want:       []annotationIssue{issue("data.keypointCategoryId", "missing_field")},
+		},
+		{
+			name:       "valid polygon",
+			categoryID: 2,
+			annType:    "polygon",
+			data:       `{"points":[[0,0],[1,0],[1,1]]}`,
+		},
+		{
+			name:       "polygon with too few and bad points",
+			categoryID: 2,
+			annType:    "polygon",
+			data:       `{"points":[[0,0],[1.5,0]]}`,
+			want: []annotationIssue{
+				issue("data.points", "too_few_points"),
+				issue("data.points[1]", "out_of_range"),
+			},
+		},
+		{
+			name:       "polygon without points",
+			categoryID: 2,
+			annType:    "polygon",
+			data:       `{}`,
+			want:       []annotationIssue{issue("data.points", "missing_field")},
+		},
+		{
+			name:       "keypoint annotation using the keypoints field",
+			categoryID: 3,
+			annType:    "keypoint",
+			data:       `{"keypoints":[[0.1,0.1,1],[0.2,0.2,2]]}`,
+		},
+		{
+			name:       "keypoint annotation with a wrong point count",
+			categoryID: 3,
+			annType:    "keypoint",
+			data:       `{"points":[[0.1,0.1,1]]}`,
+			want:       []annotationIssue{issue("data.points", "keypoint_count_mismatch")},
+		},
+		{
+			name:       "classification has no geometry",
+			categoryID: 5,
+			annType:    "category",
+			data:       `{}`,
+		},
+		{
+			name:       "type does not match the category",
+			categoryID: 2,
+			annType:    "bbox",
+			data:       `{"x":0.1,"y":0.1,"width":0.5,"height":0.5}`,
+			want:       []annotationIssue{issue("type", "category_type_mismatch")},
+		},
+		{
+			name:       "unknown category and invalid json",
+			categoryID: 99,
+			annType:    "bbox",
+			data:       `not json`,
+			want: []annotationIssue{
+				issue("categoryId", "category_not_found"),
+				issue("data", "invalid_json"),
+			},
+		},
+		{
+			name:       "unknown type",
+			categoryID: 99,
+			annType:    "circle",
+			data:       `{}`,
+			want: []annotationIssue{
+				issue("categoryId", "category_not_found"),
+				issue("type", "unknown_type"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := AnnotationData{ID: 1, ImageID: 1, CategoryID: tt.categoryID, Type: tt.annType, Data: tt.data}
+			got := validateAnnotation(a, cats)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// projectArchiveExt 项目归档文件扩展名（zip 格式）
+const projectArchiveExt = ".emproj"
+
+// projectArchiveFormatVersion 归档格式版本，结构不兼容时递增
+const projectArchiveFormatVersion = 1
+
+// projectArchiveManifest 归档中的 manifest.json
+type projectArchiveManifest struct {
+	FormatVersion  int                      `json:"formatVersion"`
+	SchemaVersion  int                      `json:"schemaVersion"`
+	CreatedAt      string                   `json:"createdAt"`
+	Project        ProjectMeta              `json:"project"`
+	Files          []projectArchiveFile     `json:"files"`
+	ExternalImages []projectArchiveExternal `json:"externalImages"`
+}
+
+// projectArchiveFile 归档内的单个文件及其校验和
+type projectArchiveFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// projectArchiveExternal 外部模式图片；ArchivePath 为空表示未打包，导入时仍指向原绝对路径
+type projectArchiveExternal struct {
+	SourcePath  string `json:"sourcePath"`
+	ArchivePath string `json:"archivePath,omitempty"`
+	Missing     bool   `json:"missing,omitempty"` // 导出时源文件已不存在
+}
+
+// updateImportTask 在锁内修改任务状态
+func updateImportTask(taskID string, fn func(task *ImportTaskStatus)) {
+	importTasksMu.Lock()
+	if task, ok := importTasks[taskID]; ok {
+		fn(task)
+	}
+	importTasksMu.Unlock()
+}
+
+// failImportTask 标记任务失败
+func failImportTask(taskID, code string) {
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseFailed
+		task.Error = code
+	})
+}
+
+// handleProjectArchiveExport 将项目打包为 .emproj 归档
+func handleProjectArchiveExport(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID       string `json:"projectId"`
+		OutputPath      string `json:"outputPath"`      // 目标文件或目录
+		IncludeExternal bool   `json:"includeExternal"` // 是否把外部模式图片一并打包
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	req.OutputPath = strings.TrimSpace(req.OutputPath)
+	if req.ProjectID == "" || req.OutputPath == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_and_output_required"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	project, err := getProject(cfg.DataPath, req.ProjectID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errProjectNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"projects_unavailable"}`))
+		return
+	}
+
+	outputPath := req.OutputPath
+	if info, err := os.Stat(outputPath); err == nil && info.IsDir() {
+		outputPath = filepath.Join(outputPath, project.Name+projectArchiveExt)
+	} else if !strings.EqualFold(filepath.Ext(outputPath), projectArchiveExt) {
+		outputPath += projectArchiveExt
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: req.ProjectID,
+		TaskType:  taskTypeExportProject,
+		Phase:     importPhaseScanning,
+	}
+	importTasksMu.Unlock()
+
+	go runProjectArchiveExport(cfg.DataPath, project, taskID, outputPath, req.IncludeExternal)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"taskId":     taskID,
+		"outputPath": outputPath,
+	})
+}
+
+// runProjectArchiveExport 异步打包项目目录、版本快照以及（可选）外部图片
+func runProjectArchiveExport(dataPath string, project ProjectMeta, taskID, outputPath string, includeExternal bool) {
+	defer releaseIOLock(taskID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ProjectArchive] Export task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_export_task")
+		}
+	}()
+
+	projectRoot := filepath.Join(dataPath, "project_item", project.ID)
+	if info, err := os.Stat(projectRoot); err != nil || !info.IsDir() {
+		failImportTask(taskID, "project_dir_missing")
+		return
+	}
+
+	// 收集项目目录下的文件，WAL/SHM 由 VACUUM INTO 合并，不单独打包
+	var relFiles []string
+	var dbFiles []string
+	err := filepath.WalkDir(projectRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// 瓦片是可重建的缓存，不打包
+			if p == filepath.Join(projectRoot, "images", "tiles") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if strings.HasSuffix(name, "-wal") || strings.HasSuffix(name, "-shm") || strings.HasSuffix(name, "-journal") {
+			return nil
+		}
+		rel, err := filepath.Rel(projectRoot, p)
+		if err != nil {
+			return err
+		}
+		relFiles = append(relFiles, rel)
+		if strings.HasSuffix(name, ".db") {
+			dbFiles = append(dbFiles, p)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[ProjectArchive] Export task %s walk failed: %v", taskID, err)
+		failImportTask(taskID, "scan_failed")
+		return
+	}
+
+	externalPaths := collectExternalImagePaths(dbFiles)
+	schemaVersion := 0
+	if db, err := acquireProjectDB(filepath.Join(projectRoot, "db", "project.db")); err == nil {
+		schemaVersion, _ = readSchemaVersion(db)
+		db.Release()
+	}
+
+	total := len(relFiles)
+	if includeExternal {
+		total += len(externalPaths)
+	}
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCopying
+		task.Total = total
+	})
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		failImportTask(taskID, "output_unavailable")
+		return
+	}
+	partPath := outputPath + ".part"
+	out, err := os.Create(partPath)
+	if err != nil {
+		log.Printf("[ProjectArchive] Export task %s create output failed: %v", taskID, err)
+		failImportTask(taskID, "output_unavailable")
+		return
+	}
+	zw := zip.NewWriter(out)
+	abort := func(code string) {
+		_ = zw.Close()
+		_ = out.Close()
+		_ = os.Remove(partPath)
+		failImportTask(taskID, code)
+	}
+
+	manifest := projectArchiveManifest{
+		FormatVersion: projectArchiveFormatVersion,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		Project:       project,
+	}
+	processed := 0
+	step := func() {
+		processed++
+		updateImportTask(taskID, func(task *ImportTaskStatus) {
+			task.Imported = processed
+			if task.Total > 0 {
+				task.Progress = int(float64(processed) * 100 / float64(task.Total))
+			}
+		})
+	}
+
+	for _, rel := range relFiles {
+		src := filepath.Join(projectRoot, rel)
+		archiveName := path.Join("project", filepath.ToSlash(rel))
+		var entry projectArchiveFile
+		if strings.HasSuffix(rel, ".db") {
+			entry, err = addSQLiteSnapshotToZip(zw, archiveName, src)
+		} else {
+			entry, err = addFileToZip(zw, archiveName, src)
+		}
+		if err != nil {
+			log.Printf("[ProjectArchive] Export task %s add %s failed: %v", taskID, rel, err)
+			abort("archive_write_failed")
+			return
+		}
+		manifest.Files = append(manifest.Files, entry)
+		step()
+	}
+
+	for i, p := range externalPaths {
+		ext := projectArchiveExternal{SourcePath: p}
+		if includeExternal {
+			archiveName := fmt.Sprintf("external/%d/%s", i, path.Base(filepath.ToSlash(p)))
+			entry, err := addFileToZip(zw, archiveName, filepath.FromSlash(p))
+			if err != nil {
+				log.Printf("[ProjectArchive] Export task %s external image unavailable: %s: %v", taskID, p, err)
+				ext.Missing = true
+				updateImportTask(taskID, func(task *ImportTaskStatus) {
+					task.MissingFiles = append(task.MissingFiles, p)
+				})
+			} else {
+				ext.ArchivePath = archiveName
+				manifest.Files = append(manifest.Files, entry)
+			}
+			step()
+		} else if _, err := os.Stat(filepath.FromSlash(p)); err != nil {
+			ext.Missing = true
+		}
+		manifest.ExternalImages = append(manifest.ExternalImages, ext)
+	}
+
+	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+	mw, err := zw.Create("manifest.json")
+	if err == nil {
+		_, err = mw.Write(manifestData)
+	}
+	if err != nil {
+		abort("archive_write_failed")
+		return
+	}
+	if err := zw.Close(); err != nil {
+		_ = out.Close()
+		_ = os.Remove(partPath)
+		failImportTask(taskID, "archive_write_failed")
+		return
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(partPath)
+		failImportTask(taskID, "archive_write_failed")
+		return
+	}
+	_ = os.Remove(outputPath)
+	if err := os.Rename(partPath, outputPath); err != nil {
+		_ = os.Remove(partPath)
+		failImportTask(taskID, "archive_write_failed")
+		return
+	}
+
+	log.Printf("[ProjectArchive] Export task %s wrote %s (%d files, %d external)", taskID, outputPath, len(manifest.Files), len(manifest.ExternalImages))
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+		task.ResultPath = outputPath
+	})
+}
+
+// collectExternalImagePaths 汇总当前库和所有快照中引用的外部（绝对路径）图片
+func collectExternalImagePaths(dbFiles []string) []string {
+	seen := make(map[string]struct{})
+	var result []string
+	for _, dbPath := range dbFiles {
+		db, err := acquireProjectDB(dbPath)
+		if err != nil {
+			continue
+		}
+		rows, err := db.Query(`SELECT original_rel_path, thumb_rel_path FROM image_index;`)
+		if err != nil {
+			db.Release()
+			continue
+		}
+		for rows.Next() {
+			var original, thumb string
+			if rows.Scan(&original, &thumb) != nil {
+				continue
+			}
+			for _, p := range []string{original, thumb} {
+				if p == "" || !filepath.IsAbs(filepath.FromSlash(p)) {
+					continue
+				}
+				if _, ok := seen[p]; !ok {
+					seen[p] = struct{}{}
+					result = append(result, p)
+				}
+			}
+		}
+		rows.Close()
+		db.Release()
+	}
+	return result
+}
+
+// addSQLiteSnapshotToZip 用 VACUUM INTO 得到一致的数据库副本后写入归档
+func addSQLiteSnapshotToZip(zw *zip.Writer, archiveName, dbPath string) (projectArchiveFile, error) {
+	tmp, err := os.CreateTemp("", "emproj-*.db")
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	// VACUUM INTO 要求目标文件不存在
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+	_, err = db.Exec(`VACUUM INTO ?;`, tmpPath)
+	db.Release()
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+	if err := clearSnapshotWatchFolders(tmpPath); err != nil {
+		return projectArchiveFile{}, err
+	}
+	return addFileToZip(zw, archiveName, tmpPath)
+}
+
+// clearSnapshotWatchFolders 监视目录是本机路径，不随项目归档带到别的机器
+func clearSnapshotWatchFolders(snapshotPath string) error {
+	db, err := sql.Open("sqlite", snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, table := range []string{"watch_files", "watch_folders"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`, table).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := db.Exec(`DELETE FROM ` + table + `;`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFileToZip 写入文件并同时计算 SHA-256；已压缩的图片格式直接存储
+func addFileToZip(zw *zip.Writer, archiveName, src string) (projectArchiveFile, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+
+	header := &zip.FileHeader{Name: archiveName, Method: zip.Deflate}
+	header.Modified = info.ModTime()
+	if isImagePath(src) {
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), f)
+	if err != nil {
+		return projectArchiveFile{}, err
+	}
+	return projectArchiveFile{Path: archiveName, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// handleProjectArchiveImport 从 .emproj 归档导入为新项目
+func handleProjectArchiveImport(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ArchivePath string `json:"archivePath"`
+		Name        string `json:"name"` // 可选，默认使用归档中的项目名
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ArchivePath = strings.TrimSpace(req.ArchivePath)
+	req.Name = strings.TrimSpace(req.Name)
+	if req.ArchivePath == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"archive_required"}`))
+		return
+	}
+	if req.Name != "" && strings.ContainsAny(req.Name, "<>:\"/\\|?*") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"name_invalid"}`))
+		return
+	}
+	if info, err := os.Stat(req.ArchivePath); err != nil || info.IsDir() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"archive_not_found"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:       taskID,
+		TaskType: taskTypeImportProject,
+		Phase:    importPhaseScanning,
+	}
+	importTasksMu.Unlock()
+
+	go runProjectArchiveImport(cfg.DataPath, taskID, req.ArchivePath, req.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"taskId": taskID})
+}
+
+// runProjectArchiveImport 校验并解包归档，改写外部图片路径后注册为新项目
+// 校验和不一致时整个导入失败；归档或本机缺失的文件只记录在任务状态中
+func runProjectArchiveImport(dataPath, taskID, archivePath, name string) {
+	defer releaseIOLock(taskID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ProjectArchive] Import task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_import_task")
+		}
+	}()
+
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		log.Printf("[ProjectArchive] Import task %s open archive failed: %v", taskID, err)
+		failImportTask(taskID, "archive_invalid")
+		return
+	}
+	defer zr.Close()
+
+	entries := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	manifestEntry, ok := entries["manifest.json"]
+	if !ok {
+		failImportTask(taskID, "manifest_missing")
+		return
+	}
+	var manifest projectArchiveManifest
+	if rc, err := manifestEntry.Open(); err == nil {
+		err = json.NewDecoder(rc).Decode(&manifest)
+		rc.Close()
+		if err != nil {
+			failImportTask(taskID, "manifest_invalid")
+			return
+		}
+	} else {
+		failImportTask(taskID, "manifest_invalid")
+		return
+	}
+	if manifest.FormatVersion > projectArchiveFormatVersion {
+		failImportTask(taskID, "archive_version_unsupported")
+		return
+	}
+	if manifest.SchemaVersion > latestSchemaVersion() {
+		failImportTask(taskID, "archive_schema_unsupported")
+		return
+	}
+
+	newID, err := generateProjectID()
+	if err != nil {
+		failImportTask(taskID, "id_generation_failed")
+		return
+	}
+	projectRoot := filepath.Join(dataPath, "project_item", newID)
+	originalsDir := filepath.Join(projectRoot, "images", "originals")
+	if err := os.MkdirAll(originalsDir, 0o755); err != nil {
+		failImportTask(taskID, "storage_unavailable")
+		return
+	}
+	cleanup := func(code string) {
+		_ = os.RemoveAll(projectRoot)
+		failImportTask(taskID, code)
+	}
+
+	// 打包进来的外部图片放入 originals，文件名避开已有文件
+	externalTargets := make(map[string]string)
+	usedNames := make(map[string]struct{})
+	for _, f := range manifest.Files {
+		if strings.HasPrefix(f.Path, "project/images/originals/") {
+			usedNames[path.Base(f.Path)] = struct{}{}
+		}
+	}
+	for _, ext := range manifest.ExternalImages {
+		if ext.ArchivePath == "" {
+			continue
+		}
+		name := path.Base(ext.ArchivePath)
+		candidate := name
+		for i := 1; ; i++ {
+			if _, taken := usedNames[candidate]; !taken {
+				break
+			}
+			candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, path.Ext(name)), i, path.Ext(name))
+		}
+		usedNames[candidate] = struct{}{}
+		externalTargets[ext.ArchivePath] = candidate
+	}
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCopying
+		task.Total = len(manifest.Files)
+	})
+
+	var missing, corrupt []string
+	for i, f := range manifest.Files {
+		var target string
+		if strings.HasPrefix(f.Path, "project/") {
+			rel := strings.TrimPrefix(f.Path, "project/")
+			clean := path.Clean(rel)
+			if clean == "." || strings.HasPrefix(clean, "../") || clean == ".." || path.IsAbs(clean) {
+				corrupt = append(corrupt, f.Path)
+				continue
+			}
+			target = filepath.Join(projectRoot, filepath.FromSlash(clean))
+		} else if name, ok := externalTargets[f.Path]; ok {
+			target = filepath.Join(originalsDir, name)
+		} else {
+			continue
+		}
+
+		entry, ok := entries[f.Path]
+		if !ok {
+			missing = append(missing, f.Path)
+			continue
+		}
+		sum, err := extractZipEntry(entry, target)
+		if err != nil {
+			log.Printf("[ProjectArchive] Import task %s extract %s failed: %v", taskID, f.Path, err)
+			corrupt = append(corrupt, f.Path)
+			continue
+		}
+		if sum != f.SHA256 {
+			corrupt = append(corrupt, f.Path)
+		}
+
+		processed := i + 1
+		updateImportTask(taskID, func(task *ImportTaskStatus) {
+			task.Imported = processed
+			if task.Total > 0 {
+				task.Progress = int(float64(processed) * 100 / float64(task.Total))
+			}
+		})
+	}
+
+	// 未打包的外部图片继续引用原路径，检查在本机是否存在
+	for _, ext := range manifest.ExternalImages {
+		if ext.ArchivePath != "" {
+			if _, ok := entries[ext.ArchivePath]; ok {
+				continue
+			}
+		}
+		if _, err := os.Stat(filepath.FromSlash(ext.SourcePath)); err != nil {
+			missing = append(missing, ext.SourcePath)
+		}
+	}
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.MissingFiles = missing
+		task.CorruptFiles = corrupt
+	})
+	if len(corrupt) > 0 {
+		log.Printf("[ProjectArchive] Import task %s: %d files failed checksum verification", taskID, len(corrupt))
+		cleanup("checksum_mismatch")
+		return
+	}
+	if _, err := os.Stat(filepath.Join(projectRoot, "db", "project.db")); err != nil {
+		cleanup("project_db_missing")
+		return
+	}
+
+	// 改写数据库中的外部图片路径（当前库和所有快照）
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseIndexing
+	})
+	rewrites := make(map[string]string)
+	for _, ext := range manifest.ExternalImages {
+		if name, ok := externalTargets[ext.ArchivePath]; ok {
+			if _, present := entries[ext.ArchivePath]; present {
+				rewrites[ext.SourcePath] = path.Join("images", "originals", name)
+			}
+		}
+	}
+	if len(rewrites) > 0 {
+		dbFiles, _ := filepath.Glob(filepath.Join(projectRoot, "db", "versions", "v*", "project.db"))
+		dbFiles = append([]string{filepath.Join(projectRoot, "db", "project.db")}, dbFiles...)
+		for _, dbPath := range dbFiles {
+			if err := rewriteImagePaths(dbPath, rewrites); err != nil {
+				log.Printf("[ProjectArchive] Import task %s rewrite paths in %s failed: %v", taskID, dbPath, err)
+				cleanup("path_rewrite_failed")
+				return
+			}
+		}
+	}
+
+	if name == "" {
+		name = manifest.Project.Name
+	}
+	if name == "" {
+		name = newID
+	}
+	meta := ProjectMeta{
+		ID:          newID,
+		Name:        name,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		Description: manifest.Project.Description,
+		TaskType:    manifest.Project.TaskType,
+		Tags:        manifest.Project.Tags,
+	}
+	// 名称冲突时追加序号
+	for i := 2; ; i++ {
+		err := createProject(dataPath, meta)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errProjectNameExists) {
+			cleanup("projects_persist_failed")
+			return
+		}
+		meta.Name = fmt.Sprintf("%s (%d)", name, i)
+	}
+	name = meta.Name
+	refreshProjectCounts(dataPath, newID)
+
+	log.Printf("[ProjectArchive] Import task %s created project %s (%s), missing=%d", taskID, newID, name, len(missing))
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.ProjectID = newID
+		task.ResultProjectID = newID
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+	})
+}
+
+// extractZipEntry 解压单个文件并返回其 SHA-256
+func extractZipEntry(entry *zip.File, target string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	rc, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, copyErr := io.Copy(io.MultiWriter(out, h), rc)
+	closeErr := out.Close()
+	if copyErr != nil {
+		return "", copyErr
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rewriteImagePaths 按映射替换 image_index 中的原图和缩略图路径
+func rewriteImagePaths(dbPath string, rewrites map[string]string) error {
+	db, err := openProjectDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for from, to := range rewrites {
+		if _, err := tx.Exec(`UPDATE image_index SET original_rel_path = ? WHERE original_rel_path = ?;`, to, from); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE image_index SET thumb_rel_path = ? WHERE thumb_rel_path = ?;`, to, from); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 项目目录库位于数据根目录下，替代原来的 projects.json
+const catalogDBName = "catalog.db"
+
+var (
+	errProjectNotFound   = errors.New("project not found")
+	errProjectNameExists = errors.New("project name already exists")
+)
+
+// catalogReady 已完成建表和 projects.json 迁移的数据根目录
+var (
+	catalogInitMu sync.Mutex
+	catalogReady  = make(map[string]bool)
+)
+
+// openCatalogDB 打开项目目录库，首次打开时建表并迁移 projects.json
+// 只用一个连接，所有写操作天然串行
+func openCatalogDB(dataPath string) (*sql.DB, error) {
+	if err := os.MkdirAll(dataPath, 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", filepath.Join(dataPath, catalogDBName))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	catalogInitMu.Lock()
+	defer catalogInitMu.Unlock()
+	if catalogReady[dataPath] {
+		return db, nil
+	}
+	if err := initCatalogDB(db, dataPath); err != nil {
+		db.Close()
+		return nil, err
+	}
+	catalogReady[dataPath] = true
+	return db, nil
+}
+
+// initCatalogDB 建表，并在一个事务内导入旧的 projects.json
+func initCatalogDB(db *sql.DB, dataPath string) error {
+	if _, err := db.Exec(`PRAGMA journal_mode=WAL;`); err != nil {
+		log.Printf("[Catalog] set WAL failed: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS projects (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	task_type TEXT NOT NULL DEFAULT '',
+	tags TEXT NOT NULL DEFAULT '[]',
+	archived INTEGER NOT NULL DEFAULT 0,
+	last_opened_at TEXT NOT NULL DEFAULT '',
+	image_count INTEGER NOT NULL DEFAULT 0,
+	annotation_count INTEGER NOT NULL DEFAULT 0,
+	counts_updated_at TEXT NOT NULL DEFAULT ''
+);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_name ON projects(name COLLATE NOCASE);`,
+		`CREATE TABLE IF NOT EXISTS catalog_meta (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	var migrated string
+	err = tx.QueryRow(`SELECT value FROM catalog_meta WHERE key = 'projects_json_migrated';`).Scan(&migrated)
+	if err == nil {
+		return tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	jsonPath := filepath.Join(dataPath, "projects.json")
+	legacy, err := readLegacyProjectsJSON(jsonPath)
+	if err != nil {
+		return fmt.Errorf("read projects.json: %w", err)
+	}
+	for _, p := range legacy {
+		name := p.Name
+		for i := 2; ; i++ {
+			var exists int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM projects WHERE name = ? COLLATE NOCASE;`, name).Scan(&exists); err != nil {
+				return err
+			}
+			if exists == 0 {
+				break
+			}
+			name = fmt.Sprintf("%s (%d)", p.Name, i)
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO projects (id, name, created_at) VALUES (?, ?, ?);`, p.ID, name, p.CreatedAt); err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO catalog_meta (key, value) VALUES ('projects_json_migrated', ?);`, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// 旧文件保留为备份，避免再次被读取
+	if len(legacy) > 0 {
+		if err := os.Rename(jsonPath, jsonPath+".migrated"); err != nil {
+			log.Printf("[Catalog] rename projects.json failed: %v", err)
+		}
+		log.Printf("[Catalog] migrated %d projects from projects.json", len(legacy))
+	}
+	return nil
+}
+
+// readLegacyProjectsJSON 读取旧版 projects.json，不存在时返回空列表
+func readLegacyProjectsJSON(path string) ([]ProjectMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, nil
+	}
+	var projects []ProjectMeta
+	if err := json.Unmarshal(data, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+const projectSelectColumns = `id, name, created_at, description, task_type, tags, archived, last_opened_at, image_count, annotation_count, counts_updated_at`
+
+// scanProject 读取一行项目记录，返回计数是否已缓存
+func scanProject(scan func(dest ...interface{}) error) (ProjectMeta, bool, error) {
+	var p ProjectMeta
+	var tags, countsUpdatedAt string
+	var archived int
+	if err := scan(&p.ID, &p.Name, &p.CreatedAt, &p.Description, &p.TaskType, &tags, &archived, &p.LastOpenedAt, &p.ImageCount, &p.AnnotationCount, &countsUpdatedAt); err != nil {
+		return p, false, err
+	}
+	p.Archived = archived != 0
+	p.Tags = []string{}
+	if tags != "" {
+		_ = json.Unmarshal([]byte(tags), &p.Tags)
+	}
+	return p, countsUpdatedAt != "", nil
+}
+
+// loadProjects 加载项目列表（按创建顺序）
+// 尚未缓存计数的项目会在这里统计一次
+func loadProjects(dataPath string) ([]ProjectMeta, error) {
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT ` + projectSelectColumns + ` FROM projects ORDER BY created_at ASC, rowid ASC;`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	projects := make([]ProjectMeta, 0)
+	var stale []int
+	for rows.Next() {
+		p, cached, err := scanProject(rows.Scan)
+		if err != nil {
+			rows.Close()
+			db.Close()
+			return nil, err
+		}
+		if !cached {
+			stale = append(stale, len(projects))
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+	db.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, i := range stale {
+		if imageCount, annotationCount, ok := refreshProjectCounts(dataPath, projects[i].ID); ok {
+			projects[i].ImageCount = imageCount
+			projects[i].AnnotationCount = annotationCount
+		}
+	}
+	return projects, nil
+}
+
+// getProject 按 ID 读取项目
+func getProject(dataPath, projectID string) (ProjectMeta, error) {
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		return ProjectMeta{}, err
+	}
+	defer db.Close()
+	p, _, err := scanProject(db.QueryRow(`SELECT `+projectSelectColumns+` FROM projects WHERE id = ?;`, projectID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ProjectMeta{}, errProjectNotFound
+	}
+	return p, err
+}
+
+// isUniqueViolation 判断是否违反唯一约束（项目名不区分大小写唯一）
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// createProject 新增项目记录，重名返回 errProjectNameExists
+func createProject(dataPath string, p ProjectMeta) error {
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tags := p.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, _ := json.Marshal(tags)
+	archived := 0
+	if p.Archived {
+		archived = 1
+	}
+	_, err = db.Exec(`INSERT INTO projects (id, name, created_at, description, task_type, tags, archived) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		p.ID, p.Name, p.CreatedAt, p.Description, p.TaskType, string(tagsJSON), archived)
+	if isUniqueViolation(err) {
+		return errProjectNameExists
+	}
+	return err
+}
+
+// projectMetaPatch 项目元数据的部分更新，nil 字段保持不变
+type projectMetaPatch struct {
+	Name        *string   `json:"name"`
+	Description *string   `json:"description"`
+	TaskType    *string   `json:"taskType"`
+	Tags        *[]string `json:"tags"`
+	Archived    *bool     `json:"archived"`
+}
+
+// updateProject 部分更新项目元数据
+func updateProject(dataPath, projectID string, patch projectMetaPatch) error {
+	sets := make([]string, 0, 5)
+	args := make([]interface{}, 0, 6)
+	if patch.Name != nil {
+		sets = append(sets, "name = ?")
+		args = append(args, *patch.Name)
+	}
+	if patch.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *patch.Description)
+	}
+	if patch.TaskType != nil {
+		sets = append(sets, "task_type = ?")
+		args = append(args, *patch.TaskType)
+	}
+	if patch.Tags != nil {
+		tags := *patch.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		tagsJSON, _ := json.Marshal(tags)
+		sets = append(sets, "tags = ?")
+		args = append(args, string(tagsJSON))
+	}
+	if patch.Archived != nil {
+		archived := 0
+		if *patch.Archived {
+			archived = 1
+		}
+		sets = append(sets, "archived = ?")
+		args = append(args, archived)
+	}
+	if len(sets) == 0 {
+		_, err := getProject(dataPath, projectID)
+		return err
+	}
+
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	args = append(args, projectID)
+	res, err := db.Exec(`UPDATE projects SET `+strings.Join(sets, ", ")+` WHERE id = ?;`, args...)
+	if isUniqueViolation(err) {
+		return errProjectNameExists
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errProjectNotFound
+	}
+	return nil
+}
+
+// deleteProjectRecord 删除项目记录
+func deleteProjectRecord(dataPath, projectID string) error {
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	res, err := db.Exec(`DELETE FROM projects WHERE id = ?;`, projectID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errProjectNotFound
+	}
+	return nil
+}
+
+// touchProjectOpened 记录最近打开时间
+func touchProjectOpened(dataPath, projectID string) error {
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	res, err := db.Exec(`UPDATE projects SET last_opened_at = ? WHERE id = ?;`, time.Now().UTC().Format(time.RFC3339), projectID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errProjectNotFound
+	}
+	return nil
+}
+
+// refreshProjectCounts 重新统计项目的图片数和标注数并写入目录库
+// 在导入、删除、保存标注等改变数量的操作之后调用；失败只记录日志
+func refreshProjectCounts(dataPath, projectID string) (int64, int64, bool) {
+	dbPath := filepath.Join(dataPath, "project_item", projectID, "db", "project.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return 0, 0, false
+	}
+	pdb, err := acquireProjectDB(dbPath)
+	if err != nil {
+		log.Printf("[Catalog] count project %s: open db failed: %v", projectID, err)
+		return 0, 0, false
+	}
+	var imageCount, annotationCount int64
+	err = pdb.QueryRow(`SELECT COUNT(*) FROM image_index WHERE deleted_in_project = 0;`).Scan(&imageCount)
+	if err == nil {
+		err = pdb.QueryRow(`SELECT COUNT(*) FROM annotations a JOIN image_index i ON a.image_id = i.id WHERE i.deleted_in_project = 0;`).Scan(&annotationCount)
+	}
+	pdb.Release()
+	if err != nil {
+		log.Printf("[Catalog] count project %s failed: %v", projectID, err)
+		return 0, 0, false
+	}
+
+	db, err := openCatalogDB(dataPath)
+	if err != nil {
+		log.Printf("[Catalog] count project %s: open catalog failed: %v", projectID, err)
+		return imageCount, annotationCount, true
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE projects SET image_count = ?, annotation_count = ?, counts_updated_at = ? WHERE id = ?;`,
+		imageCount, annotationCount, time.Now().UTC().Format(time.RFC3339), projectID); err != nil {
+		log.Printf("[Catalog] update counts for project %s failed: %v", projectID, err)
+	}
+	return imageCount, annotationCount, true
+}
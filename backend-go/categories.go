@@ -55,7 +55,7 @@ func handleProjectCategories(w http.ResponseWriter, r *http.Request) {
 		} else {
 			dbPath = filepath.Join(projectRoot, "db", "project.db")
 		}
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			log.Printf("project categories get: open db failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -63,61 +63,16 @@ func handleProjectCategories(w http.ResponseWriter, r *http.Request) {
 			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 			return
 		}
-		defer db.Close()
+		defer db.Release()
 
 		if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 			log.Printf("project categories get: set busy_timeout failed: %v", err)
 		}
-		if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS categories (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	type TEXT NOT NULL,
-	color TEXT NOT NULL,
-	sort_order INTEGER NOT NULL DEFAULT 0,
-	mate TEXT NOT NULL DEFAULT ''
-);
-`); err != nil {
-			log.Printf("project categories get: ensure table failed: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"schema_unavailable"}`))
-			return
-		}
-
-		hasMateColumn := false
-		colRows, colErr := db.Query(`PRAGMA table_info(categories);`)
-		if colErr == nil {
-			defer colRows.Close()
-			for colRows.Next() {
-				var cid int
-				var cname string
-				var ctype string
-				var notnull int
-				var dfltValue interface{}
-				var pk int
-				if err := colRows.Scan(&cid, &cname, &ctype, &notnull, &dfltValue, &pk); err == nil {
-					if cname == "mate" {
-						hasMateColumn = true
-						break
-					}
-				}
-			}
-		}
-
 		var rows *sql.Rows
-		if hasMateColumn {
-			if typeStr == "" {
-				rows, err = db.Query(`SELECT id, name, type, color, sort_order, mate FROM categories ORDER BY sort_order ASC, id ASC;`)
-			} else {
-				rows, err = db.Query(`SELECT id, name, type, color, sort_order, mate FROM categories WHERE type = ? ORDER BY sort_order ASC, id ASC;`, typeStr)
-			}
+		if typeStr == "" {
+			rows, err = db.Query(`SELECT id, name, type, color, sort_order, mate FROM categories ORDER BY sort_order ASC, id ASC;`)
 		} else {
-			if typeStr == "" {
-				rows, err = db.Query(`SELECT id, name, type, color, sort_order FROM categories ORDER BY sort_order ASC, id ASC;`)
-			} else {
-				rows, err = db.Query(`SELECT id, name, type, color, sort_order FROM categories WHERE type = ? ORDER BY sort_order ASC, id ASC;`, typeStr)
-			}
+			rows, err = db.Query(`SELECT id, name, type, color, sort_order, mate FROM categories WHERE type = ? ORDER BY sort_order ASC, id ASC;`, typeStr)
 		}
 		if err != nil {
 			log.Printf("project categories get: query failed: %v", err)
@@ -141,17 +96,9 @@ CREATE TABLE IF NOT EXISTS categories (
 		items := make([]categoryItem, 0, 32)
 		for rows.Next() {
 			var it categoryItem
-			if hasMateColumn {
-				if err := rows.Scan(&it.ID, &it.Name, &it.Type, &it.Color, &it.SortOrder, &it.Mate); err != nil {
-					log.Printf("project categories get: scan failed: %v", err)
-					continue
-				}
-			} else {
-				if err := rows.Scan(&it.ID, &it.Name, &it.Type, &it.Color, &it.SortOrder); err != nil {
-					log.Printf("project categories get: scan failed: %v", err)
-					continue
-				}
-				it.Mate = ""
+			if err := rows.Scan(&it.ID, &it.Name, &it.Type, &it.Color, &it.SortOrder, &it.Mate); err != nil {
+				log.Printf("project categories get: scan failed: %v", err)
+				continue
 			}
 			items = append(items, it)
 		}
@@ -245,6 +192,10 @@ CREATE TABLE IF NOT EXISTS categories (
 		}{Items: items})
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 	if r.Method == http.MethodPut {
 		var rawReq map[string]interface{}
 		bodyBytes, _ := io.ReadAll(r.Body)
@@ -267,6 +218,12 @@ CREATE TABLE IF NOT EXISTS categories (
 				_, _ = w.Write([]byte(`{"error":"projectId_and_id_required"}`))
 				return
 			}
+			if _, code := parseCategoryAttributes(mateStr); code != "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+				return
+			}
 
 			cfg, err := loadPathsConfig()
 			if err != nil {
@@ -278,7 +235,7 @@ CREATE TABLE IF NOT EXISTS categories (
 
 			projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
 			dbPath := filepath.Join(projectRoot, "db", "project.db")
-			db, err := openProjectDB(dbPath)
+			db, err := acquireProjectDB(dbPath)
 			if err != nil {
 				log.Printf("project categories put: open db failed: %v", err)
 				w.Header().Set("Content-Type", "application/json")
@@ -286,7 +243,7 @@ CREATE TABLE IF NOT EXISTS categories (
 				_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 				return
 			}
-			defer db.Close()
+			defer db.Release()
 
 			if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 				log.Printf("project categories put: set busy_timeout failed: %v", err)
@@ -377,7 +334,7 @@ CREATE TABLE IF NOT EXISTS categories (
 		}
 		projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
 		dbPath := filepath.Join(projectRoot, "db", "project.db")
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			log.Printf("project categories put: open db failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -385,40 +342,14 @@ CREATE TABLE IF NOT EXISTS categories (
 			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 			return
 		}
-		defer db.Close()
+		defer db.Release()
 		if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 			log.Printf("project categories put: set busy_timeout failed: %v", err)
 		}
 
-		hasMateColumn := false
-		colRows, colErr := db.Query(`PRAGMA table_info(categories);`)
-		if colErr == nil {
-			defer colRows.Close()
-			for colRows.Next() {
-				var cid int
-				var cname string
-				var ctype string
-				var notnull int
-				var dfltValue interface{}
-				var pk int
-				if err := colRows.Scan(&cid, &cname, &ctype, &notnull, &dfltValue, &pk); err == nil {
-					if cname == "mate" {
-						hasMateColumn = true
-						break
-					}
-				}
-			}
-		}
-		if !hasMateColumn {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte(`{"error":"mate_unsupported"}`))
-			return
-		}
-
-		var catType string
-		row := db.QueryRow(`SELECT type FROM categories WHERE id = ?;`, req.CategoryID)
-		if err := row.Scan(&catType); err != nil {
+		var catType, oldMate string
+		row := db.QueryRow(`SELECT type, COALESCE(mate, '') FROM categories WHERE id = ?;`, req.CategoryID)
+		if err := row.Scan(&catType, &oldMate); err != nil {
 			log.Printf("project categories put: category not found: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusNotFound)
@@ -432,12 +363,15 @@ CREATE TABLE IF NOT EXISTS categories (
 			return
 		}
 
-		mateObj := struct {
-			Keypoints []struct {
-				ID   int    `json:"id"`
-				Name string `json:"name"`
-			} `json:"keypoints"`
-		}{Keypoints: validKeypoints}
+		// 只替换 keypoints，保留 mate 中的属性定义等其他字段
+		mateObj := map[string]interface{}{}
+		if oldMate != "" {
+			_ = json.Unmarshal([]byte(oldMate), &mateObj)
+			if mateObj == nil {
+				mateObj = map[string]interface{}{}
+			}
+		}
+		mateObj["keypoints"] = validKeypoints
 		mateBytes, err := json.Marshal(mateObj)
 		if err != nil {
 			log.Printf("project categories put: marshal mate failed: %v", err)
@@ -491,7 +425,7 @@ CREATE TABLE IF NOT EXISTS categories (
 		}
 		projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
 		dbPath := filepath.Join(projectRoot, "db", "project.db")
-		db, err := openProjectDB(dbPath)
+		db, err := acquireProjectDB(dbPath)
 		if err != nil {
 			log.Printf("project categories delete: open db failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -499,7 +433,7 @@ CREATE TABLE IF NOT EXISTS categories (
 			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 			return
 		}
-		defer db.Close()
+		defer db.Release()
 		if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 			log.Printf("project categories delete: set busy_timeout failed: %v", err)
 		}
@@ -514,14 +448,38 @@ CREATE TABLE IF NOT EXISTS categories (
 			return
 		}
 
-		// 删除类别前先删除相关标注
-		_, _ = db.Exec(`DELETE FROM annotations WHERE category_id = ?;`, req.CategoryID)
-
-		// 更新没有任何标注的图片状态为未标注
-		_, _ = db.Exec(`UPDATE image_index SET annotation_status = 'none' 
+		// 删除类别前先删除相关标注，受影响图片逐张写入变更日志（revision 随之加一）
+		tx, err := db.Begin()
+		if err != nil {
+			log.Printf("project categories delete: begin tx failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"delete_failed"}`))
+			return
+		}
+		defer tx.Rollback()
+		batch := newAnnotationChangeBatch(annChangeSourceCategoryDelete)
+		err = batch.captureQuery(tx, `SELECT DISTINCT image_id FROM annotations WHERE category_id = ?;`, req.CategoryID)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM annotations WHERE category_id = ?;`, req.CategoryID)
+		}
+		if err == nil {
+			// 更新没有任何标注的图片状态为未标注
+			_, err = tx.Exec(`UPDATE image_index SET annotation_status = 'none' 
 			WHERE annotation_status = 'annotated' AND id NOT IN (SELECT DISTINCT image_id FROM annotations);`)
+		}
+		if err == nil {
+			err = batch.record(tx)
+		}
+		if err != nil {
+			log.Printf("project categories delete: delete annotations failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"delete_failed"}`))
+			return
+		}
 
-		res, err := db.Exec(`DELETE FROM categories WHERE id = ?;`, req.CategoryID)
+		res, err := tx.Exec(`DELETE FROM categories WHERE id = ?;`, req.CategoryID)
 		if err != nil {
 			log.Printf("project categories delete: delete failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -536,6 +494,13 @@ CREATE TABLE IF NOT EXISTS categories (
 			_, _ = w.Write([]byte(`{"error":"category_not_found"}`))
 			return
 		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("project categories delete: commit failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"delete_failed"}`))
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -583,6 +548,12 @@ CREATE TABLE IF NOT EXISTS categories (
 		_, _ = w.Write([]byte(`{"error":"category_type_invalid"}`))
 		return
 	}
+	if _, code := parseCategoryAttributes(mate); code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+		return
+	}
 
 	if len(color) != 7 || color[0] != '#' {
 		w.Header().Set("Content-Type", "application/json")
@@ -610,7 +581,7 @@ CREATE TABLE IF NOT EXISTS categories (
 
 	projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
 	dbPath := filepath.Join(projectRoot, "db", "project.db")
-	db, err := openProjectDB(dbPath)
+	db, err := acquireProjectDB(dbPath)
 	if err != nil {
 		log.Printf("project categories: open db failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -618,32 +589,12 @@ CREATE TABLE IF NOT EXISTS categories (
 		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
-	defer db.Close()
+	defer db.Release()
 
 	if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 		log.Printf("project categories: set busy_timeout failed: %v", err)
 	}
 
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS categories (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	type TEXT NOT NULL,
-	color TEXT NOT NULL,
-	sort_order INTEGER NOT NULL DEFAULT 0,
-	mate TEXT NOT NULL DEFAULT ''
-);
-`); err != nil {
-		log.Printf("project categories: ensure table failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"schema_unavailable"}`))
-		return
-	}
-	if _, err := db.Exec(`DROP INDEX IF EXISTS idx_categories_name;`); err != nil {
-		log.Printf("project categories: drop old index failed: %v", err)
-	}
-
 	var sortOrder int64
 	row := db.QueryRow(`SELECT COALESCE(MAX(sort_order), 0) + 1 FROM categories WHERE type = ?;`, typeStr)
 	if err := row.Scan(&sortOrder); err != nil {
@@ -697,6 +648,10 @@ func handleEditProjectCategory(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 
 	type editCategoryRequest struct {
 		ProjectID     string `json:"projectId"`
@@ -766,7 +721,7 @@ func handleEditProjectCategory(w http.ResponseWriter, r *http.Request) {
 	}
 	projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
 	dbPath := filepath.Join(projectRoot, "db", "project.db")
-	db, err := openProjectDB(dbPath)
+	db, err := acquireProjectDB(dbPath)
 	if err != nil {
 		log.Printf("project categories edit: open db failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -774,28 +729,11 @@ func handleEditProjectCategory(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
-	defer db.Close()
+	defer db.Release()
 	if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 		log.Printf("project categories edit: set busy_timeout failed: %v", err)
 	}
 
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS categories (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	type TEXT NOT NULL,
-	color TEXT NOT NULL,
-	sort_order INTEGER NOT NULL DEFAULT 0,
-	mate TEXT NOT NULL DEFAULT ''
-);
-`); err != nil {
-		log.Printf("project categories edit: ensure table failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"schema_unavailable"}`))
-		return
-	}
-
 	var existingID int64
 	row := db.QueryRow(`SELECT id FROM categories WHERE id = ?;`, req.CategoryID)
 	if err := row.Scan(&existingID); err != nil {
@@ -814,24 +752,53 @@ CREATE TABLE IF NOT EXISTS categories (
 
 	// 如果是合并操作
 	if req.Merge && req.MergeTargetID > 0 {
-		// 将所有标注从当前类别转移到目标类别
-		_, err := db.Exec(`UPDATE annotations SET category_id = ? WHERE category_id = ?;`, req.MergeTargetID, req.CategoryID)
+		// 将所有标注从当前类别转移到目标类别，受影响图片逐张写入变更日志（revision 随之加一），与删除类别在同一事务中
+		tx, err := db.Begin()
 		if err != nil {
+			log.Printf("project categories merge: begin tx failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"merge_failed"}`))
+			return
+		}
+		defer tx.Rollback()
+		batch := newAnnotationChangeBatch(annChangeSourceCategoryMerge)
+		if err := batch.captureQuery(tx, `SELECT DISTINCT image_id FROM annotations WHERE category_id = ?;`, req.CategoryID); err != nil {
+			log.Printf("project categories merge: load annotations failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"merge_failed"}`))
+			return
+		}
+		if _, err := tx.Exec(`UPDATE annotations SET category_id = ? WHERE category_id = ?;`, req.MergeTargetID, req.CategoryID); err != nil {
 			log.Printf("project categories merge: update annotations failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"merge_failed"}`))
 			return
 		}
+		if err := batch.record(tx); err != nil {
+			log.Printf("project categories merge: record changes failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"merge_failed"}`))
+			return
+		}
 		// 删除当前类别
-		_, err = db.Exec(`DELETE FROM categories WHERE id = ?;`, req.CategoryID)
-		if err != nil {
+		if _, err := tx.Exec(`DELETE FROM categories WHERE id = ?;`, req.CategoryID); err != nil {
 			log.Printf("project categories merge: delete category failed: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"merge_failed"}`))
 			return
 		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("project categories merge: commit failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"merge_failed"}`))
+			return
+		}
 		log.Printf("project categories merge: merged category %d into %d", req.CategoryID, req.MergeTargetID)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -878,6 +845,10 @@ func handleSortProjectCategories(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 
 	type sortCategoriesRequest struct {
 		ProjectID   string  `json:"projectId"`
@@ -919,7 +890,7 @@ func handleSortProjectCategories(w http.ResponseWriter, r *http.Request) {
 	}
 	projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
 	dbPath := filepath.Join(projectRoot, "db", "project.db")
-	db, err := openProjectDB(dbPath)
+	db, err := acquireProjectDB(dbPath)
 	if err != nil {
 		log.Printf("project categories sort: open db failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -927,28 +898,11 @@ func handleSortProjectCategories(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
-	defer db.Close()
+	defer db.Release()
 	if _, err := db.Exec(`PRAGMA busy_timeout = 5000;`); err != nil {
 		log.Printf("project categories sort: set busy_timeout failed: %v", err)
 	}
 
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS categories (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	type TEXT NOT NULL,
-	color TEXT NOT NULL,
-	sort_order INTEGER NOT NULL DEFAULT 0,
-	mate TEXT NOT NULL DEFAULT ''
-);
-`); err != nil {
-		log.Printf("project categories sort: ensure table failed: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"schema_unavailable"}`))
-		return
-	}
-
 	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("project categories sort: begin tx failed: %v", err)
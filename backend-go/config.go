@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 
@@ -35,11 +36,35 @@ const (
 type ImportTaskType string
 
 const (
-	taskTypeImportImages  ImportTaskType = "import_images"
-	taskTypeImportDataset ImportTaskType = "import_dataset"
-	taskTypeDeleteImages  ImportTaskType = "delete_images"
+	taskTypeImportImages   ImportTaskType = "import_images"
+	taskTypeImportDataset  ImportTaskType = "import_dataset"
+	taskTypeDeleteImages   ImportTaskType = "delete_images"
+	taskTypeExportProject  ImportTaskType = "export_project"
+	taskTypeImportProject  ImportTaskType = "import_project"
+	taskTypePurgeImages    ImportTaskType = "purge_images"
+	taskTypeStorageGC      ImportTaskType = "storage_gc"
+	taskTypeCheckLinks     ImportTaskType = "check_links"
+	taskTypeLocalizeImages ImportTaskType = "localize_images"
+	taskTypeHashImages     ImportTaskType = "hash_images"
 )
 
+// 导入图片时内容重复的处理策略
+const (
+	duplicatePolicySkip   = "skip"   // 跳过，不导入
+	duplicatePolicyRename = "rename" // 仍作为新图片导入，文件名自动加后缀
+	duplicatePolicyLink   = "link"   // 不新增记录，已有图片的引用计数 +1
+)
+
+// ImportDuplicate 导入时按内容或文件名判定为冲突的文件
+type ImportDuplicate struct {
+	Path             string `json:"path"`
+	Reason           string `json:"reason"`                     // content_exists / content_in_batch / name_conflict
+	Action           string `json:"action"`                     // skipped / renamed / linked
+	Filename         string `json:"filename,omitempty"`         // 重命名后的文件名
+	ExistingImageID  int64  `json:"existingImageId,omitempty"`  // 内容相同的已有图片
+	ExistingFilename string `json:"existingFilename,omitempty"` // 内容或名称冲突的已有文件名
+}
+
 // ImportTaskStatus 导入任务状态
 type ImportTaskStatus struct {
 	ID        string          `json:"id"`
@@ -50,6 +75,24 @@ type ImportTaskStatus struct {
 	Imported  int             `json:"imported"`
 	Total     int             `json:"total"`
 	Error     string          `json:"error,omitempty"`
+
+	Duplicates []ImportDuplicate `json:"duplicates,omitempty"`
+
+	// 项目归档任务的结果
+	ResultPath      string   `json:"resultPath,omitempty"`      // 导出的归档文件
+	ResultProjectID string   `json:"resultProjectId,omitempty"` // 导入后新项目的 ID
+	MissingFiles    []string `json:"missingFiles,omitempty"`    // 归档或本机缺失的文件
+	CorruptFiles    []string `json:"corruptFiles,omitempty"`    // 校验和不一致的文件
+
+	// 回收站清除任务的结果
+	FreedBytes      int64   `json:"freedBytes,omitempty"`      // 实际删除的文件大小
+	StillReferenced []int64 `json:"stillReferenced,omitempty"` // 仍有其他引用、只减少了引用计数的图片
+
+	// 存储回收任务的报告
+	GCReport *StorageGCReport `json:"gcReport,omitempty"`
+
+	// 外部图片检查任务的报告
+	LinkReport *LinkCheckReport `json:"linkReport,omitempty"`
 }
 
 // 全局变量
@@ -125,6 +168,23 @@ func loadPathsConfig() (*PathsConfig, error) {
 	return &cfg, nil
 }
 
+// savePathsConfig 保存配置文件，先写临时文件再替换，避免中途失败留下半个文件
+func savePathsConfig(cfg *PathsConfig) error {
+	configPath := getConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := configPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, configPath)
+}
+
 // getDataPath 获取数据目录路径，失败时返回默认路径
 func getDataPath() string {
 	cfg, err := loadPathsConfig()
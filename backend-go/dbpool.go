@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 项目数据库句柄缓存：每个 project.db（含版本快照）只保留一个 *sql.DB
+// 空闲超过 projectDBIdleTimeout 的句柄会被关闭；回滚、删除等需要独占文件的操作
+// 先调用 closeProjectDBsUnder 释放句柄
+const (
+	projectDBIdleTimeout  = 5 * time.Minute
+	projectDBJanitorEvery = time.Minute
+	projectDBCloseWait    = 10 * time.Second
+	projectDBMaxOpenConns = 4
+)
+
+// 热点查询，按 SQL 文本缓存预编译语句
+const (
+	sqlListProjectImages   = `SELECT id, filename, original_rel_path, thumb_rel_path, COALESCE(annotation_status, 'none'), width, height, file_size, format, sha256 FROM image_index WHERE deleted_in_project = 0 ORDER BY id ASC;`
+	sqlAnnotationsByImage  = `SELECT id, image_id, category_id, type, data, created_at, updated_at FROM annotations WHERE image_id = ?;`
+	sqlInsertAnnotation    = `INSERT INTO annotations (image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?);`
+	sqlUpdateAnnotation    = `UPDATE annotations SET category_id = ?, type = ?, data = ?, updated_at = ? WHERE id = ? AND image_id = ?;`
+	sqlDeleteAnnotation    = `DELETE FROM annotations WHERE id = ? AND image_id = ?;`
+	sqlUpdateImageAnnState = `UPDATE image_index SET annotation_status = ? WHERE id = ?;`
+)
+
+// projectDBEntry 缓存中的一个数据库
+type projectDBEntry struct {
+	path     string
+	db       *sql.DB
+	refs     int
+	lastUsed time.Time
+	removed  bool // 已从缓存移除，引用归零后关闭
+	done     chan struct{}
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+}
+
+// projectDBHandle 借出的数据库句柄，用完调用 Release（不要 Close）
+type projectDBHandle struct {
+	*sql.DB
+	entry    *projectDBEntry
+	released bool
+}
+
+var (
+	projectDBPoolMu sync.Mutex
+	projectDBPool   = map[string]*projectDBEntry{}
+)
+
+// acquireProjectDB 从缓存获取项目数据库，首次打开时执行 schema 迁移
+func acquireProjectDB(dbPath string) (*projectDBHandle, error) {
+	key := filepath.Clean(dbPath)
+
+	projectDBPoolMu.Lock()
+	if e, ok := projectDBPool[key]; ok {
+		e.refs++
+		e.lastUsed = time.Now()
+		projectDBPoolMu.Unlock()
+		return &projectDBHandle{DB: e.db, entry: e}, nil
+	}
+	projectDBPoolMu.Unlock()
+
+	// 迁移可能较慢，不持有缓存锁打开
+	db, err := openPooledProjectDB(key)
+	if err != nil {
+		return nil, err
+	}
+
+	projectDBPoolMu.Lock()
+	if e, ok := projectDBPool[key]; ok {
+		// 并发请求已先打开
+		e.refs++
+		e.lastUsed = time.Now()
+		projectDBPoolMu.Unlock()
+		db.Close()
+		return &projectDBHandle{DB: e.db, entry: e}, nil
+	}
+	e := &projectDBEntry{
+		path:     key,
+		db:       db,
+		refs:     1,
+		lastUsed: time.Now(),
+		done:     make(chan struct{}),
+		stmts:    map[string]*sql.Stmt{},
+	}
+	projectDBPool[key] = e
+	projectDBPoolMu.Unlock()
+	return &projectDBHandle{DB: db, entry: e}, nil
+}
+
+// openPooledProjectDB 打开供缓存使用的数据库；PRAGMA 通过 DSN 作用到池中每个连接
+func openPooledProjectDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(projectDBMaxOpenConns)
+	db.SetMaxIdleConns(projectDBMaxOpenConns)
+	if err := migrateProjectDB(db, dbPath); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Release 归还句柄
+func (h *projectDBHandle) Release() {
+	if h == nil || h.released {
+		return
+	}
+	h.released = true
+	e := h.entry
+
+	projectDBPoolMu.Lock()
+	e.refs--
+	e.lastUsed = time.Now()
+	closeNow := e.removed && e.refs == 0
+	projectDBPoolMu.Unlock()
+
+	if closeNow {
+		e.close()
+	}
+}
+
+// Prepared 返回缓存的预编译语句，句柄关闭时统一释放
+func (h *projectDBHandle) Prepared(query string) (*sql.Stmt, error) {
+	e := h.entry
+	e.stmtMu.Lock()
+	defer e.stmtMu.Unlock()
+	if stmt, ok := e.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := e.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	e.stmts[query] = stmt
+	return stmt, nil
+}
+
+// close 合并 WAL 后关闭数据库
+func (e *projectDBEntry) close() {
+	e.stmtMu.Lock()
+	for _, stmt := range e.stmts {
+		stmt.Close()
+	}
+	e.stmts = map[string]*sql.Stmt{}
+	e.stmtMu.Unlock()
+
+	if _, err := e.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		log.Printf("[ProjectDB] checkpoint %s failed: %v", e.path, err)
+	}
+	if err := e.db.Close(); err != nil {
+		log.Printf("[ProjectDB] close %s failed: %v", e.path, err)
+	}
+	close(e.done)
+}
+
+// closeProjectDBsUnder 关闭 root 目录下所有缓存的数据库，等待借出的句柄归还
+// 在删除、替换数据库文件之前调用（Windows 下打开的文件无法删除）
+func closeProjectDBsUnder(root string) {
+	var waiting []*projectDBEntry
+
+	projectDBPoolMu.Lock()
+	for key, e := range projectDBPool {
+		if !isPathWithin(root, key) {
+			continue
+		}
+		delete(projectDBPool, key)
+		e.removed = true
+		waiting = append(waiting, e)
+		if e.refs == 0 {
+			go e.close()
+		}
+	}
+	projectDBPoolMu.Unlock()
+
+	deadline := time.After(projectDBCloseWait)
+	for _, e := range waiting {
+		select {
+		case <-e.done:
+		case <-deadline:
+			log.Printf("[ProjectDB] %s still in use after %s, will close on release", e.path, projectDBCloseWait)
+			return
+		}
+	}
+}
+
+// startProjectDBJanitor 定期关闭空闲句柄
+func startProjectDBJanitor() {
+	go func() {
+		ticker := time.NewTicker(projectDBJanitorEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			evictIdleProjectDBs(projectDBIdleTimeout)
+		}
+	}()
+}
+
+// evictIdleProjectDBs 关闭无人使用且空闲超过 idle 的句柄
+func evictIdleProjectDBs(idle time.Duration) {
+	var evicted []*projectDBEntry
+
+	projectDBPoolMu.Lock()
+	for key, e := range projectDBPool {
+		if e.refs == 0 && time.Since(e.lastUsed) >= idle {
+			delete(projectDBPool, key)
+			e.removed = true
+			evicted = append(evicted, e)
+		}
+	}
+	projectDBPoolMu.Unlock()
+
+	for _, e := range evicted {
+		e.close()
+	}
+}
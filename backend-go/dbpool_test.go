@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestProjectDB 在临时目录创建项目库，测试结束时关闭
+func openTestProjectDB(t *testing.T) *projectDBHandle {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := acquireProjectDB(filepath.Join(dir, "project.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Release()
+		closeProjectDBsUnder(dir)
+	})
+	return db
+}
+
+func TestReleaseKeepsPooledDBOpen(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "project.db")
+	defer closeProjectDBsUnder(dir)
+
+	first, err := acquireProjectDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled := first.DB
+	first.Release()
+	first.Release() // 重复归还不能让引用计数变成负数
+	if err := pooled.Ping(); err != nil {
+		t.Fatalf("pooled db closed after release: %v", err)
+	}
+
+	second, err := acquireProjectDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Release()
+	if second.DB != pooled {
+		t.Fatal("released handle was not reused from the pool")
+	}
+	if _, err := second.Exec(`SELECT COUNT(*) FROM image_index;`); err != nil {
+		t.Fatalf("query on reacquired handle failed: %v", err)
+	}
+	projectDBPoolMu.Lock()
+	refs := second.entry.refs
+	projectDBPoolMu.Unlock()
+	if refs != 1 {
+		t.Fatalf("refs = %d, want 1", refs)
+	}
+}
+
+func TestCollectExternalImagePathsReleasesHandles(t *testing.T) {
+	db := openTestProjectDB(t)
+	if _, err := db.Exec(`INSERT INTO image_index (id, filename, original_rel_path, thumb_rel_path, created_at) VALUES
+		(1, 'a', 'images/a.jpg', 'images/a.jpg', 't'),
+		(2, 'b', ?, ?, 't');`, filepath.ToSlash(filepath.Join(t.TempDir(), "b.jpg")), ""); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := db.entry.path
+	for i := 0; i < 2; i++ {
+		if got := collectExternalImagePaths([]string{dbPath}); len(got) != 1 {
+			t.Fatalf("external paths = %v, want 1", got)
+		}
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("pooled db closed by collectExternalImagePaths: %v", err)
+	}
+	projectDBPoolMu.Lock()
+	refs := db.entry.refs
+	projectDBPoolMu.Unlock()
+	if refs != 1 {
+		t.Fatalf("refs = %d, want 1", refs)
+	}
+}
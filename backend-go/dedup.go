@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// importDedupEntry 某个内容摘要的归属图片，ImageID 为 0 表示本批新导入
+type importDedupEntry struct {
+	ImageID  int64
+	Filename string
+}
+
+// importDedupIndex 导入过程中按内容摘要和文件名判重，供多个 worker 并发使用
+type importDedupIndex struct {
+	mu     sync.Mutex
+	byHash map[string]importDedupEntry
+	names  map[string]struct{}
+}
+
+// loadImportDedupIndex 读取项目已有图片的摘要与文件名
+// 旧项目中尚未补全摘要的记录会先补全，否则同一张图无法被识别为重复
+func loadImportDedupIndex(dbPath, projectRoot, originalsDir string) (*importDedupIndex, error) {
+	if _, err := backfillImageMetaDB(dbPath, projectRoot, make(map[string]imageFileMeta)); err != nil {
+		return nil, err
+	}
+
+	idx := &importDedupIndex{
+		byHash: make(map[string]importDedupEntry),
+		names:  make(map[string]struct{}),
+	}
+	if entries, err := os.ReadDir(originalsDir); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() && entry.Name() != "" {
+				idx.names[entry.Name()] = struct{}{}
+			}
+		}
+	}
+
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT id, filename, sha256, deleted_in_project FROM image_index ORDER BY id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var filename, hash string
+		var deleted int
+		if err := rows.Scan(&id, &filename, &hash, &deleted); err != nil {
+			return nil, err
+		}
+		// 已删除图片的文件名仍然占用，但内容可以重新导入
+		idx.names[filename] = struct{}{}
+		if deleted != 0 || hash == "" {
+			continue
+		}
+		if _, exists := idx.byHash[hash]; !exists {
+			idx.byHash[hash] = importDedupEntry{ImageID: id, Filename: filename}
+		}
+	}
+	return idx, rows.Err()
+}
+
+// claim 判定一个源文件是否导入及使用的文件名
+// 返回空文件名表示不导入；有冲突时返回对应的 ImportDuplicate
+func (idx *importDedupIndex) claim(srcPath, name, hash, policy string) (string, *ImportDuplicate) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if hash != "" {
+		if existing, ok := idx.byHash[hash]; ok {
+			dup := &ImportDuplicate{
+				Path:             srcPath,
+				Reason:           "content_exists",
+				ExistingImageID:  existing.ImageID,
+				ExistingFilename: existing.Filename,
+			}
+			if existing.ImageID == 0 {
+				dup.Reason = "content_in_batch"
+			}
+			switch policy {
+			case duplicatePolicyLink:
+				dup.Action = "linked"
+				return "", dup
+			case duplicatePolicyRename:
+				dup.Action = "imported"
+				if _, taken := idx.names[name]; taken {
+					name = idx.uniqueName(name)
+					dup.Action = "renamed"
+				}
+				dup.Filename = name
+				idx.names[name] = struct{}{}
+				return name, dup
+			default:
+				dup.Action = "skipped"
+				return "", dup
+			}
+		}
+	}
+
+	var dup *ImportDuplicate
+	if _, taken := idx.names[name]; taken {
+		renamed := idx.uniqueName(name)
+		dup = &ImportDuplicate{
+			Path:             srcPath,
+			Reason:           "name_conflict",
+			Action:           "renamed",
+			Filename:         renamed,
+			ExistingFilename: name,
+		}
+		name = renamed
+	}
+	idx.names[name] = struct{}{}
+	if hash != "" {
+		idx.byHash[hash] = importDedupEntry{Filename: name}
+	}
+	return name, dup
+}
+
+// release 文件写入失败时撤销 claim 占用的文件名和摘要
+func (idx *importDedupIndex) release(name, hash string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.names, name)
+	if entry, ok := idx.byHash[hash]; ok && entry.ImageID == 0 && entry.Filename == name {
+		delete(idx.byHash, hash)
+	}
+}
+
+// uniqueName 生成未被占用的文件名：name_1.jpg、name_2.jpg ...（调用方持有锁）
+func (idx *importDedupIndex) uniqueName(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if _, taken := idx.names[candidate]; !taken {
+			return candidate
+		}
+	}
+}
+
+// reportImportDuplicate 记录到导入任务状态中
+func reportImportDuplicate(taskID string, dup ImportDuplicate) {
+	importTasksMu.Lock()
+	if task, ok := importTasks[taskID]; ok {
+		task.Duplicates = append(task.Duplicates, dup)
+	}
+	importTasksMu.Unlock()
+}
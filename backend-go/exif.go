@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// EXIF 方向（0x0112）：1 为正常，2-8 为各种旋转/镜像；image_index 中 0 表示尚未读取
+const (
+	exifOrientationUnknown = 0
+	exifOrientationNormal  = 1
+	// exifScanLimit 读取文件头的字节数；APP1 段最长 64KB，前面最多再有一个 APP0
+	exifScanLimit = 128 << 10
+	// orientedImageQuality 转正后重新编码 JPEG 的质量（导出、原图预览）
+	orientedImageQuality = 95
+)
+
+// exifOrientation 从文件开头的字节中解析方向，支持 JPEG（APP1 Exif）和 TIFF；读不到时返回 1
+func exifOrientation(head []byte) int {
+	if isTIFFHeader(head) {
+		return tiffOrientation(head)
+	}
+	if len(head) < 4 || head[0] != 0xFF || head[1] != 0xD8 {
+		return exifOrientationNormal
+	}
+	pos := 2
+	for pos+4 <= len(head) {
+		if head[pos] != 0xFF {
+			return exifOrientationNormal
+		}
+		marker := head[pos+1]
+		switch {
+		case marker == 0xFF: // 填充字节
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			pos += 2
+			continue
+		case marker == 0xDA || marker == 0xD9: // 扫描数据开始后不会再有 APP1
+			return exifOrientationNormal
+		}
+		segLen := int(binary.BigEndian.Uint16(head[pos+2:]))
+		if segLen < 2 {
+			return exifOrientationNormal
+		}
+		start, end := pos+4, pos+2+segLen
+		if marker == 0xE1 && end <= len(head) && end-start > 6 && string(head[start:start+6]) == "Exif\x00\x00" {
+			return tiffOrientation(head[start+6 : end])
+		}
+		pos = end
+	}
+	return exifOrientationNormal
+}
+
+// tiffOrientation 在 TIFF 结构的 IFD0 中查找方向标签
+func tiffOrientation(b []byte) int {
+	if len(b) < 8 {
+		return exifOrientationNormal
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return exifOrientationNormal
+	}
+	ifd := int(order.Uint32(b[4:]))
+	if ifd < 8 || ifd+2 > len(b) {
+		return exifOrientationNormal
+	}
+	n := int(order.Uint16(b[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(b) {
+			break
+		}
+		// 类型 3 为 SHORT，值直接存放在条目内
+		if order.Uint16(b[e:]) == 0x0112 && order.Uint16(b[e+2:]) == 3 {
+			if o := int(order.Uint16(b[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return exifOrientationNormal
+}
+
+// readEXIFOrientation 读取文件的 EXIF 方向，失败时返回 1
+func readEXIFOrientation(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return exifOrientationNormal
+	}
+	defer f.Close()
+	head := make([]byte, exifScanLimit)
+	n, _ := io.ReadFull(f, head)
+	return exifOrientation(head[:n])
+}
+
+// orientationSwapsAxes 方向 5-8 需要转置，显示尺寸为原始宽高互换
+func orientationSwapsAxes(o int) bool {
+	return o >= 5 && o <= 8
+}
+
+// applyEXIFOrientation 按方向旋转/镜像像素，返回正向显示的图像
+func applyEXIFOrientation(img image.Image, o int) image.Image {
+	if o <= exifOrientationNormal || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientationSwapsAxes(o) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:sy*src.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
+
+// decodeOrientedImage 解码图片并按 EXIF 方向转正，同时返回方向
+func decodeOrientedImage(path string) (image.Image, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, exifOrientationNormal, err
+	}
+	defer f.Close()
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	o := exifOrientation(head[:n])
+	img, _, err := decodeImageFile(f)
+	if err != nil {
+		return nil, o, err
+	}
+	return applyEXIFOrientation(img, o), o, nil
+}
+
+// copyImageOriented 复制图片；带旋转方向的图片改为写出转正后的像素（按目标扩展名编码，不再带 EXIF）
+// 无法重新编码的格式仍原样复制
+func copyImageOriented(src, dst string, orientation int) error {
+	ext := strings.ToLower(filepath.Ext(dst))
+	if orientation <= exifOrientationNormal || (ext != ".jpg" && ext != ".jpeg" && ext != ".png") {
+		return copyFile(src, dst)
+	}
+	img, _, err := decodeOrientedImage(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if ext == ".png" {
+		err = png.Encode(out, img)
+	} else {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: orientedImageQuality})
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
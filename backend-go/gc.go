@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// 存储回收的垃圾类别
+const (
+	gcCategoryOrphanOriginals = "orphan_originals" // images/originals 中无任何库引用的原图
+	gcCategoryOrphanThumbs    = "orphan_thumbs"    // images/thumbs 中无任何库引用的缩略图
+	gcCategoryTempDatasets    = "temp_datasets"    // 训练失败或中断后残留的 training_datasets/<taskId>
+)
+
+// gcSampleLimit 报告中每类最多列出的路径数
+const gcSampleLimit = 50
+
+// StorageGCCategory 单类垃圾的统计
+type StorageGCCategory struct {
+	Files   int      `json:"files"`
+	Bytes   int64    `json:"bytes"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+// StorageGCReport 回收报告；DryRun 为 true 时只统计不删除
+type StorageGCReport struct {
+	DryRun       bool                          `json:"dryRun"`
+	Categories   map[string]*StorageGCCategory `json:"categories"`
+	TotalBytes   int64                         `json:"totalBytes"`
+	FreedBytes   int64                         `json:"freedBytes"`
+	SkippedPaths []string                      `json:"skippedPaths,omitempty"` // 无法确认引用而跳过的项目或删除失败的文件
+}
+
+// add 记录一项垃圾
+func (r *StorageGCReport) add(category, path string, size int64) {
+	c, ok := r.Categories[category]
+	if !ok {
+		c = &StorageGCCategory{}
+		r.Categories[category] = c
+	}
+	c.Files++
+	c.Bytes += size
+	if len(c.Samples) < gcSampleLimit {
+		c.Samples = append(c.Samples, path)
+	}
+	r.TotalBytes += size
+}
+
+// handleStorageGC 启动存储回收任务，默认 dry-run
+func handleStorageGC(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		DryRun     *bool    `json:"dryRun"`
+		Categories []string `json:"categories"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+			return
+		}
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+	categories := map[string]bool{
+		gcCategoryOrphanOriginals: true,
+		gcCategoryOrphanThumbs:    true,
+		gcCategoryTempDatasets:    true,
+	}
+	if len(req.Categories) > 0 {
+		for k := range categories {
+			categories[k] = false
+		}
+		for _, c := range req.Categories {
+			if _, ok := categories[c]; !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"category_invalid"}`))
+				return
+			}
+			categories[c] = true
+		}
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+	// dry-run 同样持有 IO 锁，保证统计时没有导入在写文件
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:       taskID,
+		TaskType: taskTypeStorageGC,
+		Phase:    importPhaseScanning,
+	}
+	importTasksMu.Unlock()
+
+	go runStorageGC(cfg.DataPath, taskID, dryRun, categories)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID, "dryRun": dryRun})
+}
+
+// runStorageGC 扫描（并在非 dry-run 时删除）数据目录中的垃圾文件
+func runStorageGC(dataPath, taskID string, dryRun bool, categories map[string]bool) {
+	defer releaseIOLock(taskID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[StorageGC] Task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_gc_task")
+		}
+	}()
+
+	report := &StorageGCReport{DryRun: dryRun, Categories: map[string]*StorageGCCategory{}}
+	remove := func(category, path string, size int64, isDir bool) {
+		if !dryRun {
+			var err error
+			if isDir {
+				err = os.RemoveAll(path)
+			} else {
+				err = os.Remove(path)
+			}
+			if err != nil {
+				log.Printf("[StorageGC] Task %s remove %s failed: %v", taskID, path, err)
+				report.SkippedPaths = append(report.SkippedPaths, path)
+				return
+			}
+			report.FreedBytes += size
+		}
+		report.add(category, path, size)
+	}
+
+	var projectDirs []string
+	if entries, err := os.ReadDir(filepath.Join(dataPath, "project_item")); err == nil {
+		for _, e := range entries {
+			if e.IsDir() {
+				projectDirs = append(projectDirs, filepath.Join(dataPath, "project_item", e.Name()))
+			}
+		}
+	}
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Total = len(projectDirs) + 1
+		if !dryRun {
+			task.Phase = importPhaseDeleting
+		}
+	})
+
+	if categories[gcCategoryOrphanOriginals] || categories[gcCategoryOrphanThumbs] {
+		for idx, projectRoot := range projectDirs {
+			// 任一数据库读不出来就整个项目跳过，宁可漏删也不误删
+			referenced, err := collectReferencedImagePaths(projectRoot)
+			if err != nil {
+				log.Printf("[StorageGC] Task %s skip project %s: %v", taskID, projectRoot, err)
+				report.SkippedPaths = append(report.SkippedPaths, projectRoot)
+			} else {
+				for category, sub := range map[string]string{
+					gcCategoryOrphanOriginals: "originals",
+					gcCategoryOrphanThumbs:    "thumbs",
+				} {
+					if !categories[category] {
+						continue
+					}
+					for _, f := range findUnreferencedImageFiles(projectRoot, sub, referenced) {
+						remove(category, f.path, f.size, false)
+					}
+				}
+			}
+			done := idx + 1
+			updateImportTask(taskID, func(task *ImportTaskStatus) {
+				task.Imported = done
+				task.Progress = done * 100 / task.Total
+			})
+		}
+	}
+
+	if categories[gcCategoryTempDatasets] {
+		for _, d := range findStaleTrainingDatasets(dataPath) {
+			remove(gcCategoryTempDatasets, d.path, d.size, true)
+		}
+	}
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+		task.Imported = task.Total
+		task.GCReport = report
+	})
+	log.Printf("[StorageGC] Task %s done (dryRun=%v): %d bytes reclaimable, %d bytes freed", taskID, dryRun, report.TotalBytes, report.FreedBytes)
+}
+
+// gcEntry 待回收的文件或目录
+type gcEntry struct {
+	path string
+	size int64
+}
+
+// findUnreferencedImageFiles 列出 images/<sub> 下未被引用的文件
+func findUnreferencedImageFiles(projectRoot, sub string, referenced map[string]struct{}) []gcEntry {
+	var result []gcEntry
+	dir := filepath.Join(projectRoot, "images", sub)
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(projectRoot, p)
+		if err != nil {
+			return nil
+		}
+		// 库中可能存相对路径，也可能存项目内的绝对路径
+		if _, ok := referenced[normalizeImageRef(rel)]; ok {
+			return nil
+		}
+		if _, ok := referenced[normalizeImageRef(p)]; ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		result = append(result, gcEntry{path: p, size: info.Size()})
+		return nil
+	})
+	return result
+}
+
+// findStaleTrainingDatasets 列出不属于排队中或运行中训练任务的临时数据集目录
+func findStaleTrainingDatasets(dataPath string) []gcEntry {
+	root := filepath.Join(dataPath, "training_datasets")
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+
+	active := make(map[string]struct{})
+	trainingTasks.RLock()
+	for id, t := range trainingTasks.tasks {
+		if t.Status == "pending" || t.Status == "running" {
+			active[id] = struct{}{}
+			if t.DatasetPath != "" {
+				active[filepath.Base(t.DatasetPath)] = struct{}{}
+			}
+		}
+	}
+	trainingTasks.RUnlock()
+
+	var result []gcEntry
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, ok := active[e.Name()]; ok {
+			continue
+		}
+		p := filepath.Join(root, e.Name())
+		var size int64
+		_ = filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					size += info.Size()
+				}
+			}
+			return nil
+		})
+		result = append(result, gcEntry{path: p, size: size})
+	}
+	return result
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"io"
+	"math"
+	"os"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/tiff/lzw"
+	_ "golang.org/x/image/webp"
+)
+
+// 高位深（16 位、浮点）图片的显示映射窗口
+const (
+	displayWindowPercentile = "percentile" // 0.5%-99.5% 分位，忽略少量极亮/极暗像素
+	displayWindowMinMax     = "minmax"     // 全部像素的最小/最大值
+
+	displayWindowLowPercentile  = 0.005
+	displayWindowHighPercentile = 0.995
+)
+
+// parseDisplayWindow 解析 window 参数，空值为默认的分位窗口
+func parseDisplayWindow(s string) (string, bool) {
+	switch s {
+	case "", displayWindowPercentile:
+		return displayWindowPercentile, true
+	case displayWindowMinMax:
+		return displayWindowMinMax, true
+	}
+	return "", false
+}
+
+// needsDisplayCopy 浏览器不能直接正确显示的原图：带旋转方向、高位深或 TIFF
+func needsDisplayCopy(format string, bitDepth, orientation int) bool {
+	return orientation > exifOrientationNormal || bitDepth > 8 || format == "tiff"
+}
+
+// imageFileNeedsDisplayCopy 读取文件头判断是否需要显示副本（尚未入库的文件）
+func imageFileNeedsDisplayCopy(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	_, format, bitDepth, err := imageFileConfig(f)
+	if err != nil {
+		return false
+	}
+	return needsDisplayCopy(format, bitDepth, exifOrientation(head[:n]))
+}
+
+// isTIFFHeader 判断文件头是否为 TIFF
+func isTIFFHeader(head []byte) bool {
+	return len(head) >= 4 && (string(head[:4]) == "II*\x00" || string(head[:4]) == "MM\x00*")
+}
+
+// imageBitDepth 按颜色模型推断每通道位数
+func imageBitDepth(m color.Model) int {
+	switch m {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return 16
+	}
+	return 8
+}
+
+// imageFileConfig 读取尺寸、格式和位深；使用 ReadAt，不改变文件读取位置
+// 浮点 TIFF 标准库和 x/image 都不支持，由 floatTIFF 处理
+func imageFileConfig(f *os.File) (image.Config, string, int, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return image.Config{}, "", 0, err
+	}
+	sr := io.NewSectionReader(f, 0, info.Size())
+	head := make([]byte, 4)
+	if _, err := f.ReadAt(head, 0); err == nil && isTIFFHeader(head) {
+		if ft, err := readFloatTIFF(sr); err == nil {
+			return ft.config(), "tiff", ft.bits, nil
+		} else if !errors.Is(err, errNotFloatTIFF) {
+			return image.Config{}, "", 0, err
+		}
+		cfg, err := tiff.DecodeConfig(sr)
+		if err != nil {
+			return image.Config{}, "", 0, err
+		}
+		return cfg, "tiff", imageBitDepth(cfg.ColorModel), nil
+	}
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(sr))
+	if err != nil {
+		return image.Config{}, "", 0, err
+	}
+	return cfg, format, imageBitDepth(cfg.ColorModel), nil
+}
+
+// decodeImageFile 解码图片文件；TIFF 直接按随机读取解码，不把整个文件读入内存
+func decodeImageFile(f *os.File) (image.Image, string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	sr := io.NewSectionReader(f, 0, info.Size())
+	head := make([]byte, 4)
+	if _, err := f.ReadAt(head, 0); err == nil && isTIFFHeader(head) {
+		if ft, err := readFloatTIFF(sr); err == nil {
+			img, err := ft.decode(sr)
+			return img, "tiff", err
+		} else if !errors.Is(err, errNotFloatTIFF) {
+			return nil, "", err
+		}
+		img, err := tiff.Decode(sr)
+		return img, "tiff", err
+	}
+	return image.Decode(bufio.NewReader(sr))
+}
+
+// toDisplayImage 把 16 位图片按窗口线性映射到 8 位用于预览；8 位图片原样返回
+// 彩色图三个通道共用一个窗口，保持色彩比例
+func toDisplayImage(img image.Image, window string) image.Image {
+	// 直方图按位深分配，8 位图片不需要
+	bits := imageBitDepth(img.ColorModel())
+	if bits <= 8 {
+		return img
+	}
+	hist := make([]int, 1<<bits)
+	total := displayHistogram(img, hist)
+	return mapDisplayImage(img, displayLUT(hist, total, window))
+}
+
+// asNRGBA64 彩色 16 位图片转为非预乘值
+func asNRGBA64(img image.Image) *image.NRGBA64 {
+	if n, ok := img.(*image.NRGBA64); ok {
+		return n
+	}
+	b := img.Bounds()
+	n := image.NewNRGBA64(b)
+	draw.Draw(n, b, img, b.Min, draw.Src)
+	return n
+}
+
+// displayHistogram 把 16 位图片的像素值累加到直方图，返回计入的样本数；全透明像素不参与统计
+// 分段读取的图片逐段累加，之后用同一个窗口映射每一段
+func displayHistogram(img image.Image, hist []int) int {
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Gray16:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, y):src.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 2 {
+				hist[int(row[i])<<8|int(row[i+1])]++
+			}
+		}
+		return b.Dx() * b.Dy()
+	case *image.RGBA64, *image.NRGBA64:
+		n := asNRGBA64(src)
+		total := 0
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := n.Pix[n.PixOffset(b.Min.X, y):n.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 8 {
+				if row[i+6] == 0 && row[i+7] == 0 {
+					continue
+				}
+				for c := 0; c < 6; c += 2 {
+					hist[int(row[i+c])<<8|int(row[i+c+1])]++
+				}
+				total += 3
+			}
+		}
+		return total
+	}
+	return 0
+}
+
+// mapDisplayImage 按查找表把 16 位图片映射到 8 位，Bounds 不变
+func mapDisplayImage(img image.Image, lut []uint8) image.Image {
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Gray16:
+		dst := image.NewGray(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, y):src.PixOffset(b.Max.X, y)]
+			out := dst.Pix[dst.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(row); i += 2 {
+				out[i/2] = lut[int(row[i])<<8|int(row[i+1])]
+			}
+		}
+		return dst
+	case *image.RGBA64, *image.NRGBA64:
+		n := asNRGBA64(src)
+		dst := image.NewNRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := n.Pix[n.PixOffset(b.Min.X, y):n.PixOffset(b.Max.X, y)]
+			out := dst.Pix[dst.PixOffset(b.Min.X, y):]
+			for i := 0; i < len(row); i += 8 {
+				o := i / 2
+				out[o] = lut[int(row[i])<<8|int(row[i+1])]
+				out[o+1] = lut[int(row[i+2])<<8|int(row[i+3])]
+				out[o+2] = lut[int(row[i+4])<<8|int(row[i+5])]
+				out[o+3] = row[i+6]
+			}
+		}
+		return dst
+	}
+	return img
+}
+
+// displayLUT 高位深值到 8 位的查找表，大小与直方图一致
+func displayLUT(hist []int, total int, window string) []uint8 {
+	lo, hi := displayWindowRange(hist, total, window)
+	lut := make([]uint8, len(hist))
+	for v := range lut {
+		switch {
+		case v <= lo:
+			lut[v] = 0
+		case v >= hi:
+			lut[v] = 255
+		default:
+			lut[v] = uint8((v - lo) * 255 / (hi - lo))
+		}
+	}
+	return lut
+}
+
+// displayWindowRange 按直方图计算映射窗口 [lo, hi]，保证 hi > lo
+func displayWindowRange(hist []int, total int, window string) (int, int) {
+	lo, hi := 0, len(hist)-1
+	if total == 0 {
+		return lo, hi
+	}
+	lowCount, highCount := 1, total
+	if window != displayWindowMinMax {
+		lowCount = max(1, int(float64(total)*displayWindowLowPercentile))
+		highCount = max(1, int(math.Ceil(float64(total)*displayWindowHighPercentile)))
+	}
+	acc := 0
+	lo = -1
+	for v, n := range hist {
+		acc += n
+		if lo < 0 && acc >= lowCount {
+			lo = v
+		}
+		if acc >= highCount {
+			hi = v
+			break
+		}
+	}
+	if hi <= lo {
+		hi = lo + 1
+		if hi >= len(hist) {
+			lo, hi = len(hist)-2, len(hist)-1
+		}
+	}
+	return lo, hi
+}
+
+// decodeDisplayImage 解码、映射到 8 位并按 EXIF 方向转正，用于缩略图、瓦片和感知哈希
+func decodeDisplayImage(path, window string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	img, _, err := decodeImageFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return applyEXIFOrientation(toDisplayImage(img, window), exifOrientation(head[:n])), nil
+}
+
+// errNotFloatTIFF TIFF 不是浮点采样，交给 x/image/tiff 解码
+var errNotFloatTIFF = errors.New("not a floating-point tiff")
+
+// floatTIFF 浮点采样（SampleFormat=3）的单页条带式 TIFF
+type floatTIFF struct {
+	order        binary.ByteOrder
+	width        int
+	height       int
+	bits         int // 32 或 64
+	samples      int // 每像素采样数；1 为灰度，3 及以上取前三个为 RGB
+	compression  uint32
+	rowsPerStrip int
+	stripOffsets []uint32
+	stripCounts  []uint32
+}
+
+// TIFF 标签
+const (
+	tiffTagImageWidth      = 256
+	tiffTagImageLength     = 257
+	tiffTagBitsPerSample   = 258
+	tiffTagCompression     = 259
+	tiffTagPhotometric     = 262
+	tiffTagStripOffsets    = 273
+	tiffTagSamplesPerPixel = 277
+	tiffTagRowsPerStrip    = 278
+	tiffTagStripByteCounts = 279
+	tiffTagPlanarConfig    = 284
+	tiffTagPredictor       = 317
+	tiffTagTileWidth       = 322
+	tiffTagTileLength      = 323
+	tiffTagTileOffsets     = 324
+	tiffTagTileByteCounts  = 325
+	tiffTagSampleFormat    = 339
+)
+
+// readTIFFTags 解析第一个 IFD 中 SHORT/LONG 类型的标签
+func readTIFFTags(r io.ReaderAt) (binary.ByteOrder, map[uint16][]uint32, error) {
+	hdr := make([]byte, 8)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, nil, err
+	}
+	var order binary.ByteOrder
+	switch string(hdr[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("tiff: invalid header")
+	}
+	ifd := int64(order.Uint32(hdr[4:]))
+	cnt := make([]byte, 2)
+	if _, err := r.ReadAt(cnt, ifd); err != nil {
+		return nil, nil, err
+	}
+	entries := make([]byte, 12*int(order.Uint16(cnt)))
+	if _, err := r.ReadAt(entries, ifd+2); err != nil {
+		return nil, nil, err
+	}
+
+	tags := make(map[uint16][]uint32)
+	for i := 0; i+12 <= len(entries); i += 12 {
+		e := entries[i : i+12]
+		tag, typ, count := order.Uint16(e), order.Uint16(e[2:]), order.Uint32(e[4:])
+		size := 0
+		switch typ {
+		case 3: // SHORT
+			size = 2
+		case 4: // LONG
+			size = 4
+		default:
+			continue
+		}
+		if count > 1<<24 {
+			return nil, nil, fmt.Errorf("tiff tag %d too large", tag)
+		}
+		data := e[8:12]
+		if int(count)*size > 4 {
+			data = make([]byte, int(count)*size)
+			if _, err := r.ReadAt(data, int64(order.Uint32(e[8:]))); err != nil {
+				return nil, nil, err
+			}
+		}
+		vals := make([]uint32, count)
+		for j := range vals {
+			if size == 2 {
+				vals[j] = uint32(order.Uint16(data[j*2:]))
+			} else {
+				vals[j] = order.Uint32(data[j*4:])
+			}
+		}
+		tags[tag] = vals
+	}
+	return order, tags, nil
+}
+
+// readFloatTIFF 解析第一个 IFD；不是浮点采样时返回 errNotFloatTIFF
+func readFloatTIFF(r io.ReaderAt) (*floatTIFF, error) {
+	order, tags, err := readTIFFTags(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &floatTIFF{order: order}
+
+	first := func(tag uint16, def uint32) uint32 {
+		if v := tags[tag]; len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+	if first(tiffTagSampleFormat, 1) != 3 {
+		return nil, errNotFloatTIFF
+	}
+	t.width = int(first(tiffTagImageWidth, 0))
+	t.height = int(first(tiffTagImageLength, 0))
+	t.bits = int(first(tiffTagBitsPerSample, 32))
+	t.samples = int(first(tiffTagSamplesPerPixel, 1))
+	t.compression = first(tiffTagCompression, 1)
+	t.rowsPerStrip = int(first(tiffTagRowsPerStrip, uint32(t.height)))
+	t.stripOffsets = tags[tiffTagStripOffsets]
+	t.stripCounts = tags[tiffTagStripByteCounts]
+
+	switch {
+	case t.width <= 0 || t.height <= 0:
+		return nil, errors.New("tiff: invalid dimensions")
+	case t.bits != 32 && t.bits != 64:
+		return nil, fmt.Errorf("tiff: unsupported float BitsPerSample %d", t.bits)
+	case t.samples != 1 && t.samples < 3:
+		return nil, fmt.Errorf("tiff: unsupported SamplesPerPixel %d", t.samples)
+	case first(tiffTagPlanarConfig, 1) != 1:
+		return nil, errors.New("tiff: planar float data not supported")
+	case first(tiffTagPredictor, 1) != 1:
+		return nil, errors.New("tiff: float predictor not supported")
+	case len(tags[tiffTagTileWidth]) > 0:
+		return nil, errors.New("tiff: tiled float data not supported")
+	case len(t.stripOffsets) == 0 || len(t.stripOffsets) != len(t.stripCounts):
+		return nil, errors.New("tiff: missing strip offsets")
+	case t.rowsPerStrip <= 0:
+		t.rowsPerStrip = t.height
+	}
+	switch t.compression {
+	case 1, 5, 8, 32946:
+	default:
+		return nil, fmt.Errorf("tiff: unsupported compression %d", t.compression)
+	}
+	return t, nil
+}
+
+// config 映射后按 16 位图片对待
+func (t *floatTIFF) config() image.Config {
+	m := color.Gray16Model
+	if t.samples >= 3 {
+		m = color.RGBA64Model
+	}
+	return image.Config{ColorModel: m, Width: t.width, Height: t.height}
+}
+
+// decode 读取全部条带，按有限值的最小/最大值线性归一化到 16 位
+// 原始浮点数据不保留，之后再由 toDisplayImage 按窗口映射
+func (t *floatTIFF) decode(r io.ReaderAt) (image.Image, error) {
+	bytesPerSample := t.bits / 8
+	rowBytes := t.width * t.samples * bytesPerSample
+	if int64(rowBytes)*int64(t.height) > 1<<31 {
+		return nil, errors.New("tiff: image too large")
+	}
+	raw := make([]byte, 0, rowBytes*t.height)
+	for i, off := range t.stripOffsets {
+		rows := min(t.rowsPerStrip, t.height-i*t.rowsPerStrip)
+		if rows <= 0 {
+			break
+		}
+		strip, err := t.readStrip(io.NewSectionReader(r, int64(off), int64(t.stripCounts[i])), rows*rowBytes)
+		if err != nil {
+			return nil, fmt.Errorf("tiff: strip %d: %w", i, err)
+		}
+		raw = append(raw, strip...)
+	}
+	if len(raw) < rowBytes*t.height {
+		return nil, errors.New("tiff: not enough strip data")
+	}
+
+	channels := 1
+	if t.samples >= 3 {
+		channels = 3
+	}
+	values := make([]float64, 0, t.width*t.height*channels)
+	for p := 0; p < t.width*t.height; p++ {
+		for c := 0; c < channels; c++ {
+			off := (p*t.samples + c) * bytesPerSample
+			var v float64
+			if t.bits == 32 {
+				v = float64(math.Float32frombits(t.order.Uint32(raw[off:])))
+			} else {
+				v = math.Float64frombits(t.order.Uint64(raw[off:]))
+			}
+			values = append(values, v)
+		}
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if lo > hi {
+		lo, hi = 0, 1
+	}
+	scale := 0.0
+	if hi > lo {
+		scale = 65535 / (hi - lo)
+	}
+	norm := func(v float64) uint16 {
+		switch {
+		case math.IsNaN(v) || v <= lo:
+			return 0
+		case v >= hi:
+			return 0xffff
+		}
+		return uint16((v - lo) * scale)
+	}
+
+	rect := image.Rect(0, 0, t.width, t.height)
+	if channels == 1 {
+		img := image.NewGray16(rect)
+		for p, v := range values {
+			img.SetGray16(p%t.width, p/t.width, color.Gray16{Y: norm(v)})
+		}
+		return img, nil
+	}
+	img := image.NewRGBA64(rect)
+	for p := 0; p < t.width*t.height; p++ {
+		img.SetRGBA64(p%t.width, p/t.width, color.RGBA64{
+			R: norm(values[p*3]), G: norm(values[p*3+1]), B: norm(values[p*3+2]), A: 0xffff,
+		})
+	}
+	return img, nil
+}
+
+// readStrip 读取并解压一个条带
+func (t *floatTIFF) readStrip(sr io.Reader, size int) ([]byte, error) {
+	src, err := tiffChunkReader(sr, t.compression)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	strip := make([]byte, size)
+	if _, err := io.ReadFull(src, strip); err != nil {
+		return nil, err
+	}
+	return strip, nil
+}
+
+// tiffChunkReader 按压缩方式解压一个条带或分块：无压缩、LZW、Deflate 或 PackBits
+func tiffChunkReader(sr io.Reader, compression uint32) (io.ReadCloser, error) {
+	switch compression {
+	case 1:
+		return io.NopCloser(sr), nil
+	case 5:
+		return lzw.NewReader(sr, lzw.MSB, 8), nil
+	case 8, 32946:
+		return zlib.NewReader(sr)
+	case 32773:
+		return io.NopCloser(&packBitsReader{r: bufio.NewReader(sr)}), nil
+	}
+	return nil, fmt.Errorf("tiff: unsupported compression %d", compression)
+}
+
+// packBitsReader 解码 PackBits：头字节 0..127 之后原样复制 n+1 字节，129..255 把下一字节重复 257-n 次，128 跳过
+type packBitsReader struct {
+	r       *bufio.Reader
+	literal int  // 剩余原样复制的字节数
+	repeat  int  // 剩余重复次数
+	value   byte // 重复的字节
+}
+
+func (p *packBitsReader) Read(buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		switch {
+		case p.literal > 0:
+			c, err := p.r.ReadByte()
+			if err != nil {
+				return n, err
+			}
+			buf[n] = c
+			n++
+			p.literal--
+		case p.repeat > 0:
+			buf[n] = p.value
+			n++
+			p.repeat--
+		default:
+			h, err := p.r.ReadByte()
+			if err != nil {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, err
+			}
+			switch {
+			case h < 128:
+				p.literal = int(h) + 1
+			case h > 128:
+				if p.value, err = p.r.ReadByte(); err != nil {
+					return n, err
+				}
+				p.repeat = 257 - int(h)
+			}
+		}
+	}
+	return n, nil
+}
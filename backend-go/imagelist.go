@@ -0,0 +1,515 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 图片列表分页
+const (
+	defaultImageListLimit = 200
+	maxImageListLimit     = 1000
+)
+
+// imageListMetaPrefix 按清单元数据筛选的参数前缀，如 meta.site=A
+const imageListMetaPrefix = "meta."
+
+// imageListSortKeys 可排序字段 -> 排序表达式（在 filtered 结果上）
+var imageListSortKeys = map[string]string{
+	"id":          "id",
+	"filename":    "lower(filename)",
+	"createdAt":   "created_at",
+	"annotations": "ann_count",
+	"fileSize":    "file_size",
+}
+
+// imageListQuery 图片列表的筛选、排序与分页参数
+type imageListQuery struct {
+	where []string // image_index i 上的条件
+	args  []interface{}
+
+	annWhere []string // 标注 a 上的属性条件，已合并为 where 中的一个 EXISTS
+	annArgs  []interface{}
+
+	sort   string
+	desc   bool
+	limit  int
+	cursor *imageListCursor
+	paged  bool // 带任一筛选/分页参数时为 true；否则沿用一次返回全部的旧行为
+}
+
+// imageListCursor 游标：上一页最后一条的排序值和 id
+type imageListCursor struct {
+	Value interface{} `json:"v"`
+	ID    int64       `json:"id"`
+}
+
+// parseImageListQuery 解析查询参数，失败时返回错误码
+//
+//	status=annotated,none,negative
+//	hasCategory=1,2 / noCategory=3      含有 / 不含指定类别的标注
+//	minAnnotations=1&maxAnnotations=10  标注数量范围
+//	filename=cat 或 filename=*.png      子串或通配符（* ?），不区分大小写
+//	importedFrom=2024-01-01&importedTo=2024-02-01  导入日期（含 To 当天）
+//	meta.site=A,B                       清单元数据等于任一值；meta.site=* 表示有该字段
+//	capturedFrom=...&capturedTo=...     清单中的拍摄时间，格式同导入日期
+//	tags=night,rainy / anyTags=... / excludeTags=...  含全部 / 任一 / 不含指定标签（不区分大小写）
+//	attr.color=red,white&attr.occluded=false  有一个标注同时满足全部属性条件，值的含义见 parseAttributeFilter
+//	sort=id|filename|createdAt|annotations|fileSize&order=asc|desc
+//	limit=200&cursor=...
+func parseImageListQuery(v url.Values) (*imageListQuery, string) {
+	q := &imageListQuery{
+		where: []string{"i.deleted_in_project = 0"},
+		sort:  "id",
+	}
+	for _, key := range []string{"status", "hasCategory", "noCategory", "minAnnotations", "maxAnnotations", "filename", "importedFrom", "importedTo", "capturedFrom", "capturedTo", "tags", "anyTags", "excludeTags", "sort", "order", "limit", "cursor"} {
+		if strings.TrimSpace(v.Get(key)) != "" {
+			q.paged = true
+			break
+		}
+	}
+
+	metaKeys := make([]string, 0)
+	for key := range v {
+		if strings.HasPrefix(key, imageListMetaPrefix) && strings.TrimSpace(v.Get(key)) != "" {
+			metaKeys = append(metaKeys, key)
+		}
+	}
+	sort.Strings(metaKeys)
+	for _, key := range metaKeys {
+		q.paged = true
+		name := strings.TrimPrefix(key, imageListMetaPrefix)
+		if name == "" {
+			return nil, "meta_invalid"
+		}
+		s := strings.TrimSpace(v.Get(key))
+		if s == "*" {
+			q.where = append(q.where, "EXISTS (SELECT 1 FROM image_metadata m WHERE m.image_id = i.id AND m.key = ?)")
+			q.args = append(q.args, name)
+			continue
+		}
+		var values []string
+		for _, val := range strings.Split(s, ",") {
+			if val = strings.TrimSpace(val); val != "" {
+				values = append(values, val)
+			}
+		}
+		if len(values) == 0 {
+			return nil, "meta_invalid"
+		}
+		q.where = append(q.where, fmt.Sprintf("EXISTS (SELECT 1 FROM image_metadata m WHERE m.image_id = i.id AND m.key = ? AND m.value IN (%s))", placeholders(len(values))))
+		q.args = append(q.args, name)
+		for _, val := range values {
+			q.args = append(q.args, val)
+		}
+	}
+
+	attrKeys := make([]string, 0)
+	for key := range v {
+		if strings.HasPrefix(key, attributeFilterPrefix) && strings.TrimSpace(v.Get(key)) != "" {
+			attrKeys = append(attrKeys, key)
+		}
+	}
+	sort.Strings(attrKeys)
+	for _, key := range attrKeys {
+		q.paged = true
+		cond, args, code := parseAttributeFilter(key, strings.TrimSpace(v.Get(key)))
+		if code != "" {
+			return nil, code
+		}
+		q.annWhere = append(q.annWhere, cond)
+		q.annArgs = append(q.annArgs, args...)
+	}
+	if len(q.annWhere) > 0 {
+		q.where = append(q.where, "EXISTS (SELECT 1 FROM annotations a WHERE a.image_id = i.id AND "+strings.Join(q.annWhere, " AND ")+")")
+		q.args = append(q.args, q.annArgs...)
+	}
+
+	if s := strings.TrimSpace(v.Get("status")); s != "" {
+		var statuses []string
+		for _, st := range strings.Split(s, ",") {
+			st = strings.TrimSpace(st)
+			switch st {
+			case "annotated", "none", "negative":
+				statuses = append(statuses, st)
+			case "":
+			default:
+				return nil, "status_invalid"
+			}
+		}
+		if len(statuses) > 0 {
+			q.where = append(q.where, fmt.Sprintf("COALESCE(i.annotation_status, 'none') IN (%s)", placeholders(len(statuses))))
+			for _, st := range statuses {
+				q.args = append(q.args, st)
+			}
+		}
+	}
+
+	for param, negate := range map[string]bool{"hasCategory": false, "noCategory": true} {
+		s := strings.TrimSpace(v.Get(param))
+		if s == "" {
+			continue
+		}
+		ids, ok := parseIDList(s)
+		if !ok {
+			return nil, "category_invalid"
+		}
+		cond := fmt.Sprintf("EXISTS (SELECT 1 FROM annotations a WHERE a.image_id = i.id AND a.category_id IN (%s))", placeholders(len(ids)))
+		if negate {
+			cond = "NOT " + cond
+		}
+		q.where = append(q.where, cond)
+		for _, id := range ids {
+			q.args = append(q.args, id)
+		}
+	}
+
+	for param, op := range map[string]string{"minAnnotations": ">=", "maxAnnotations": "<="} {
+		s := strings.TrimSpace(v.Get(param))
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, "annotation_range_invalid"
+		}
+		q.where = append(q.where, "(SELECT COUNT(*) FROM annotations a WHERE a.image_id = i.id) "+op+" ?")
+		q.args = append(q.args, n)
+	}
+
+	if s := strings.TrimSpace(v.Get("filename")); s != "" {
+		pattern := likeEscape(s)
+		if strings.ContainsAny(s, "*?") {
+			pattern = strings.NewReplacer("*", "%", "?", "_").Replace(pattern)
+		} else {
+			pattern = "%" + pattern + "%"
+		}
+		q.where = append(q.where, `i.filename LIKE ? ESCAPE '\'`)
+		q.args = append(q.args, pattern)
+	}
+
+	if s := strings.TrimSpace(v.Get("importedFrom")); s != "" {
+		t, _, ok := parseListDate(s)
+		if !ok {
+			return nil, "date_invalid"
+		}
+		q.where = append(q.where, "i.created_at >= ?")
+		q.args = append(q.args, t.UTC().Format(time.RFC3339))
+	}
+	if s := strings.TrimSpace(v.Get("importedTo")); s != "" {
+		t, dateOnly, ok := parseListDate(s)
+		if !ok {
+			return nil, "date_invalid"
+		}
+		if dateOnly {
+			// 只给日期时包含当天
+			q.where = append(q.where, "i.created_at < ?")
+			q.args = append(q.args, t.AddDate(0, 0, 1).UTC().Format(time.RFC3339))
+		} else {
+			q.where = append(q.where, "i.created_at <= ?")
+			q.args = append(q.args, t.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if s := strings.TrimSpace(v.Get("tags")); s != "" {
+		tags, ok := parseTagList(s)
+		if !ok {
+			return nil, "tag_invalid"
+		}
+		// 每个标签一个条件，图片须含全部标签
+		for _, tag := range tags {
+			q.where = append(q.where, "EXISTS (SELECT 1 FROM image_tags t WHERE t.image_id = i.id AND t.tag = ?)")
+			q.args = append(q.args, tag)
+		}
+	}
+	for param, negate := range map[string]bool{"anyTags": false, "excludeTags": true} {
+		s := strings.TrimSpace(v.Get(param))
+		if s == "" {
+			continue
+		}
+		tags, ok := parseTagList(s)
+		if !ok {
+			return nil, "tag_invalid"
+		}
+		cond := fmt.Sprintf("EXISTS (SELECT 1 FROM image_tags t WHERE t.image_id = i.id AND t.tag IN (%s))", placeholders(len(tags)))
+		if negate {
+			cond = "NOT " + cond
+		}
+		q.where = append(q.where, cond)
+		for _, tag := range tags {
+			q.args = append(q.args, tag)
+		}
+	}
+
+	// 拍摄时间在清单导入时已统一为 RFC3339（UTC），可以按字符串比较
+	if s := strings.TrimSpace(v.Get("capturedFrom")); s != "" {
+		t, _, ok := parseListDate(s)
+		if !ok {
+			return nil, "date_invalid"
+		}
+		q.where = append(q.where, "EXISTS (SELECT 1 FROM image_metadata m WHERE m.image_id = i.id AND m.key = ? AND m.value >= ?)")
+		q.args = append(q.args, manifestKeyCaptureTime, t.UTC().Format(time.RFC3339))
+	}
+	if s := strings.TrimSpace(v.Get("capturedTo")); s != "" {
+		t, dateOnly, ok := parseListDate(s)
+		if !ok {
+			return nil, "date_invalid"
+		}
+		op := "<="
+		if dateOnly {
+			t, op = t.AddDate(0, 0, 1), "<"
+		}
+		q.where = append(q.where, "EXISTS (SELECT 1 FROM image_metadata m WHERE m.image_id = i.id AND m.key = ? AND m.value "+op+" ?)")
+		q.args = append(q.args, manifestKeyCaptureTime, t.UTC().Format(time.RFC3339))
+	}
+
+	if s := strings.TrimSpace(v.Get("sort")); s != "" {
+		if _, ok := imageListSortKeys[s]; !ok {
+			return nil, "sort_invalid"
+		}
+		q.sort = s
+	}
+	switch strings.ToLower(strings.TrimSpace(v.Get("order"))) {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, "order_invalid"
+	}
+
+	q.limit = defaultImageListLimit
+	if s := strings.TrimSpace(v.Get("limit")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, "limit_invalid"
+		}
+		if n > maxImageListLimit {
+			n = maxImageListLimit
+		}
+		q.limit = n
+	}
+
+	if s := strings.TrimSpace(v.Get("cursor")); s != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, "cursor_invalid"
+		}
+		var c imageListCursor
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, "cursor_invalid"
+		}
+		q.cursor = &c
+	}
+	return q, ""
+}
+
+// writeFilteredProjectImages 按筛选条件返回一页图片，统计数针对整个筛选结果
+func writeFilteredProjectImages(w http.ResponseWriter, db *projectDBHandle, q *imageListQuery) {
+	filtered := fmt.Sprintf(`WITH f AS (
+	SELECT i.id, i.filename, i.original_rel_path, i.thumb_rel_path, COALESCE(i.annotation_status, 'none') AS status,
+		i.width, i.height, i.file_size, i.format, i.sha256, i.created_at,
+		(SELECT COUNT(*) FROM annotations a WHERE a.image_id = i.id) AS ann_count
+	FROM image_index i WHERE %s
+)`, strings.Join(q.where, " AND "))
+
+	resp := projectImageListResponse{Items: make([]projectImageListItem, 0, q.limit)}
+	err := db.QueryRow(filtered+`
+SELECT COUNT(*),
+	COALESCE(SUM(status = 'annotated'), 0),
+	COALESCE(SUM(status = 'negative'), 0)
+FROM f;`, q.args...).Scan(&resp.Total, &resp.AnnotatedCount, &resp.NegativeCount)
+	if err != nil {
+		log.Printf("project images: count query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	resp.UnannotatedCount = resp.Total - resp.AnnotatedCount - resp.NegativeCount
+
+	sortExpr := imageListSortKeys[q.sort]
+	dir, cmp := "ASC", ">"
+	if q.desc {
+		dir, cmp = "DESC", "<"
+	}
+	args := append([]interface{}(nil), q.args...)
+	cursorCond := ""
+	if q.cursor != nil {
+		cursorCond = fmt.Sprintf("WHERE (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sortExpr, cmp)
+		args = append(args, q.cursor.Value, q.cursor.Value, q.cursor.ID)
+	}
+	args = append(args, q.limit+1)
+
+	rows, err := db.Query(filtered+fmt.Sprintf(`
+SELECT id, filename, original_rel_path, thumb_rel_path, status, width, height, file_size, format, sha256, %[1]s
+FROM f %[2]s ORDER BY %[1]s %[3]s, id %[3]s LIMIT ?;`, sortExpr, cursorCond, dir), args...)
+	if err != nil {
+		log.Printf("project images: page query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	defer rows.Close()
+
+	var lastKey interface{}
+	for rows.Next() {
+		var id int64
+		var filename, originalRel, thumbRel, status string
+		var meta imageFileMeta
+		var key interface{}
+		if err := rows.Scan(&id, &filename, &originalRel, &thumbRel, &status, &meta.Width, &meta.Height, &meta.FileSize, &meta.Format, &meta.SHA256, &key); err != nil {
+			log.Printf("project images: scan failed: %v", err)
+			continue
+		}
+		if len(resp.Items) == q.limit {
+			// 多取的一条只用来判断是否还有下一页
+			last := resp.Items[len(resp.Items)-1]
+			if b, err := json.Marshal(imageListCursor{Value: lastKey, ID: last.ID}); err == nil {
+				resp.NextCursor = base64.RawURLEncoding.EncodeToString(b)
+			}
+			break
+		}
+		resp.Items = append(resp.Items, newProjectImageListItem(id, filename, originalRel, thumbRel, status, meta))
+		lastKey = key
+	}
+	rows.Close()
+	attachImageMetadata(db, resp.Items)
+	attachImageTags(db, resp.Items)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// attachImageMetadata 为当前页的图片附上清单元数据；失败只记录日志
+func attachImageMetadata(db *projectDBHandle, items []projectImageListItem) {
+	if len(items) == 0 {
+		return
+	}
+	index := make(map[int64]int, len(items))
+	args := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		index[item.ID] = i
+		args = append(args, item.ID)
+	}
+	rows, err := db.Query(fmt.Sprintf(`SELECT image_id, key, value FROM image_metadata WHERE image_id IN (%s);`, placeholders(len(args))), args...)
+	if err != nil {
+		log.Printf("project images: metadata query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var imageID int64
+		var key, value string
+		if err := rows.Scan(&imageID, &key, &value); err != nil {
+			continue
+		}
+		item := &items[index[imageID]]
+		if item.Metadata == nil {
+			item.Metadata = make(map[string]string)
+		}
+		item.Metadata[key] = value
+	}
+}
+
+// parseImageFilter 解析导出、训练集等处使用的图片筛选条件，语法与图片列表的查询参数相同
+// 例如 "tags=night&excludeTags=blurry&meta.site=A&status=annotated"；排序和分页参数被忽略
+// 返回作用于 image_index i 的条件（不含删除状态），空字符串表示不筛选
+func parseImageFilter(filter string) (string, []interface{}, string) {
+	q, code := parseImageFilterQuery(filter)
+	if code != "" || q == nil {
+		return "", nil, code
+	}
+	// 第一个条件是 deleted_in_project，由调用方自行决定
+	if len(q.where) <= 1 {
+		return "", nil, ""
+	}
+	return strings.Join(q.where[1:], " AND "), q.args, ""
+}
+
+// parseImageFilterQuery 解析筛选条件；filter 为空时返回 nil
+func parseImageFilterQuery(filter string) (*imageListQuery, string) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, ""
+	}
+	v, err := url.ParseQuery(filter)
+	if err != nil {
+		return nil, "filter_invalid"
+	}
+	return parseImageListQuery(v)
+}
+
+// queryCategoryAnnotations 查询类别的全部标注及所在图片，供导出和训练集使用；filter 语法同 parseImageFilter
+// 属性条件同时作用于标注本身，只导出满足条件的标注
+func queryCategoryAnnotations(db *projectDBHandle, categoryID int, filter string) (*sql.Rows, error) {
+	q, code := parseImageFilterQuery(filter)
+	if code != "" {
+		return nil, fmt.Errorf("invalid image filter: %s", code)
+	}
+	query := `SELECT a.id, a.image_id, a.type, a.data, i.original_rel_path, i.width, i.height, i.orientation
+FROM annotations a
+JOIN image_index i ON a.image_id = i.id
+WHERE a.category_id = ?`
+	args := []interface{}{categoryID}
+	if q != nil && len(q.where) > 1 {
+		query += ` AND ` + strings.Join(q.where[1:], " AND ")
+		args = append(args, q.args...)
+	}
+	if q != nil && len(q.annWhere) > 0 {
+		query += ` AND ` + strings.Join(q.annWhere, " AND ")
+		args = append(args, q.annArgs...)
+	}
+	return db.Query(query, args...)
+}
+
+// placeholders 生成 n 个逗号分隔的 ?
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// parseIDList 解析逗号分隔的正整数 ID
+func parseIDList(s string) ([]int64, bool) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, len(ids) > 0
+}
+
+// likeEscape 转义 LIKE 中的特殊字符（配合 ESCAPE '\'）
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// parseListDate 支持 RFC3339 或 YYYY-MM-DD（按 UTC）
+func parseListDate(s string) (time.Time, bool, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, true
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, true
+	}
+	return time.Time{}, false, false
+}
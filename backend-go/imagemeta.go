@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// imageFileMeta 导入时记录的图片文件信息
+type imageFileMeta struct {
+	Width       int // 按 EXIF 方向转正后的显示尺寸
+	Height      int
+	FileSize    int64
+	Format      string
+	SHA256      string
+	PHash       string // 64 位 dHash 的十六进制，需要完整解码，probeImageFile 不计算
+	Orientation int    // EXIF 方向，1-8
+	BitDepth    int    // 每通道位数：8、16，浮点 TIFF 为 32/64
+}
+
+// probeImageFile 一次读取同时得到尺寸、格式、位深、EXIF 方向、大小和 SHA-256
+// 无法识别的格式仍然返回大小和摘要，尺寸为 0
+func probeImageFile(path string) (imageFileMeta, error) {
+	var meta imageFileMeta
+	f, err := os.Open(path)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+
+	// 文件头和图片信息用 ReadAt 读取，不影响下面从头计算摘要
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	meta.Orientation = exifOrientation(head[:n])
+	if cfg, format, bitDepth, err := imageFileConfig(f); err == nil {
+		meta.Width = cfg.Width
+		meta.Height = cfg.Height
+		meta.Format = format
+		meta.BitDepth = bitDepth
+		if orientationSwapsAxes(meta.Orientation) {
+			meta.Width, meta.Height = cfg.Height, cfg.Width
+		}
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return meta, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return meta, err
+	}
+	meta.FileSize = info.Size()
+	meta.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return meta, nil
+}
+
+// resolveProjectImagePath 将 image_index 中的路径转换为磁盘路径（外部图片存的是绝对路径）
+func resolveProjectImagePath(projectRoot, rel string) string {
+	if filepath.IsAbs(rel) {
+		return rel
+	}
+	return filepath.Join(projectRoot, filepath.FromSlash(rel))
+}
+
+// readImageDimensions 旧数据缺少尺寸时的兜底读取（按 EXIF 方向转正后的尺寸）
+func readImageDimensions(path string) (int, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	cfg, _, _, err := imageFileConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	if orientationSwapsAxes(exifOrientation(head[:n])) {
+		return cfg.Height, cfg.Width
+	}
+	return cfg.Width, cfg.Height
+}
+
+// imageMetaBackfillRunning 正在补全的项目，避免重复启动
+var (
+	imageMetaBackfillMu      sync.Mutex
+	imageMetaBackfillRunning = make(map[string]bool)
+)
+
+// startImageMetaBackfill 后台为所有已有项目补全图片信息
+func startImageMetaBackfill() {
+	cfg, err := loadPathsConfig()
+	if err != nil || cfg.DataPath == "" {
+		return
+	}
+	projects, err := loadProjects(cfg.DataPath)
+	if err != nil {
+		log.Printf("[ImageMeta] load projects failed: %v", err)
+		return
+	}
+	go func() {
+		for _, p := range projects {
+			backfillProjectImageMeta(cfg.DataPath, p.ID)
+		}
+	}()
+}
+
+// backfillProjectImageMeta 补全项目数据库及其版本快照中缺失的图片信息
+// 快照与当前库共用图片文件，已算过的路径直接复用，不再重复读取
+func backfillProjectImageMeta(dataPath, projectID string) {
+	imageMetaBackfillMu.Lock()
+	if imageMetaBackfillRunning[projectID] {
+		imageMetaBackfillMu.Unlock()
+		return
+	}
+	imageMetaBackfillRunning[projectID] = true
+	imageMetaBackfillMu.Unlock()
+	defer func() {
+		imageMetaBackfillMu.Lock()
+		delete(imageMetaBackfillRunning, projectID)
+		imageMetaBackfillMu.Unlock()
+	}()
+	// 迁移期间跳过，下次启动时再补全
+	if !projectWriteGate.TryRLock() {
+		log.Printf("[ImageMeta] project %s: skipped during data relocation", projectID)
+		return
+	}
+	defer endProjectWrite()
+
+	projectRoot := filepath.Join(dataPath, "project_item", projectID)
+	dbPaths := []string{filepath.Join(projectRoot, "db", "project.db")}
+	if matches, err := filepath.Glob(filepath.Join(projectRoot, "db", "versions", "v*", "project.db")); err == nil {
+		dbPaths = append(dbPaths, matches...)
+	}
+
+	known := make(map[string]imageFileMeta)
+	regenerated := make(map[string]bool)
+	for _, dbPath := range dbPaths {
+		if _, err := os.Stat(dbPath); err != nil {
+			continue
+		}
+		n, err := backfillImageMetaDB(dbPath, projectRoot, known)
+		if err != nil {
+			log.Printf("[ImageMeta] %s: backfill failed: %v", dbPath, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("[ImageMeta] %s: backfilled %d images", dbPath, n)
+		}
+	}
+
+	// 旧数据没有记录 EXIF 方向和位深，补上并修正尺寸和缩略图
+	for _, dbPath := range dbPaths {
+		if _, err := os.Stat(dbPath); err != nil {
+			continue
+		}
+		n, err := fillMissingDisplayInfo(dbPath, projectRoot, regenerated)
+		if err != nil {
+			log.Printf("[ImageMeta] %s: display info backfill failed: %v", dbPath, err)
+		} else if n > 0 {
+			log.Printf("[ImageMeta] %s: recorded orientation and bit depth for %d images", dbPath, n)
+		}
+	}
+
+	// 感知哈希需要完整解码，只补当前库（查重只针对当前库）
+	if n, err := fillMissingPHashes(dbPaths[0], projectRoot, nil); err != nil {
+		log.Printf("[ImageMeta] %s: phash backfill failed: %v", dbPaths[0], err)
+	} else if n > 0 {
+		log.Printf("[ImageMeta] %s: computed phash for %d images", dbPaths[0], n)
+	}
+}
+
+// backfillImageMetaDB 补全单个数据库中 sha256 为空的记录
+func backfillImageMetaDB(dbPath, projectRoot string, known map[string]imageFileMeta) (int, error) {
+	db, err := openProjectDB(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	// 已有信息的记录也加入缓存，供后面的快照复用
+	rows, err := db.Query(`SELECT id, original_rel_path, width, height, file_size, format, sha256 FROM image_index;`)
+	if err != nil {
+		return 0, err
+	}
+	type pendingImage struct {
+		ID  int64
+		Rel string
+	}
+	var pending []pendingImage
+	for rows.Next() {
+		var id int64
+		var rel string
+		var m imageFileMeta
+		if err := rows.Scan(&id, &rel, &m.Width, &m.Height, &m.FileSize, &m.Format, &m.SHA256); err != nil {
+			continue
+		}
+		if m.SHA256 != "" {
+			if _, ok := known[rel]; !ok {
+				known[rel] = m
+			}
+			continue
+		}
+		pending = append(pending, pendingImage{ID: id, Rel: rel})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, p := range pending {
+		m, ok := known[p.Rel]
+		if !ok {
+			probed, err := probeImageFile(resolveProjectImagePath(projectRoot, p.Rel))
+			if err != nil {
+				continue
+			}
+			m = probed
+			known[p.Rel] = m
+		}
+		if err := updateImageFileMeta(db, p.ID, m); err != nil {
+			log.Printf("[ImageMeta] %s: update image %d failed: %v", dbPath, p.ID, err)
+			continue
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// updateImageFileMeta 写入单张图片的文件信息
+func updateImageFileMeta(db *sql.DB, imageID int64, m imageFileMeta) error {
+	_, err := db.Exec(`UPDATE image_index SET width = ?, height = ?, file_size = ?, format = ?, sha256 = ?, orientation = ?, bit_depth = ? WHERE id = ?;`,
+		m.Width, m.Height, m.FileSize, m.Format, m.SHA256, m.Orientation, m.BitDepth, imageID)
+	return err
+}
+
+// fillMissingDisplayInfo 为旧记录补上 EXIF 方向（orientation = 0）和位深（bit_depth = 0）
+// 需要转置的图片同时互换已记录的宽高；导入时按原始像素生成的缩略图重新生成（同一文件只处理一次）
+func fillMissingDisplayInfo(dbPath, projectRoot string, regenerated map[string]bool) (int, error) {
+	db, err := openProjectDB(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT id, original_rel_path, thumb_rel_path, orientation, bit_depth FROM image_index WHERE orientation = ? OR bit_depth = 0;`, exifOrientationUnknown)
+	if err != nil {
+		return 0, err
+	}
+	type pendingImage struct {
+		ID          int64
+		Rel         string
+		ThumbRel    string
+		Orientation int
+		BitDepth    int
+	}
+	var pending []pendingImage
+	for rows.Next() {
+		var p pendingImage
+		if err := rows.Scan(&p.ID, &p.Rel, &p.ThumbRel, &p.Orientation, &p.BitDepth); err == nil {
+			pending = append(pending, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	thumbsDir := filepath.Join(projectRoot, "images", "thumbs")
+	updated := 0
+	for _, p := range pending {
+		srcPath := resolveProjectImagePath(projectRoot, p.Rel)
+		f, err := os.Open(srcPath)
+		if err != nil {
+			continue
+		}
+		regenerate := false
+		swap := false
+		if p.Orientation == exifOrientationUnknown {
+			head := make([]byte, exifScanLimit)
+			n, _ := f.ReadAt(head, 0)
+			p.Orientation = exifOrientation(head[:n])
+			swap = orientationSwapsAxes(p.Orientation)
+			regenerate = p.Orientation != exifOrientationNormal
+		}
+		if p.BitDepth == 0 {
+			// 读不出来的文件按 8 位记录，避免每次启动重复尝试
+			p.BitDepth = 8
+			if _, _, bitDepth, err := imageFileConfig(f); err == nil {
+				p.BitDepth = bitDepth
+			}
+			regenerate = regenerate || p.BitDepth > 8
+		}
+		f.Close()
+
+		query := `UPDATE image_index SET orientation = ?, bit_depth = ? WHERE id = ?;`
+		if swap {
+			query = `UPDATE image_index SET orientation = ?, bit_depth = ?, width = height, height = width WHERE id = ?;`
+		}
+		if _, err := db.Exec(query, p.Orientation, p.BitDepth, p.ID); err != nil {
+			log.Printf("[ImageMeta] %s: update display info for image %d failed: %v", dbPath, p.ID, err)
+			continue
+		}
+		updated++
+
+		if !regenerate || p.ThumbRel == "" || p.ThumbRel == p.Rel {
+			continue
+		}
+		thumbPath := resolveProjectImagePath(projectRoot, p.ThumbRel)
+		if regenerated[thumbPath] || !isPathWithin(thumbsDir, thumbPath) {
+			continue
+		}
+		regenerated[thumbPath] = true
+		if err := writeThumbFile(thumbPath, srcPath, thumbMaxDimension, displayWindowPercentile); err != nil {
+			log.Printf("[ImageMeta] %s: regenerate thumb for image %d failed: %v", dbPath, p.ID, err)
+		}
+	}
+	return updated, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -31,6 +32,14 @@ type projectImageListItem struct {
 	ThumbPath        string `json:"thumbPath"`
 	OriginalPath     string `json:"originalPath"`
 	AnnotationStatus string `json:"annotationStatus"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	FileSize         int64  `json:"fileSize"`
+	Format           string `json:"format"`
+	SHA256           string `json:"sha256"`
+	// Metadata 和 Tags 只在按条件分页查询时返回
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
 }
 
 // projectImageListResponse 项目图片列表响应
@@ -40,10 +49,35 @@ type projectImageListResponse struct {
 	AnnotatedCount   int64                  `json:"annotatedCount"`
 	UnannotatedCount int64                  `json:"unannotatedCount"`
 	NegativeCount    int64                  `json:"negativeCount"`
+	NextCursor       string                 `json:"nextCursor,omitempty"` // 分页请求时还有下一页
+}
+
+// newProjectImageListItem 由 image_index 行构造列表项
+func newProjectImageListItem(id int64, filename, originalRel, thumbRel, annotationStatus string, meta imageFileMeta) projectImageListItem {
+	thumbPath := thumbRel
+	if thumbPath == "" {
+		thumbPath = originalRel
+	}
+	return projectImageListItem{
+		ID:               id,
+		Filename:         filename,
+		HasThumb:         thumbRel != "" && thumbRel != originalRel,
+		IsExternal:       !(strings.HasPrefix(originalRel, "images/") || strings.HasPrefix(originalRel, "./images/")),
+		ThumbPath:        thumbPath,
+		OriginalPath:     originalRel,
+		AnnotationStatus: annotationStatus,
+		Width:            meta.Width,
+		Height:           meta.Height,
+		FileSize:         meta.FileSize,
+		Format:           meta.Format,
+		SHA256:           meta.SHA256,
+	}
 }
 
 // runImportImagesTask 异步执行导入图片任务
-func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePaths []string) {
+// duplicatePolicy 决定内容重复的图片如何处理，文件名冲突但内容不同时总是自动重命名
+// manifest 不为 nil 时，在同一事务内写入清单中的元数据和分类标签
+func runImportImagesTask(dataPath, projectID, taskID, importMode, duplicatePolicy string, imagePaths []string, manifest *importManifest) {
 	defer releaseIOLock(taskID)
 	defer func() {
 		if r := recover(); r != nil {
@@ -61,6 +95,10 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 	if importMode != "copy" && importMode != "link" && importMode != "external" {
 		importMode = "copy"
 	}
+	duplicatePolicy = strings.TrimSpace(strings.ToLower(duplicatePolicy))
+	if duplicatePolicy != duplicatePolicyRename && duplicatePolicy != duplicatePolicyLink {
+		duplicatePolicy = duplicatePolicySkip
+	}
 
 	projectRoot := filepath.Join(dataPath, "project_item", projectID)
 	imagesDir := filepath.Join(projectRoot, "images")
@@ -87,6 +125,19 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		return
 	}
 
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	dedup, err := loadImportDedupIndex(dbPath, projectRoot, originalsDir)
+	if err != nil {
+		log.Printf("import task: load dedup index failed: %v", err)
+		importTasksMu.Lock()
+		if task, ok := importTasks[taskID]; ok {
+			task.Phase = importPhaseFailed
+			task.Error = "db_unavailable"
+		}
+		importTasksMu.Unlock()
+		return
+	}
+
 	type importJob struct {
 		SrcPath string
 		Name    string
@@ -94,23 +145,17 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 	}
 
 	type importedImage struct {
+		SrcPath     string
 		Filename    string
 		OriginalRel string
 		ThumbRel    string
-	}
-
-	existingNames := make(map[string]struct{})
-	entries, err := os.ReadDir(originalsDir)
-	if err == nil {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			name := entry.Name()
-			if name != "" {
-				existingNames[name] = struct{}{}
-			}
-		}
+		Meta        imageFileMeta
+		// 按 link 策略处理的重复图片：指向已有图片或本批内容相同的图片
+		LinkImageID int64
+		LinkHash    string
+		// 按 skip 策略跳过的重复图片，只用于把清单信息写到内容相同的图片上
+		DupImageID int64
+		DupHash    string
 	}
 
 	jobs := make([]importJob, 0, len(imagePaths))
@@ -124,10 +169,6 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		if baseName == "" {
 			continue
 		}
-		if _, exists := existingNames[baseName]; exists {
-			continue
-		}
-		existingNames[baseName] = struct{}{}
 		jobs = append(jobs, importJob{
 			SrcPath: srcPath,
 			Name:    baseName,
@@ -171,6 +212,29 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		go func() {
 			defer wg.Done()
 			for job := range jobsCh {
+				// 先读取源文件得到摘要，再决定是否导入以及使用的文件名
+				meta, err := probeImageFile(job.SrcPath)
+				if err != nil {
+					log.Printf("import task: read source failed for %s: %v", job.SrcPath, err)
+					resultsCh <- importedImage{}
+					continue
+				}
+				name, dup := dedup.claim(job.SrcPath, job.Name, meta.SHA256, duplicatePolicy)
+				if dup != nil {
+					reportImportDuplicate(taskID, *dup)
+				}
+				if name == "" {
+					switch {
+					case dup != nil && dup.Action == "linked":
+						resultsCh <- importedImage{SrcPath: job.SrcPath, LinkImageID: dup.ExistingImageID, LinkHash: meta.SHA256}
+					case dup != nil:
+						resultsCh <- importedImage{SrcPath: job.SrcPath, DupImageID: dup.ExistingImageID, DupHash: meta.SHA256}
+					default:
+						resultsCh <- importedImage{}
+					}
+					continue
+				}
+
 				var physicalPath string
 				var originalRel string
 				var thumbRel string
@@ -181,12 +245,13 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 					originalRel = filepath.ToSlash(job.SrcPath)
 					thumbRel = originalRel
 				case "link":
-					originalPath := filepath.Join(originalsDir, job.Name)
+					originalPath := filepath.Join(originalsDir, name)
 					if err := os.Link(job.SrcPath, originalPath); err != nil {
 						atomic.AddUint64(&hardLinkFallback, 1)
 						log.Printf("import task: hard link failed for %s -> %s, fallback to copy: %v", job.SrcPath, originalPath, err)
 						if err := copyFile(job.SrcPath, originalPath); err != nil {
 							log.Printf("import task: link/copy file failed: %v", err)
+							dedup.release(name, meta.SHA256)
 							resultsCh <- importedImage{}
 							continue
 						}
@@ -194,56 +259,59 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 						atomic.AddUint64(&hardLinkSuccess, 1)
 					}
 					physicalPath = originalPath
-					originalRel = filepath.ToSlash(filepath.Join("images", "originals", job.Name))
+					originalRel = filepath.ToSlash(filepath.Join("images", "originals", name))
 					thumbRel = originalRel
 				default:
-					originalPath := filepath.Join(originalsDir, job.Name)
+					originalPath := filepath.Join(originalsDir, name)
 					if err := copyFile(job.SrcPath, originalPath); err != nil {
 						log.Printf("import task: copy file failed: %v", err)
+						dedup.release(name, meta.SHA256)
 						resultsCh <- importedImage{}
 						continue
 					}
 					physicalPath = originalPath
-					originalRel = filepath.ToSlash(filepath.Join("images", "originals", job.Name))
+					originalRel = filepath.ToSlash(filepath.Join("images", "originals", name))
 					thumbRel = originalRel
 				}
 
-				if job.Size > thumbSizeThresholdBytes {
-					ext := strings.ToLower(filepath.Ext(job.Name))
-					base := strings.TrimSuffix(job.Name, ext)
+				// 解码一次（映射到 8 位并按 EXIF 方向转正），同时用于感知哈希和缩略图
+				decoded, _ := decodeDisplayImage(physicalPath, displayWindowPercentile)
+				if decoded != nil {
+					meta.PHash = formatPHash(dHashImage(decoded))
+				}
+
+				// 浏览器不能直接正确显示的图片总是生成缩略图，不直接用原图
+				if (job.Size > thumbSizeThresholdBytes || needsDisplayCopy(meta.Format, meta.BitDepth, meta.Orientation)) && decoded != nil {
+					ext := strings.ToLower(filepath.Ext(name))
+					base := strings.TrimSuffix(name, ext)
 					thumbFilename := base + ".jpg"
 					thumbPath := filepath.Join(thumbsDir, thumbFilename)
-					srcFile, err := os.Open(physicalPath)
-					if err == nil {
-						img, _, decErr := image.Decode(srcFile)
-						_ = srcFile.Close()
-						if decErr == nil {
-							b := img.Bounds()
-							w := b.Dx()
-							h := b.Dy()
-							newW, newH := w, h
-							if w >= h && w > thumbMaxDimension {
-								newW = thumbMaxDimension
-								newH = int(float64(h) * float64(newW) / float64(w))
-							} else if h > w && h > thumbMaxDimension {
-								newH = thumbMaxDimension
-								newW = int(float64(w) * float64(newH) / float64(h))
-							}
-							thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
-							draw.ApproxBiLinear.Scale(thumbImg, thumbImg.Bounds(), img, b, draw.Over, nil)
-							if out, err := os.Create(thumbPath); err == nil {
-								_ = jpeg.Encode(out, thumbImg, &jpeg.Options{Quality: 75})
-								_ = out.Close()
-								thumbRel = filepath.ToSlash(filepath.Join("images", "thumbs", thumbFilename))
-							}
-						}
+					b := decoded.Bounds()
+					w := b.Dx()
+					h := b.Dy()
+					newW, newH := w, h
+					if w >= h && w > thumbMaxDimension {
+						newW = thumbMaxDimension
+						newH = int(float64(h) * float64(newW) / float64(w))
+					} else if h > w && h > thumbMaxDimension {
+						newH = thumbMaxDimension
+						newW = int(float64(w) * float64(newH) / float64(h))
+					}
+					thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
+					draw.ApproxBiLinear.Scale(thumbImg, thumbImg.Bounds(), decoded, b, draw.Over, nil)
+					if out, err := os.Create(thumbPath); err == nil {
+						_ = jpeg.Encode(out, thumbImg, &jpeg.Options{Quality: 75})
+						_ = out.Close()
+						thumbRel = filepath.ToSlash(filepath.Join("images", "thumbs", thumbFilename))
 					}
 				}
 
 				resultsCh <- importedImage{
-					Filename:    job.Name,
+					SrcPath:     job.SrcPath,
+					Filename:    name,
 					OriginalRel: originalRel,
 					ThumbRel:    thumbRel,
+					Meta:        meta,
 				}
 			}
 		}()
@@ -262,10 +330,15 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 	}()
 
 	imported := make([]importedImage, 0, len(jobs))
+	var linked, skipped []importedImage
 	processed := 0
 	for res := range resultsCh {
 		if res.Filename != "" {
 			imported = append(imported, res)
+		} else if res.LinkImageID > 0 || res.LinkHash != "" {
+			linked = append(linked, res)
+		} else if res.DupImageID > 0 || res.DupHash != "" {
+			skipped = append(skipped, res)
 		}
 		processed++
 		importTasksMu.Lock()
@@ -286,7 +359,6 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		log.Printf("import task: hard link summary for task %s - success=%d, fallback_to_copy=%d", taskID, s, f)
 	}
 
-	dbPath := filepath.Join(projectRoot, "db", "project.db")
 	db, err := openProjectDB(dbPath)
 	if err != nil {
 		log.Printf("import task: open db failed: %v", err)
@@ -322,13 +394,25 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		return
 	}
 
+	// 本批新图片的摘要 -> ID，用于解析批内的 link
+	batchIDs := make(map[string]int64, len(imported))
+	// 源文件 -> 图片 ID，用于写入清单信息
+	srcIDs := make(map[string]int64, len(imported))
 	for idx, imgInfo := range imported {
 		res, err := tx.Exec(
-			`INSERT INTO image_index (filename, original_rel_path, thumb_rel_path, deleted_in_project, created_at) VALUES (?, ?, ?, 0, ?);`,
+			`INSERT INTO image_index (filename, original_rel_path, thumb_rel_path, deleted_in_project, created_at, width, height, file_size, format, sha256, phash, orientation, bit_depth) VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
 			imgInfo.Filename,
 			imgInfo.OriginalRel,
 			imgInfo.ThumbRel,
 			time.Now().UTC().Format(time.RFC3339),
+			imgInfo.Meta.Width,
+			imgInfo.Meta.Height,
+			imgInfo.Meta.FileSize,
+			imgInfo.Meta.Format,
+			imgInfo.Meta.SHA256,
+			imgInfo.Meta.PHash,
+			imgInfo.Meta.Orientation,
+			imgInfo.Meta.BitDepth,
 		)
 		if err != nil {
 			log.Printf("import task: insert image_index failed: %v", err)
@@ -338,6 +422,12 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		// 创建引用计数记录
 		if imageID, err := res.LastInsertId(); err == nil {
 			_, _ = tx.Exec(`INSERT INTO image_ref_count (image_id, ref_count) VALUES (?, 1);`, imageID)
+			srcIDs[imgInfo.SrcPath] = imageID
+			if imgInfo.Meta.SHA256 != "" {
+				if _, exists := batchIDs[imgInfo.Meta.SHA256]; !exists {
+					batchIDs[imgInfo.Meta.SHA256] = imageID
+				}
+			}
 		}
 
 		importTasksMu.Lock()
@@ -352,6 +442,45 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		importTasksMu.Unlock()
 	}
 
+	// link 策略：不新增记录，只增加被指向图片的引用计数
+	for _, l := range linked {
+		imageID := l.LinkImageID
+		if imageID == 0 {
+			imageID = batchIDs[l.LinkHash]
+		}
+		if imageID == 0 {
+			log.Printf("import task: link target for %s was not imported", l.LinkHash)
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO image_ref_count (image_id, ref_count) VALUES (?, 2) ON CONFLICT(image_id) DO UPDATE SET ref_count = ref_count + 1;`, imageID); err != nil {
+			log.Printf("import task: increment ref_count for image %d failed: %v", imageID, err)
+		}
+		srcIDs[l.SrcPath] = imageID
+	}
+
+	if manifest != nil {
+		for _, d := range skipped {
+			imageID := d.DupImageID
+			if imageID == 0 {
+				imageID = batchIDs[d.DupHash]
+			}
+			if imageID > 0 {
+				srcIDs[d.SrcPath] = imageID
+			}
+		}
+		if err := applyImportManifest(tx, manifest, srcIDs); err != nil {
+			log.Printf("import task: apply manifest failed: %v", err)
+			tx.Rollback()
+			importTasksMu.Lock()
+			if task, ok := importTasks[taskID]; ok {
+				task.Phase = importPhaseFailed
+				task.Error = "manifest_apply_failed"
+			}
+			importTasksMu.Unlock()
+			return
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("import task: commit tx failed: %v", err)
 		importTasksMu.Lock()
@@ -363,6 +492,10 @@ func runImportImagesTask(dataPath, projectID, taskID, importMode string, imagePa
 		return
 	}
 
+	refreshProjectCounts(dataPath, projectID)
+	// 超大图片在后台预生成瓦片
+	go scheduleProjectTiles(projectRoot)
+
 	importTasksMu.Lock()
 	if task, ok := importTasks[taskID]; ok {
 		task.Phase = importPhaseCompleted
@@ -386,10 +519,13 @@ func handleImportImages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type importImagesRequest struct {
-		ProjectID  string   `json:"projectId"`
-		Mode       string   `json:"mode"`
-		ImportMode string   `json:"importMode"`
-		Paths      []string `json:"paths"`
+		ProjectID  string `json:"projectId"`
+		Mode       string `json:"mode"`
+		ImportMode string `json:"importMode"`
+		// Paths mode=manifest 时为清单文件（.csv / .jsonl）路径
+		Paths []string `json:"paths"`
+		// DuplicatePolicy 内容重复时的处理：skip（默认）/ rename / link
+		DuplicatePolicy string `json:"duplicatePolicy"`
 	}
 
 	var req importImagesRequest
@@ -409,7 +545,7 @@ func handleImportImages(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"project_required"}`))
 		return
 	}
-	if req.Mode != "directory" && req.Mode != "files" {
+	if req.Mode != "directory" && req.Mode != "files" && req.Mode != "manifest" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(`{"error":"mode_invalid"}`))
@@ -424,6 +560,16 @@ func handleImportImages(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"import_mode_invalid"}`))
 		return
 	}
+	req.DuplicatePolicy = strings.TrimSpace(strings.ToLower(req.DuplicatePolicy))
+	if req.DuplicatePolicy == "" {
+		req.DuplicatePolicy = duplicatePolicySkip
+	}
+	if req.DuplicatePolicy != duplicatePolicySkip && req.DuplicatePolicy != duplicatePolicyRename && req.DuplicatePolicy != duplicatePolicyLink {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"duplicate_policy_invalid"}`))
+		return
+	}
 	if len(req.Paths) == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -431,6 +577,32 @@ func handleImportImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 清单在创建任务前解析，格式错误直接返回出错的行
+	var manifest *importManifest
+	if req.Mode == "manifest" {
+		// 清单模式一次只导入一个清单文件
+		if len(req.Paths) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"manifest_single_path_required"}`))
+			return
+		}
+		m, err := readImportManifest(strings.TrimSpace(req.Paths[0]))
+		if err != nil {
+			log.Printf("import images: read manifest %s failed: %v", req.Paths[0], err)
+			resp := map[string]interface{}{"error": "manifest_invalid", "code": manifestErrUnreadable}
+			var me *manifestError
+			if errors.As(err, &me) {
+				resp = me.response()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		manifest = m
+	}
+
 	cfg, err := loadPathsConfig()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -505,6 +677,25 @@ func handleImportImages(w http.ResponseWriter, r *http.Request) {
 					return
 				}
 				imagePaths = files
+			} else if req.Mode == "manifest" {
+				// 清单中不存在的文件记录在任务结果里，其余照常导入
+				var missing []string
+				for _, p := range manifest.paths() {
+					info, err := os.Stat(p)
+					if err != nil || info.IsDir() || !isImagePath(p) {
+						missing = append(missing, p)
+						continue
+					}
+					imagePaths = append(imagePaths, p)
+				}
+				if len(missing) > 0 {
+					log.Printf("import images: manifest lists %d missing or unsupported files", len(missing))
+					importTasksMu.Lock()
+					if task, ok := importTasks[taskID]; ok {
+						task.MissingFiles = missing
+					}
+					importTasksMu.Unlock()
+				}
 			} else {
 				seen := make(map[string]struct{})
 				for _, p := range req.Paths {
@@ -565,7 +756,7 @@ func handleImportImages(w http.ResponseWriter, r *http.Request) {
 		importTasksMu.Unlock()
 
 		log.Printf("import images: async scan completed for task %s, found=%d, elapsed=%s", taskID, len(imagePaths), time.Since(scanStart))
-		runImportImagesTask(cfg.DataPath, req.ProjectID, taskID, req.ImportMode, imagePaths)
+		runImportImagesTask(cfg.DataPath, req.ProjectID, taskID, req.ImportMode, req.DuplicatePolicy, imagePaths, manifest)
 	}()
 
 	w.Header().Set("Content-Type", "application/json")
@@ -599,8 +790,17 @@ func handleImportTaskStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 在锁内复制一份，避免编码时与任务更新并发读写
 	importTasksMu.RLock()
-	task, ok := importTasks[id]
+	var task ImportTaskStatus
+	current, ok := importTasks[id]
+	if ok {
+		task = *current
+		task.Duplicates = append([]ImportDuplicate(nil), current.Duplicates...)
+		task.MissingFiles = append([]string(nil), current.MissingFiles...)
+		task.CorruptFiles = append([]string(nil), current.CorruptFiles...)
+		task.StillReferenced = append([]int64(nil), current.StillReferenced...)
+	}
 	importTasksMu.RUnlock()
 	if !ok {
 		w.Header().Set("Content-Type", "application/json")
@@ -634,6 +834,14 @@ func handleProjectImages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query, code := parseImageListQuery(r.URL.Query())
+	if code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"` + code + `"}`))
+		return
+	}
+
 	cfg, err := loadPathsConfig()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -644,7 +852,7 @@ func handleProjectImages(w http.ResponseWriter, r *http.Request) {
 
 	projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
 	dbPath := filepath.Join(projectRoot, "db", "project.db")
-	db, err := openProjectDB(dbPath)
+	db, err := acquireProjectDB(dbPath)
 	if err != nil {
 		log.Printf("project images: open db failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -652,11 +860,23 @@ func handleProjectImages(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
-	defer db.Close()
+	defer db.Release()
 
-	_, _ = db.Exec(`ALTER TABLE image_index ADD COLUMN annotation_status TEXT NOT NULL DEFAULT 'none';`)
+	// 带筛选或分页参数时走分页查询；不带参数时保持一次返回全部
+	if query.paged {
+		writeFilteredProjectImages(w, db, query)
+		return
+	}
 
-	rows, err := db.Query(`SELECT id, filename, original_rel_path, thumb_rel_path, COALESCE(annotation_status, 'none') FROM image_index WHERE deleted_in_project = 0 ORDER BY id ASC;`)
+	stmt, err := db.Prepared(sqlListProjectImages)
+	if err != nil {
+		log.Printf("project images: prepare failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	rows, err := stmt.Query()
 	if err != nil {
 		log.Printf("project images: query failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -674,7 +894,8 @@ func handleProjectImages(w http.ResponseWriter, r *http.Request) {
 		var originalRel string
 		var thumbRel string
 		var annotationStatus string
-		if err := rows.Scan(&id, &filename, &originalRel, &thumbRel, &annotationStatus); err != nil {
+		var meta imageFileMeta
+		if err := rows.Scan(&id, &filename, &originalRel, &thumbRel, &annotationStatus, &meta.Width, &meta.Height, &meta.FileSize, &meta.Format, &meta.SHA256); err != nil {
 			log.Printf("project images: scan failed: %v", err)
 			continue
 		}
@@ -686,22 +907,7 @@ func handleProjectImages(w http.ResponseWriter, r *http.Request) {
 		default:
 			unannotatedCount++
 		}
-		isExternal := !(strings.HasPrefix(originalRel, "images/") || strings.HasPrefix(originalRel, "./images/"))
-		hasThumb := thumbRel != "" && thumbRel != originalRel
-		originalPath := originalRel
-		thumbPath := thumbRel
-		if thumbPath == "" {
-			thumbPath = originalPath
-		}
-		items = append(items, projectImageListItem{
-			ID:               id,
-			Filename:         filename,
-			HasThumb:         hasThumb,
-			IsExternal:       isExternal,
-			ThumbPath:        thumbPath,
-			OriginalPath:     originalPath,
-			AnnotationStatus: annotationStatus,
-		})
+		items = append(items, newProjectImageListItem(id, filename, originalRel, thumbRel, annotationStatus, meta))
 	}
 	if err := rows.Err(); err != nil {
 		log.Printf("project images: rows error: %v", err)
@@ -898,7 +1104,7 @@ func runDeleteImagesTask(dataPath, projectID, taskID string, imageIDs []int64) {
 	}
 	importTasksMu.Unlock()
 
-	// 从项目中删除图片：只设置 deleted_in_project = 1，不减少引用计数，不删除文件
+	// 从项目中删除图片：只设置 deleted_in_project = 1，不减少引用计数，不删除文件（由回收站清除）
 	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("[DeleteImages] Task %s begin tx failed: %v", taskID, err)
@@ -911,13 +1117,26 @@ func runDeleteImagesTask(dataPath, projectID, taskID string, imageIDs []int64) {
 		return
 	}
 
-	// 删除对应的标注数据
+	// 标注移入回收站，恢复图片时一并恢复
+	trashQuery := fmt.Sprintf(`INSERT OR REPLACE INTO trashed_annotations (id, image_id, category_id, type, data, created_at, updated_at)
+		SELECT id, image_id, category_id, type, data, created_at, updated_at FROM annotations WHERE image_id IN (%s);`, inClause)
+	if _, err := tx.Exec(trashQuery, args...); err != nil {
+		log.Printf("[DeleteImages] Task %s move annotations to trash failed: %v", taskID, err)
+		tx.Rollback()
+		importTasksMu.Lock()
+		if task, ok := importTasks[taskID]; ok {
+			task.Phase = importPhaseFailed
+			task.Error = "update_failed"
+		}
+		importTasksMu.Unlock()
+		return
+	}
 	annQuery := fmt.Sprintf(`DELETE FROM annotations WHERE image_id IN (%s);`, inClause)
 	_, _ = tx.Exec(annQuery, args...)
 
 	// 标记在项目中已删除
-	updQuery := fmt.Sprintf(`UPDATE image_index SET deleted_in_project = 1 WHERE id IN (%s);`, inClause)
-	if _, err := tx.Exec(updQuery, args...); err != nil {
+	updQuery := fmt.Sprintf(`UPDATE image_index SET deleted_in_project = 1, deleted_at = ?, annotation_revision = annotation_revision + 1 WHERE id IN (%s) AND deleted_in_project = 0;`, inClause)
+	if _, err := tx.Exec(updQuery, append([]interface{}{time.Now().UTC().Format(time.RFC3339)}, args...)...); err != nil {
 		log.Printf("[DeleteImages] Task %s batch update failed: %v", taskID, err)
 		tx.Rollback()
 		importTasksMu.Lock()
@@ -940,6 +1159,8 @@ func runDeleteImagesTask(dataPath, projectID, taskID string, imageIDs []int64) {
 		return
 	}
 
+	refreshProjectCounts(dataPath, projectID)
+
 	importTasksMu.Lock()
 	if task, ok := importTasks[taskID]; ok {
 		task.Phase = importPhaseCompleted
@@ -971,6 +1192,13 @@ func handleProjectImageFile(w http.ResponseWriter, r *http.Request) {
 	if kind == "" {
 		kind = "thumb"
 	}
+	window, ok := parseDisplayWindow(strings.TrimSpace(r.URL.Query().Get("window")))
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"window_invalid"}`))
+		return
+	}
 	if projectID == "" || imageIDStr == "" {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -995,7 +1223,7 @@ func handleProjectImageFile(w http.ResponseWriter, r *http.Request) {
 
 	projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
 	dbPath := filepath.Join(projectRoot, "db", "project.db")
-	db, err := openProjectDB(dbPath)
+	db, err := acquireProjectDB(dbPath)
 	if err != nil {
 		log.Printf("project image file: open db failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -1003,12 +1231,14 @@ func handleProjectImageFile(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
 		return
 	}
-	defer db.Close()
+	defer db.Release()
 
 	var originalRel string
 	var thumbRel string
-	row := db.QueryRow(`SELECT original_rel_path, thumb_rel_path FROM image_index WHERE id = ?;`, imageID)
-	if err := row.Scan(&originalRel, &thumbRel); err != nil {
+	var sha, format string
+	var orientation, bitDepth int
+	row := db.QueryRow(`SELECT original_rel_path, thumb_rel_path, sha256, orientation, format, bit_depth FROM image_index WHERE id = ?;`, imageID)
+	if err := row.Scan(&originalRel, &thumbRel, &sha, &orientation, &format, &bitDepth); err != nil {
 		if err == sql.ErrNoRows {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusNotFound)
@@ -1035,15 +1265,10 @@ func handleProjectImageFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fullPath string
-	if filepath.IsAbs(chosen) {
-		fullPath = chosen
-	} else {
-		fullPath = filepath.Join(projectRoot, filepath.FromSlash(chosen))
-	}
-
+	fullPath := resolveProjectImagePath(projectRoot, chosen)
+	// 库中的绝对路径是导入时登记的外部文件；相对路径不能经由 .. 或符号链接跳出项目目录
 	info, err := os.Stat(fullPath)
-	if err != nil || info.IsDir() {
+	if err != nil || info.IsDir() || (!filepath.IsAbs(chosen) && !isRealPathWithin(projectRoot, fullPath)) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
 		_, _ = w.Write([]byte(`{"error":"file_not_found"}`))
@@ -1051,6 +1276,13 @@ func handleProjectImageFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	if chosen == originalRel && needsDisplayCopy(format, bitDepth, orientation) {
+		err := serveDisplayOriginal(w, r, cfg.DataPath, projectID, imageID, sha, orientation, window, fullPath, info)
+		if err == nil {
+			return
+		}
+		log.Printf("project image file: display copy of image %d failed, serving raw file: %v", imageID, err)
+	}
 	http.ServeFile(w, r, fullPath)
 }
 
@@ -1083,18 +1315,13 @@ func handleProjectImageFileByPath(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fullPath string
-	if filepath.IsAbs(pathParam) {
-		fullPath = pathParam
-	} else {
-		if strings.Contains(pathParam, "..") {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte(`{"error":"path_invalid"}`))
-			return
-		}
-		projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
-		fullPath = filepath.Join(projectRoot, filepath.FromSlash(pathParam))
+	fullPath, ok := resolveServedPath(cfg.DataPath, projectID, pathParam)
+	if !ok {
+		// 文件不存在时同样无法解析真实路径，统一返回 403，不暴露目录外文件是否存在
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":"path_forbidden"}`))
+		return
 	}
 
 	info, err := os.Stat(fullPath)
@@ -1108,5 +1335,35 @@ func handleProjectImageFileByPath(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=31536000")
 	imageFileSem <- struct{}{}
 	defer func() { <-imageFileSem }()
+	// 原图带 EXIF 旋转方向或浏览器无法正确显示时返回转正、映射后的副本，与缩略图和标注坐标保持一致
+	if imageID, sha, orientation, ok := lookupDisplayOriginal(cfg.DataPath, projectID, pathParam); ok {
+		err := serveDisplayOriginal(w, r, cfg.DataPath, projectID, imageID, sha, orientation, displayWindowPercentile, fullPath, info)
+		if err == nil {
+			return
+		}
+		log.Printf("project image file: display copy of image %d failed, serving raw file: %v", imageID, err)
+	}
 	http.ServeFile(w, r, fullPath)
 }
+
+// lookupDisplayOriginal 按原图路径查找需要显示副本的图片
+func lookupDisplayOriginal(dataPath, projectID, rel string) (int64, string, int, bool) {
+	dbPath := filepath.Join(dataPath, "project_item", projectID, "db", "project.db")
+	if !fileExists(dbPath) {
+		return 0, "", 0, false
+	}
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return 0, "", 0, false
+	}
+	defer db.Release()
+	var id int64
+	var sha, format string
+	var orientation, bitDepth int
+	err = db.QueryRow(`SELECT id, sha256, orientation, format, bit_depth FROM image_index WHERE original_rel_path IN (?, ?) LIMIT 1;`,
+		rel, filepath.ToSlash(rel)).Scan(&id, &sha, &orientation, &format, &bitDepth)
+	if err != nil || !needsDisplayCopy(format, bitDepth, orientation) {
+		return 0, "", 0, false
+	}
+	return id, sha, orientation, true
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 只有 external 模式导入的图片在 image_index 中保存绝对路径（link 模式是硬链接到 images/originals），
+// 盘符变化或网络共享重新挂载后这些图片会找不到文件
+
+// linkSampleLimit 检查与重定位结果中最多列出的缺失文件数
+const linkSampleLimit = 200
+
+// MissingLinkImage 文件不存在的外部图片
+type MissingLinkImage struct {
+	ImageID  int64  `json:"imageId"`
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Trashed  bool   `json:"trashed,omitempty"`
+}
+
+// MissingLinkDir 按所在目录汇总的缺失数，便于确定重定位的旧根目录
+type MissingLinkDir struct {
+	Dir   string `json:"dir"`
+	Count int    `json:"count"`
+}
+
+// LinkCheckReport 外部图片检查报告；回收站中的图片单独计数（Checked、MissingCount 不含它们），
+// 缺失列表和目录汇总包含两者
+type LinkCheckReport struct {
+	Checked             int                `json:"checked"`
+	MissingCount        int                `json:"missingCount"`
+	TrashedChecked      int                `json:"trashedChecked"`
+	TrashedMissingCount int                `json:"trashedMissingCount"`
+	Missing             []MissingLinkImage `json:"missing,omitempty"`
+	MissingDirs         []MissingLinkDir   `json:"missingDirs,omitempty"`
+}
+
+// externalImage image_index 中路径为绝对路径的一条记录
+type externalImage struct {
+	ID       int64
+	Filename string
+	Original string
+	Thumb    string
+	SHA256   string
+	FileSize int64
+	Trashed  bool
+}
+
+// queryExternalImages 列出外部图片；includeTrashed 为 false 时不含回收站中的图片，ids 非空时只取其中的图片
+func queryExternalImages(db *projectDBHandle, ids []int64, includeTrashed bool) ([]externalImage, error) {
+	query := `SELECT id, filename, original_rel_path, thumb_rel_path, sha256, file_size, deleted_in_project FROM image_index WHERE 1 = 1`
+	if !includeTrashed {
+		query += ` AND deleted_in_project = 0`
+	}
+	args := make([]interface{}, 0, len(ids))
+	if len(ids) > 0 {
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		query += fmt.Sprintf(` AND id IN (%s)`, placeholders(len(args)))
+	}
+	rows, err := db.Query(query+` ORDER BY id ASC;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var images []externalImage
+	for rows.Next() {
+		var img externalImage
+		if err := rows.Scan(&img.ID, &img.Filename, &img.Original, &img.Thumb, &img.SHA256, &img.FileSize, &img.Trashed); err != nil {
+			return nil, err
+		}
+		if img.Original != "" && filepath.IsAbs(filepath.FromSlash(img.Original)) {
+			images = append(images, img)
+		}
+	}
+	return images, rows.Err()
+}
+
+// isRegularFile 路径存在且是普通文件
+func isRegularFile(path string) (os.FileInfo, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	return info, true
+}
+
+// projectImageDBPaths 项目当前库及所有版本快照库
+func projectImageDBPaths(projectRoot string) []string {
+	dbPaths := []string{filepath.Join(projectRoot, "db", "project.db")}
+	if matches, err := filepath.Glob(filepath.Join(projectRoot, "db", "versions", "v*", "project.db")); err == nil {
+		dbPaths = append(dbPaths, matches...)
+	}
+	return dbPaths
+}
+
+// applyImagePathChanges 按 旧路径 -> 新路径 改写一个库中的原图和缩略图路径
+func applyImagePathChanges(dbPath string, changes map[string]string) (int64, error) {
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Release()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var changed int64
+	for oldPath, newPath := range changes {
+		res, err := tx.Exec(`UPDATE image_index SET original_rel_path = ? WHERE original_rel_path = ?;`, newPath, oldPath)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		changed += n
+		if _, err := tx.Exec(`UPDATE image_index SET thumb_rel_path = ? WHERE thumb_rel_path = ?;`, newPath, oldPath); err != nil {
+			return 0, err
+		}
+	}
+	return changed, tx.Commit()
+}
+
+// applyImagePathChangesToVersions 同步改写版本快照，失败只记录日志（快照仍指向原路径）
+func applyImagePathChangesToVersions(projectRoot string, changes map[string]string) {
+	for _, dbPath := range projectImageDBPaths(projectRoot)[1:] {
+		if _, err := applyImagePathChanges(dbPath, changes); err != nil {
+			log.Printf("[ImageLinks] rewrite %s failed: %v", dbPath, err)
+		}
+	}
+}
+
+// handleCheckImageLinks 启动外部图片检查任务，结果在任务状态的 linkReport 中
+func handleCheckImageLinks(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"projectId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+	// 只读检查，不占用 IO 锁
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: req.ProjectID,
+		TaskType:  taskTypeCheckLinks,
+		Phase:     importPhaseScanning,
+	}
+	importTasksMu.Unlock()
+
+	go runCheckImageLinks(cfg.DataPath, req.ProjectID, taskID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID})
+}
+
+// runCheckImageLinks 逐个检查外部图片的文件是否存在
+func runCheckImageLinks(dataPath, projectID, taskID string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ImageLinks] Task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_check_task")
+		}
+	}()
+
+	dbPath := filepath.Join(dataPath, "project_item", projectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		failImportTask(taskID, "db_unavailable")
+		return
+	}
+	// 回收站中的图片恢复后同样需要原文件，一并检查
+	images, err := queryExternalImages(db, nil, true)
+	db.Release()
+	if err != nil {
+		log.Printf("[ImageLinks] Task %s query failed: %v", taskID, err)
+		failImportTask(taskID, "query_failed")
+		return
+	}
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Total = len(images)
+	})
+
+	report := &LinkCheckReport{}
+	dirs := make(map[string]int)
+	for i, img := range images {
+		if img.Trashed {
+			report.TrashedChecked++
+		} else {
+			report.Checked++
+		}
+		if _, ok := isRegularFile(filepath.FromSlash(img.Original)); !ok {
+			if img.Trashed {
+				report.TrashedMissingCount++
+			} else {
+				report.MissingCount++
+			}
+			dirs[filepath.Dir(filepath.FromSlash(img.Original))]++
+			if len(report.Missing) < linkSampleLimit {
+				report.Missing = append(report.Missing, MissingLinkImage{ImageID: img.ID, Filename: img.Filename, Path: img.Original, Trashed: img.Trashed})
+			}
+		}
+		if done := i + 1; done%100 == 0 || done == len(images) {
+			updateImportTask(taskID, func(task *ImportTaskStatus) {
+				task.Imported = done
+				task.Progress = done * 100 / task.Total
+			})
+		}
+	}
+	for dir, n := range dirs {
+		report.MissingDirs = append(report.MissingDirs, MissingLinkDir{Dir: dir, Count: n})
+	}
+	sort.Slice(report.MissingDirs, func(i, j int) bool {
+		if report.MissingDirs[i].Count != report.MissingDirs[j].Count {
+			return report.MissingDirs[i].Count > report.MissingDirs[j].Count
+		}
+		return report.MissingDirs[i].Dir < report.MissingDirs[j].Dir
+	})
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+		task.Imported = task.Total
+		task.LinkReport = report
+	})
+	log.Printf("[ImageLinks] Task %s project %s: %d external images, %d missing (trash: %d, %d missing)", taskID, projectID, report.Checked, report.MissingCount, report.TrashedChecked, report.TrashedMissingCount)
+}
+
+// handleRelinkImages 把位于 oldRoot 下的外部图片改到 newRoot 下的同一相对位置
+// 请求体 {projectId, oldRoot, newRoot, dryRun}；只改写新位置上文件存在（且大小一致）的图片，版本快照一并改写。
+// 回收站中的图片同样改写，计数单独返回（trashed*），relinked 为实际改写的记录数，含回收站中的图片
+func handleRelinkImages(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string `json:"projectId"`
+		OldRoot   string `json:"oldRoot"`
+		NewRoot   string `json:"newRoot"`
+		DryRun    bool   `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	oldRoot := filepath.Clean(strings.TrimSpace(req.OldRoot))
+	newRoot := filepath.Clean(strings.TrimSpace(req.NewRoot))
+	if !filepath.IsAbs(oldRoot) || !filepath.IsAbs(newRoot) || oldRoot == newRoot {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"root_invalid"}`))
+		return
+	}
+	if info, err := os.Stat(newRoot); err != nil || !info.IsDir() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"new_root_not_found"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	if !req.DryRun {
+		lockID := "relink_" + req.ProjectID
+		if !tryAcquireIOLock(lockID) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+			return
+		}
+		defer releaseIOLock(lockID)
+	}
+
+	projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	images, err := queryExternalImages(db, nil, true)
+	db.Release()
+	if err != nil {
+		log.Printf("[ImageLinks] relink query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+
+	changes := make(map[string]string)
+	matched, trashedMatched := 0, 0
+	missingCount, trashedMissingCount := 0, 0
+	missing := make([]MissingLinkImage, 0)
+	for _, img := range images {
+		newPath, ok := rebasePath(img.Original, oldRoot, newRoot)
+		if !ok {
+			continue
+		}
+		if img.Trashed {
+			trashedMatched++
+		} else {
+			matched++
+		}
+		info, ok := isRegularFile(filepath.FromSlash(newPath))
+		if !ok || (img.FileSize > 0 && info.Size() != img.FileSize) {
+			if img.Trashed {
+				trashedMissingCount++
+			} else {
+				missingCount++
+			}
+			if len(missing) < linkSampleLimit {
+				missing = append(missing, MissingLinkImage{ImageID: img.ID, Filename: img.Filename, Path: newPath, Trashed: img.Trashed})
+			}
+			continue
+		}
+		changes[img.Original] = newPath
+		if t, ok := rebasePath(img.Thumb, oldRoot, newRoot); ok && img.Thumb != img.Original {
+			changes[img.Thumb] = t
+		}
+	}
+
+	relinked := int64(0)
+	if !req.DryRun && len(changes) > 0 {
+		n, err := applyImagePathChanges(dbPath, changes)
+		if err != nil {
+			log.Printf("[ImageLinks] relink project %s failed: %v", req.ProjectID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"update_failed"}`))
+			return
+		}
+		relinked = n
+		applyImagePathChangesToVersions(projectRoot, changes)
+		log.Printf("[ImageLinks] project %s: relinked %d images %s -> %s (%d not found, %d in trash)", req.ProjectID, n, oldRoot, newRoot, missingCount, trashedMissingCount)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":             true,
+		"dryRun":              req.DryRun,
+		"matched":             matched,
+		"relinkable":          matched - missingCount,
+		"relinked":            relinked,
+		"missingCount":        missingCount,
+		"missing":             missing,
+		"trashedMatched":      trashedMatched,
+		"trashedRelinkable":   trashedMatched - trashedMissingCount,
+		"trashedMissingCount": trashedMissingCount,
+	})
+}
+
+// handleLocalizeImages 把外部图片复制到项目目录，之后不再依赖原位置
+// 请求体 {projectId, imageIds | filter}；两者都为空时处理项目中全部外部图片
+func handleLocalizeImages(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req imageSelection
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	var ids []int64
+	if len(req.ImageIDs) > 0 || strings.TrimSpace(req.Filter) != "" {
+		var code string
+		ids, code = resolveImageSelection(db, req)
+		if code != "" {
+			db.Release()
+			w.Header().Set("Content-Type", "application/json")
+			status := http.StatusBadRequest
+			if code == "query_failed" {
+				status = http.StatusInternalServerError
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error":"` + code + `"}`))
+			return
+		}
+		if len(ids) == 0 {
+			db.Release()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"images_required"}`))
+			return
+		}
+	}
+	images, err := queryExternalImages(db, ids, false)
+	db.Release()
+	if err != nil {
+		log.Printf("[ImageLinks] localize query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: req.ProjectID,
+		TaskType:  taskTypeLocalizeImages,
+		Phase:     importPhaseCopying,
+		Total:     len(images),
+	}
+	importTasksMu.Unlock()
+
+	go runLocalizeImages(cfg.DataPath, req.ProjectID, taskID, images)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID, "total": len(images)})
+}
+
+// runLocalizeImages 逐个复制外部图片到 images/originals 并改写路径
+// 源文件缺失的记入 MissingFiles；内容与导入时的摘要不一致的记入 CorruptFiles，均保持原样
+func runLocalizeImages(dataPath, projectID, taskID string, images []externalImage) {
+	defer releaseIOLock(taskID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ImageLinks] Task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_localize_task")
+		}
+	}()
+
+	projectRoot := filepath.Join(dataPath, "project_item", projectID)
+	originalsDir := filepath.Join(projectRoot, "images", "originals")
+	if err := os.MkdirAll(originalsDir, 0o755); err != nil {
+		failImportTask(taskID, "originals_dir_failed")
+		return
+	}
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		failImportTask(taskID, "db_unavailable")
+		return
+	}
+	defer db.Release()
+
+	changes := make(map[string]string)
+	var missing, corrupt []string
+	for i, img := range images {
+		src := filepath.FromSlash(img.Original)
+		if _, ok := isRegularFile(src); !ok {
+			missing = append(missing, img.Original)
+		} else {
+			name := uniqueOriginalName(originalsDir, img.Filename)
+			dst := filepath.Join(originalsDir, name)
+			sum, err := copyFileWithSHA256(src, dst)
+			switch {
+			case err != nil:
+				log.Printf("[ImageLinks] Task %s copy %s failed: %v", taskID, src, err)
+				_ = os.Remove(dst)
+				missing = append(missing, img.Original)
+			case img.SHA256 != "" && sum != img.SHA256:
+				_ = os.Remove(dst)
+				corrupt = append(corrupt, img.Original)
+			default:
+				rel := filepath.ToSlash(filepath.Join("images", "originals", name))
+				thumb := img.Thumb
+				if thumb == img.Original {
+					thumb = rel
+				}
+				if _, err := db.Exec(`UPDATE image_index SET original_rel_path = ?, thumb_rel_path = ? WHERE id = ?;`, rel, thumb, img.ID); err != nil {
+					log.Printf("[ImageLinks] Task %s update image %d failed: %v", taskID, img.ID, err)
+					_ = os.Remove(dst)
+					failImportTask(taskID, "update_failed")
+					return
+				}
+				changes[img.Original] = rel
+			}
+		}
+		done := i + 1
+		updateImportTask(taskID, func(task *ImportTaskStatus) {
+			task.Imported = done
+			if task.Total > 0 {
+				task.Progress = done * 100 / task.Total
+			}
+		})
+	}
+	if len(changes) > 0 {
+		applyImagePathChangesToVersions(projectRoot, changes)
+	}
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+		task.Imported = len(changes)
+		task.MissingFiles = missing
+		task.CorruptFiles = corrupt
+	})
+	log.Printf("[ImageLinks] Task %s project %s: copied %d external images (%d missing, %d changed)", taskID, projectID, len(changes), len(missing), len(corrupt))
+}
+
+// uniqueOriginalName images/originals 中尚未存在的文件名：name.jpg、name_1.jpg ...
+func uniqueOriginalName(originalsDir, name string) string {
+	if _, err := os.Lstat(filepath.Join(originalsDir, name)); os.IsNotExist(err) {
+		return name
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if _, err := os.Lstat(filepath.Join(originalsDir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -13,17 +13,43 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", handleHealthz)
 	mux.HandleFunc("/api/projects", handleProjects)
+	mux.HandleFunc("/api/projects/open", handleProjectOpened)
+	mux.HandleFunc("/api/projects/archive/export", handleProjectArchiveExport)
+	mux.HandleFunc("/api/projects/archive/import", handleProjectArchiveImport)
 	mux.HandleFunc("/api/import-images", handleImportImages)
 	mux.HandleFunc("/api/import-tasks", handleImportTaskStatus)
 	mux.HandleFunc("/api/project-images", handleProjectImages)
 	mux.HandleFunc("/api/project-images/delete", handleDeleteProjectImages)
+	mux.HandleFunc("/api/project-images/duplicates", handleProjectImageDuplicates)
+	mux.HandleFunc("/api/project-images/duplicates/resolve", handleResolveImageDuplicates)
+	mux.HandleFunc("/api/project-images/trash", handleProjectImageTrash)
+	mux.HandleFunc("/api/project-images/trash/restore", handleRestoreTrashImages)
+	mux.HandleFunc("/api/project-images/trash/purge", handlePurgeTrashImages)
+	mux.HandleFunc("/api/project-images/tags", handleProjectImageTags)
+	mux.HandleFunc("/api/project-images/tags/add", handleAddImageTags)
+	mux.HandleFunc("/api/project-images/tags/remove", handleRemoveImageTags)
+	mux.HandleFunc("/api/project-images/metadata", handleUpdateImageMetadata)
+	mux.HandleFunc("/api/project-images/links/check", handleCheckImageLinks)
+	mux.HandleFunc("/api/project-images/links/relink", handleRelinkImages)
+	mux.HandleFunc("/api/project-images/links/localize", handleLocalizeImages)
 	mux.HandleFunc("/api/project-image", handleProjectImageFile)
+	mux.HandleFunc("/api/project-image/thumb", handleProjectImageThumb)
+	mux.HandleFunc("/api/project-image/dzi", handleProjectImageDZI)
+	mux.HandleFunc("/api/project-image/tile", handleProjectImageTile)
 	mux.HandleFunc("/api/project-image-file", handleProjectImageFileByPath)
+	mux.HandleFunc("/api/project-watch-folders", handleProjectWatchFolders)
+	mux.HandleFunc("/api/project-watch-folders/delete", handleDeleteWatchFolder)
+	mux.HandleFunc("/api/project-watch-folders/pause", handlePauseWatchFolders)
+	mux.HandleFunc("/api/project-watch-folders/resume", handleResumeWatchFolders)
 	mux.HandleFunc("/api/project-categories", handleProjectCategories)
 	mux.HandleFunc("/api/project-categories/edit", handleEditProjectCategory)
 	mux.HandleFunc("/api/project-categories/sort", handleSortProjectCategories)
 	mux.HandleFunc("/api/project-annotations", handleProjectAnnotations)
 	mux.HandleFunc("/api/project-annotations/save", handleSaveAnnotations)
+	mux.HandleFunc("/api/project-annotations/patch", handlePatchAnnotations)
+	mux.HandleFunc("/api/project-annotations/history", handleAnnotationHistory)
+	mux.HandleFunc("/api/project-annotations/restore", handleRestoreAnnotations)
+	mux.HandleFunc("/api/project-annotations/undo", handleUndoAnnotations)
 	mux.HandleFunc("/api/annotations", handleAnnotations)
 	mux.HandleFunc("/api/annotations/", handleAnnotations)
 	// 插件管理
@@ -54,6 +80,9 @@ func main() {
 	mux.HandleFunc("/api/dataset/export-status", handleExportStatus)
 	mux.HandleFunc("/api/plugins/export", handleExportPlugins)
 	mux.HandleFunc("/api/settings/paths", handleSettingsPaths)
+	mux.HandleFunc("/api/settings/relocate", handleRelocateDataPath)
+	mux.HandleFunc("/api/settings/relocate/confirm", handleConfirmRelocation)
+	mux.HandleFunc("/api/storage/gc", handleStorageGC)
 	mux.HandleFunc("/api/shell/open-folder", handleOpenFolder)
 	// 数据集版本管理
 	mux.HandleFunc("/api/dataset-versions", handleDatasetVersions)
@@ -117,6 +146,12 @@ func main() {
 	mux.HandleFunc("/api/inference/stop", handleInferenceStop)
 	mux.HandleFunc("/api/inference/status", handleInferenceStatus)
 
+	// 后台补全旧项目缺失的图片尺寸、大小和摘要
+	startImageMetaBackfill()
+	startProjectDBJanitor()
+	// 监视目录自动导入
+	startWatchFolderPoller()
+
 	addr := ":18080"
 	log.Printf("Starting Go backend on %s\n", addr)
 	if err := http.ListenAndServe(addr, mux); err != nil {
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 清单中的固定列；其余列都作为元数据保存
+const (
+	manifestKeyPath        = "path"
+	manifestKeyLabel       = "label"
+	manifestKeyCaptureTime = "capture_time"
+	// manifestLabelSeparator 一行有多个分类标签时的分隔符
+	manifestLabelSeparator = ";"
+	// manifestCategoryColor 清单自动创建的分类类别颜色，与前端新建分类类别一致
+	manifestCategoryColor = "#808080"
+)
+
+// manifestKeyAliases 常见列名 -> 规范列名
+var manifestKeyAliases = map[string]string{
+	"file":        manifestKeyPath,
+	"filename":    manifestKeyPath,
+	"file_path":   manifestKeyPath,
+	"image":       manifestKeyPath,
+	"image_path":  manifestKeyPath,
+	"class":       manifestKeyLabel,
+	"category":    manifestKeyLabel,
+	"labels":      manifestKeyLabel,
+	"camera":      "camera_id",
+	"captured_at": manifestKeyCaptureTime,
+	"timestamp":   manifestKeyCaptureTime,
+	"datetime":    manifestKeyCaptureTime,
+}
+
+// manifestRow 清单中的一行
+type manifestRow struct {
+	Path     string
+	Labels   []string
+	Metadata map[string]string
+}
+
+// importManifest 按源文件路径（filepath.Clean 后）索引的清单
+type importManifest struct {
+	rows map[string]manifestRow
+}
+
+// paths 清单中的文件路径，按路径排序
+func (m *importManifest) paths() []string {
+	paths := make([]string, 0, len(m.rows))
+	for p := range m.rows {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// 清单错误代码，随 manifest_invalid 一起返回给前端
+const (
+	manifestErrUnreadable         = "unreadable"
+	manifestErrUnsupportedFormat  = "unsupported_format"
+	manifestErrEmpty              = "empty"
+	manifestErrPathColumnMissing  = "path_column_missing"
+	manifestErrMalformedCSV       = "malformed_csv"
+	manifestErrInvalidJSON        = "invalid_json"
+	manifestErrLineTooLong        = "line_too_long"
+	manifestErrPathMissing        = "path_missing"
+	manifestErrInvalidCaptureTime = "invalid_capture_time"
+)
+
+// manifestError 清单错误；Line 从 1 开始，0 表示与具体行无关，Field 为规范化后的列名
+// Detail 只写日志，不返回给前端
+type manifestError struct {
+	Code   string
+	Line   int
+	Field  string
+	Detail string
+}
+
+func (e *manifestError) Error() string {
+	msg := "manifest " + e.Code
+	if e.Line > 0 {
+		msg = fmt.Sprintf("manifest line %d: %s", e.Line, e.Code)
+	}
+	if e.Field != "" {
+		msg += " (" + e.Field + ")"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// response 返回给前端的结构化错误
+func (e *manifestError) response() map[string]interface{} {
+	resp := map[string]interface{}{"error": "manifest_invalid", "code": e.Code}
+	if e.Line > 0 {
+		resp["line"] = e.Line
+	}
+	if e.Field != "" {
+		resp["field"] = e.Field
+	}
+	return resp
+}
+
+// readImportManifest 读取 CSV（首行为列名）或 JSONL（每行一个对象）清单
+// 相对路径相对于清单所在目录；同一文件出现多次时后面的行覆盖前面的元数据，标签合并
+func readImportManifest(path string) (*importManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &manifestError{Code: manifestErrUnreadable, Detail: err.Error()}
+	}
+	defer f.Close()
+
+	var rows []manifestRow
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = parseCSVManifest(f)
+	case ".jsonl", ".ndjson":
+		rows, err = parseJSONLManifest(f)
+	default:
+		return nil, &manifestError{Code: manifestErrUnsupportedFormat, Detail: filepath.Ext(path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := filepath.Dir(path)
+	m := &importManifest{rows: make(map[string]manifestRow, len(rows))}
+	for _, row := range rows {
+		p := filepath.FromSlash(row.Path)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(baseDir, p)
+		}
+		p = filepath.Clean(p)
+		prev, ok := m.rows[p]
+		if !ok {
+			row.Path = p
+			m.rows[p] = row
+			continue
+		}
+		for k, v := range row.Metadata {
+			prev.Metadata[k] = v
+		}
+		prev.Labels = appendUniqueStrings(prev.Labels, row.Labels...)
+		m.rows[p] = prev
+	}
+	return m, nil
+}
+
+// parseCSVManifest 解析 CSV 清单
+func parseCSVManifest(r io.Reader) ([]manifestRow, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, &manifestError{Code: manifestErrEmpty, Line: 1}
+	}
+	if err != nil {
+		return nil, csvManifestError(err, 1)
+	}
+	keys := make([]string, len(header))
+	hasPath := false
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff")
+		}
+		keys[i] = normalizeManifestKey(h)
+		hasPath = hasPath || keys[i] == manifestKeyPath
+	}
+	if !hasPath {
+		return nil, &manifestError{Code: manifestErrPathColumnMissing, Line: 1, Field: manifestKeyPath}
+	}
+
+	var rows []manifestRow
+	line := 1
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvManifestError(err, line+1)
+		}
+		line, _ = cr.FieldPos(0)
+		fields := make(map[string]string, len(record))
+		for i, v := range record {
+			if i < len(keys) && keys[i] != "" {
+				fields[keys[i]] = v
+			}
+		}
+		row, err := newManifestRow(fields, line)
+		if err != nil {
+			return nil, err
+		}
+		if row.Path != "" {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// csvManifestError CSV 读取错误转为清单错误，格式错误时使用 csv 报告的行号
+func csvManifestError(err error, line int) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return &manifestError{Code: manifestErrMalformedCSV, Line: pe.Line, Detail: pe.Err.Error()}
+	}
+	return &manifestError{Code: manifestErrUnreadable, Line: line, Detail: err.Error()}
+}
+
+// parseJSONLManifest 解析 JSONL 清单；标签可以是字符串或字符串数组
+func parseJSONLManifest(r io.Reader) ([]manifestRow, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var rows []manifestRow
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &obj); err != nil {
+			return nil, &manifestError{Code: manifestErrInvalidJSON, Line: line, Detail: err.Error()}
+		}
+		fields := make(map[string]string, len(obj))
+		for k, v := range obj {
+			key := normalizeManifestKey(k)
+			if key == "" {
+				continue
+			}
+			if list, ok := v.([]interface{}); ok && key == manifestKeyLabel {
+				var labels []string
+				for _, item := range list {
+					labels = append(labels, manifestValueString(item))
+				}
+				fields[key] = strings.Join(labels, manifestLabelSeparator)
+				continue
+			}
+			fields[key] = manifestValueString(v)
+		}
+		row, err := newManifestRow(fields, line)
+		if err != nil {
+			return nil, err
+		}
+		if row.Path == "" {
+			return nil, &manifestError{Code: manifestErrPathMissing, Line: line, Field: manifestKeyPath}
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, &manifestError{Code: manifestErrLineTooLong, Line: line + 1}
+		}
+		return nil, &manifestError{Code: manifestErrUnreadable, Line: line + 1, Detail: err.Error()}
+	}
+	return rows, nil
+}
+
+// newManifestRow 由规范化后的列构造一行；CSV 中路径为空的行原样返回，由调用方跳过
+func newManifestRow(fields map[string]string, line int) (manifestRow, error) {
+	row := manifestRow{Metadata: make(map[string]string)}
+	for k, v := range fields {
+		v = strings.TrimSpace(v)
+		switch k {
+		case manifestKeyPath:
+			row.Path = v
+		case manifestKeyLabel:
+			for _, l := range strings.Split(v, manifestLabelSeparator) {
+				if l = strings.TrimSpace(l); l != "" {
+					row.Labels = appendUniqueStrings(row.Labels, l)
+				}
+			}
+		case manifestKeyCaptureTime:
+			if v == "" {
+				continue
+			}
+			t, ok := parseCaptureTime(v)
+			if !ok {
+				return row, &manifestError{Code: manifestErrInvalidCaptureTime, Line: line, Field: k, Detail: strconv.Quote(v)}
+			}
+			row.Metadata[k] = t
+		default:
+			if v != "" {
+				row.Metadata[k] = v
+			}
+		}
+	}
+	return row, nil
+}
+
+// normalizeManifestKey 列名转为小写下划线形式（cameraId、Camera ID -> camera_id），再套用别名
+func normalizeManifestKey(s string) string {
+	s = strings.TrimSpace(s)
+	var b strings.Builder
+	prevLower := false
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			if prevLower {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			prevLower = false
+		case r == ' ' || r == '-' || r == '.':
+			b.WriteByte('_')
+			prevLower = false
+		default:
+			b.WriteRune(r)
+			prevLower = unicode.IsLower(r) || unicode.IsDigit(r)
+		}
+	}
+	key := strings.Trim(b.String(), "_")
+	if alias, ok := manifestKeyAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// manifestValueString JSON 值转为字符串；整数不带小数点
+func manifestValueString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// parseCaptureTime 统一为 RFC3339（UTC），便于按时间范围筛选
+// 不带时区的时间按本地时间解释；支持 EXIF 的 2006:01:02 15:04:05 和 Unix 秒
+func parseCaptureTime(s string) (string, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC().Format(time.RFC3339), true
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006:01:02 15:04:05", "2006/01/02 15:04:05", "2006-01-02", "2006/01/02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.UTC().Format(time.RFC3339), true
+		}
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+		return time.Unix(n, 0).UTC().Format(time.RFC3339), true
+	}
+	return "", false
+}
+
+// appendUniqueStrings 追加尚不存在的字符串
+func appendUniqueStrings(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, s := range list {
+			if s == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// applyImportManifest 在导入事务内写入清单元数据，并把标签写成图片级的分类标注
+// imageIDs 为源文件路径 -> 图片 ID（包括按重复处理、指向已有图片的文件）
+func applyImportManifest(tx *sql.Tx, m *importManifest, imageIDs map[string]int64) error {
+	categoryIDs := make(map[string]int64)
+	changes := newAnnotationChangeBatch(annChangeSourceImport)
+	now := time.Now().UTC().Format(time.RFC3339)
+	for src, imageID := range imageIDs {
+		row, ok := m.rows[filepath.Clean(src)]
+		if !ok || imageID <= 0 {
+			continue
+		}
+		for k, v := range row.Metadata {
+			if _, err := tx.Exec(`INSERT INTO image_metadata (image_id, key, value) VALUES (?, ?, ?)
+ON CONFLICT(image_id, key) DO UPDATE SET value = excluded.value;`, imageID, k, v); err != nil {
+				return err
+			}
+		}
+		for _, label := range row.Labels {
+			categoryID, ok := categoryIDs[label]
+			if !ok {
+				id, err := ensureManifestCategory(tx, label)
+				if err != nil {
+					return err
+				}
+				categoryID = id
+				categoryIDs[label] = id
+			}
+			var exists int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM annotations WHERE image_id = ? AND category_id = ? AND type = 'category';`, imageID, categoryID).Scan(&exists); err != nil {
+				return err
+			}
+			if exists > 0 {
+				continue
+			}
+			if err := changes.capture(tx, imageID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO annotations (image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, 'category', '{}', ?, ?);`,
+				imageID, categoryID, now, now); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE image_index SET annotation_status = 'annotated' WHERE id = ?;`, imageID); err != nil {
+				return err
+			}
+		}
+	}
+	// 写入了标签的图片逐张记录变更，revision 随之加一
+	return changes.record(tx)
+}
+
+// ensureManifestCategory 查找同名的分类类别，没有则新建
+func ensureManifestCategory(tx *sql.Tx, name string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(`SELECT id FROM categories WHERE type = 'category' AND name = ? ORDER BY id ASC LIMIT 1;`, name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	var sortOrder int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(sort_order), 0) + 1 FROM categories WHERE type = 'category';`).Scan(&sortOrder); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`INSERT INTO categories (name, type, color, sort_order, mate) VALUES (?, 'category', ?, ?, '');`, name, manifestCategoryColor, sortOrder)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
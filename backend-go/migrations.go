@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// schemaMigration 项目数据库的一次前向迁移
+type schemaMigration struct {
+	Version     int
+	Description string
+	Apply       func(tx *sql.Tx) error
+}
+
+// errSchemaTooNew 数据库由更新版本的程序创建，当前程序无法安全读写
+var errSchemaTooNew = errors.New("project schema is newer than supported")
+
+// projectSchemaMu 串行化本进程内的迁移，避免多个请求同时升级同一个数据库
+var projectSchemaMu sync.Mutex
+
+// projectMigrations 按版本号升序排列，只能追加，不能修改已发布的迁移
+var projectMigrations = []schemaMigration{
+	{
+		Version:     1,
+		Description: "baseline tables",
+		Apply: func(tx *sql.Tx) error {
+			stmts := []string{
+				`CREATE TABLE IF NOT EXISTS image_index (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	filename TEXT NOT NULL,
+	original_rel_path TEXT NOT NULL,
+	thumb_rel_path TEXT NOT NULL,
+	deleted_in_project INTEGER NOT NULL DEFAULT 0,
+	annotation_status TEXT NOT NULL DEFAULT 'none',
+	created_at TEXT NOT NULL
+);`,
+				`CREATE TABLE IF NOT EXISTS image_ref_count (
+	image_id INTEGER PRIMARY KEY,
+	ref_count INTEGER NOT NULL DEFAULT 1,
+	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
+);`,
+				`CREATE TABLE IF NOT EXISTS annotations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	image_id INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	data TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
+);`,
+				`CREATE TABLE IF NOT EXISTS categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	color TEXT NOT NULL,
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	mate TEXT NOT NULL DEFAULT ''
+);`,
+			}
+			for _, stmt := range stmts {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			// 旧项目可能缺少后加的列
+			if err := addColumnIfMissing(tx, "image_index", "annotation_status", `TEXT NOT NULL DEFAULT 'none'`); err != nil {
+				return err
+			}
+			return addColumnIfMissing(tx, "categories", "mate", `TEXT NOT NULL DEFAULT ''`)
+		},
+	},
+	{
+		Version:     2,
+		Description: "allow duplicate category names across types",
+		Apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DROP INDEX IF EXISTS idx_categories_name;`)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "index annotations by image and category",
+		Apply: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_annotations_image_id ON annotations(image_id);`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_annotations_category_id ON annotations(category_id);`)
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "image dimensions, size, format and sha256",
+		Apply: func(tx *sql.Tx) error {
+			columns := [][2]string{
+				{"width", `INTEGER NOT NULL DEFAULT 0`},
+				{"height", `INTEGER NOT NULL DEFAULT 0`},
+				{"file_size", `INTEGER NOT NULL DEFAULT 0`},
+				{"format", `TEXT NOT NULL DEFAULT ''`},
+				{"sha256", `TEXT NOT NULL DEFAULT ''`},
+			}
+			for _, c := range columns {
+				if err := addColumnIfMissing(tx, "image_index", c[0], c[1]); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_image_index_sha256 ON image_index(sha256);`)
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "image perceptual hash",
+		Apply: func(tx *sql.Tx) error {
+			return addColumnIfMissing(tx, "image_index", "phash", `TEXT NOT NULL DEFAULT ''`)
+		},
+	},
+	{
+		Version:     6,
+		Description: "trash bin for deleted images",
+		Apply: func(tx *sql.Tx) error {
+			if err := addColumnIfMissing(tx, "image_index", "deleted_at", `TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+			// 删除图片时标注移到此表，恢复时原样搬回
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS trashed_annotations (
+	id INTEGER PRIMARY KEY,
+	image_id INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	data TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_trashed_annotations_image ON trashed_annotations(image_id);`)
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "image exif orientation",
+		Apply: func(tx *sql.Tx) error {
+			// 0 表示尚未读取，启动时的补全任务会填上
+			return addColumnIfMissing(tx, "image_index", "orientation", `INTEGER NOT NULL DEFAULT 0`)
+		},
+	},
+	{
+		Version:     8,
+		Description: "image bit depth",
+		Apply: func(tx *sql.Tx) error {
+			// 0 表示尚未读取，与 orientation 一起由启动时的补全任务填上
+			return addColumnIfMissing(tx, "image_index", "bit_depth", `INTEGER NOT NULL DEFAULT 0`)
+		},
+	},
+	{
+		Version:     9,
+		Description: "watch folders stored with the project",
+		Apply: func(tx *sql.Tx) error {
+			// 监视目录及已处理过的文件随项目保存，迁移数据目录或复制项目时一并带走
+			stmts := []string{
+				`CREATE TABLE IF NOT EXISTS watch_folders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	path TEXT NOT NULL UNIQUE,
+	import_mode TEXT NOT NULL DEFAULT 'copy',
+	duplicate_policy TEXT NOT NULL DEFAULT 'skip',
+	recursive INTEGER NOT NULL DEFAULT 1,
+	paused INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL
+);`,
+				`CREATE TABLE IF NOT EXISTS watch_files (
+	folder_id INTEGER NOT NULL,
+	path TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	mod_time INTEGER NOT NULL,
+	PRIMARY KEY(folder_id, path)
+);`,
+			}
+			for _, stmt := range stmts {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     10,
+		Description: "image metadata from import manifests",
+		Apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS image_metadata (
+	image_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY(image_id, key),
+	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_image_metadata_key_value ON image_metadata(key, value);`)
+			return err
+		},
+	},
+	{
+		Version:     11,
+		Description: "image tags",
+		Apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS image_tags (
+	image_id INTEGER NOT NULL,
+	tag TEXT NOT NULL COLLATE NOCASE,
+	created_at TEXT NOT NULL,
+	PRIMARY KEY(image_id, tag),
+	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_image_tags_tag ON image_tags(tag);`)
+			return err
+		},
+	},
+	{
+		Version:     12,
+		Description: "annotation change log",
+		Apply: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS annotation_changes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	image_id INTEGER NOT NULL,
+	actor TEXT NOT NULL,
+	source TEXT NOT NULL,
+	status_before TEXT NOT NULL DEFAULT '',
+	status_after TEXT NOT NULL DEFAULT '',
+	reverts INTEGER NOT NULL DEFAULT 0,
+	undone_by INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL,
+	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_annotation_changes_image ON annotation_changes(image_id, id);
+CREATE TABLE IF NOT EXISTS annotation_change_items (
+	change_id INTEGER NOT NULL,
+	annotation_id INTEGER NOT NULL,
+	before_json TEXT,
+	after_json TEXT,
+	PRIMARY KEY(change_id, annotation_id),
+	FOREIGN KEY(change_id) REFERENCES annotation_changes(id) ON DELETE CASCADE
+);`)
+			return err
+		},
+	},
+	{
+		Version:     13,
+		Description: "per-image annotation revision",
+		Apply: func(tx *sql.Tx) error {
+			// 每次标注写入加一，保存时据此发现并发修改
+			if err := addColumnIfMissing(tx, "image_index", "annotation_revision", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+				return err
+			}
+			// 变更完成后的 revision，旧记录为 0
+			return addColumnIfMissing(tx, "annotation_changes", "revision", `INTEGER NOT NULL DEFAULT 0`)
+		},
+	},
+}
+
+// latestSchemaVersion 当前程序支持的最新 schema 版本
+func latestSchemaVersion() int {
+	if len(projectMigrations) == 0 {
+		return 0
+	}
+	return projectMigrations[len(projectMigrations)-1].Version
+}
+
+// readSchemaVersion 读取数据库当前 schema 版本，没有 schema_version 表时返回 0
+func readSchemaVersion(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (int, error) {
+	var name string
+	err := q.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'schema_version';`).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var version int
+	if err := q.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version;`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// migrateProjectDB 将项目数据库（包括 db/versions/vN 下的快照）升级到最新版本
+// 已是最新版本时只做一次只读查询；版本高于程序支持时返回 errSchemaTooNew
+func migrateProjectDB(db *sql.DB, dbPath string) error {
+	current, err := readSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := latestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("%w: %s is v%d, supported v%d", errSchemaTooNew, dbPath, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	projectSchemaMu.Lock()
+	defer projectSchemaMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	applied_at TEXT NOT NULL
+);
+`); err != nil {
+		return err
+	}
+	// 拿到锁后重新读取，其他连接可能已经完成迁移
+	current, err = readSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, m := range projectMigrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := m.Apply(tx); err != nil {
+			return fmt.Errorf("migration v%d (%s): %w", m.Version, m.Description, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?);`, m.Version, m.Description, now); err != nil {
+			return err
+		}
+		log.Printf("[Schema] %s: applied v%d (%s)", dbPath, m.Version, m.Description)
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing 列不存在时追加列
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column, definition))
+	return err
+}
+
+// columnExists 检查表中是否存在指定列
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s);`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid int
+		var cname string
+		var ctype string
+		var notnull int
+		var dfltValue interface{}
+		var pk int
+		if err := rows.Scan(&cid, &cname, &ctype, &notnull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if cname == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 服务端监听在 :18080 且允许任意来源跨域，读取文件的接口只能访问数据目录和项目登记过的外部图片文件
+
+// externalFilesReloadInterval 路径不在缓存的外部文件中时，距上次加载超过该时间才重新读库
+// （刚导入的外部图片不必等缓存过期，又不会被无效请求频繁触发查询）
+const externalFilesReloadInterval = 2 * time.Second
+
+// projectExternalFiles 项目 image_index 中登记的外部文件（原图和缩略图），同时记录清理后的路径和解析符号链接后的路径
+type projectExternalFiles struct {
+	files    map[string]struct{}
+	loadedAt time.Time
+}
+
+var (
+	externalFilesMu    sync.Mutex
+	externalFilesCache = make(map[string]*projectExternalFiles)
+)
+
+// validPathSegment 用作单级目录名的 ID（项目、插件）不能包含分隔符，也不能是 . 或 ..
+func validPathSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\:`)
+}
+
+// isRealPathWithin 解析符号链接后判断 p 是否位于 root 之内，防止经由链接跳出；任一路径不存在时返回 false
+func isRealPathWithin(root, p string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	return isPathWithin(realRoot, realPath)
+}
+
+// resolveServedPath 把请求中的图片路径解析为允许读取的磁盘路径
+// 相对路径相对于项目目录且不能跳出；绝对路径必须位于数据目录下，或是项目登记过的外部图片文件本身
+func resolveServedPath(dataPath, projectID, p string) (string, bool) {
+	if !validPathSegment(projectID) {
+		return "", false
+	}
+	projectRoot := filepath.Join(dataPath, "project_item", projectID)
+	native := filepath.FromSlash(p)
+	if !filepath.IsAbs(native) {
+		fullPath := filepath.Join(projectRoot, native)
+		return fullPath, isRealPathWithin(projectRoot, fullPath)
+	}
+	fullPath := filepath.Clean(native)
+	if isRealPathWithin(dataPath, fullPath) {
+		return fullPath, true
+	}
+	if isRegisteredExternalFile(dataPath, projectID, fullPath, false) {
+		return fullPath, true
+	}
+	return fullPath, isRegisteredExternalFile(dataPath, projectID, fullPath, true)
+}
+
+// isRegisteredExternalFile 判断 p 是否是项目登记过的外部文件；reload 为 true 时按需重新读库
+// 同目录下的其他文件不可读
+func isRegisteredExternalFile(dataPath, projectID, p string, reload bool) bool {
+	externalFilesMu.Lock()
+	entry := externalFilesCache[projectID]
+	if reload && (entry == nil || time.Since(entry.loadedAt) >= externalFilesReloadInterval) {
+		files, err := loadProjectExternalFiles(dataPath, projectID)
+		if err != nil {
+			log.Printf("[PathGuard] load external files of project %s failed: %v", projectID, err)
+		}
+		entry = &projectExternalFiles{files: files, loadedAt: time.Now()}
+		externalFilesCache[projectID] = entry
+	}
+	var files map[string]struct{}
+	if entry != nil {
+		files = entry.files
+	}
+	externalFilesMu.Unlock()
+
+	return matchExternalFile(files, p)
+}
+
+// matchExternalFile p 清理后或解析符号链接后与登记的文件相同；文件不存在时返回 false
+func matchExternalFile(files map[string]struct{}, p string) bool {
+	if len(files) == 0 {
+		return false
+	}
+	realPath, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	if _, ok := files[filepath.Clean(p)]; ok {
+		return true
+	}
+	_, ok := files[realPath]
+	return ok
+}
+
+// loadProjectExternalFiles 汇总当前库中（含回收站中的图片）登记为绝对路径的原图和缩略图
+func loadProjectExternalFiles(dataPath, projectID string) (map[string]struct{}, error) {
+	dbPath := filepath.Join(dataPath, "project_item", projectID, "db", "project.db")
+	if !fileExists(dbPath) {
+		return nil, nil
+	}
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT original_rel_path, thumb_rel_path FROM image_index;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var original, thumb string
+		if err := rows.Scan(&original, &thumb); err != nil {
+			return nil, err
+		}
+		paths = append(paths, original, thumb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return externalFileSet(paths), nil
+}
+
+// externalFileSet 取出其中的绝对路径，清理后的路径和解析符号链接后的真实路径都加入集合
+func externalFileSet(paths []string) map[string]struct{} {
+	files := make(map[string]struct{})
+	for _, p := range paths {
+		native := filepath.FromSlash(p)
+		if p == "" || !filepath.IsAbs(native) {
+			continue
+		}
+		clean := filepath.Clean(native)
+		files[clean] = struct{}{}
+		if realPath, err := filepath.EvalSymlinks(clean); err == nil {
+			files[realPath] = struct{}{}
+		}
+	}
+	return files
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProjectExternalFiles(t *testing.T) {
+	dataPath := t.TempDir()
+	external := t.TempDir()
+	registered := filepath.Join(external, "a.jpg")
+	sibling := filepath.Join(external, "secret.txt")
+	for _, p := range []string{registered, sibling} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dbDir := filepath.Join(dataPath, "project_item", "p1", "db")
+	if err := os.MkdirAll(dbDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := acquireProjectDB(filepath.Join(dbDir, "project.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO image_index (filename, original_rel_path, thumb_rel_path, created_at) VALUES ('a.jpg', ?, 'images/thumbs/a.jpg', 'x'), ('b.jpg', 'images/b.jpg', '', 'x');`, registered); err != nil {
+		t.Fatal(err)
+	}
+	db.Release()
+
+	files, err := loadProjectExternalFiles(dataPath, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files[filepath.Clean(registered)]; !ok {
+		t.Fatalf("registered file missing from %v", files)
+	}
+	for p := range files {
+		if !filepath.IsAbs(p) {
+			t.Fatalf("relative path %q should not be in the external set", p)
+		}
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{"registered file", registered, true},
+		{"unclean path to registered file", filepath.Join(external, ".", "a.jpg"), true},
+		{"sibling file", sibling, false},
+		{"containing directory", external, false},
+		{"missing file", filepath.Join(external, "missing.jpg"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchExternalFile(files, tt.path); got != tt.want {
+				t.Errorf("matchExternalFile(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if files, err := loadProjectExternalFiles(dataPath, "missing"); err != nil || files != nil {
+		t.Errorf("project without db: got %v, %v", files, err)
+	}
+}
+
+func TestResolveServedPathRejectsSiblings(t *testing.T) {
+	dataPath := t.TempDir()
+	external := t.TempDir()
+	registered := filepath.Join(external, "a.jpg")
+	sibling := filepath.Join(external, "b.jpg")
+	for _, p := range []string{registered, sibling} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dbDir := filepath.Join(dataPath, "project_item", "p2", "db")
+	if err := os.MkdirAll(dbDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := acquireProjectDB(filepath.Join(dbDir, "project.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO image_index (filename, original_rel_path, thumb_rel_path, created_at) VALUES ('a.jpg', ?, '', 'x');`, registered); err != nil {
+		t.Fatal(err)
+	}
+	db.Release()
+
+	if _, ok := resolveServedPath(dataPath, "p2", registered); !ok {
+		t.Error("registered external file should be served")
+	}
+	if _, ok := resolveServedPath(dataPath, "p2", sibling); ok {
+		t.Error("sibling of a registered file must not be served")
+	}
+	if _, ok := resolveServedPath(dataPath, "p2", "../../../etc/passwd"); ok {
+		t.Error("relative path escaping the project must not be served")
+	}
+	if _, ok := resolveServedPath(dataPath, "..", registered); ok {
+		t.Error("invalid project id must be rejected")
+	}
+}
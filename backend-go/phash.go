@@ -0,0 +1,651 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math/bits"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// defaultDuplicateThreshold 默认汉明距离阈值（64 位 dHash）
+	defaultDuplicateThreshold = 5
+	// maxDuplicateThreshold 阈值上限，再大基本所有图片都会被归为一组
+	maxDuplicateThreshold = 20
+)
+
+// dHashImage 计算 64 位差值哈希：缩放为 9x8 灰度后比较相邻像素
+func dHashImage(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	// BiLinear 按缩放比例扩大采样范围，大图缩小时不会只取到零星像素
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// formatPHash 以 16 位十六进制存储
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parsePHash 解析 formatPHash 的结果
+func parsePHash(s string) (uint64, bool) {
+	if len(s) != 16 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	return v, err == nil
+}
+
+// computeImagePHash 解码图片文件并计算感知哈希（与导入时一致，基于显示用的 8 位图像）
+func computeImagePHash(path string) (string, error) {
+	img, err := decodeDisplayImage(path, displayWindowPercentile)
+	if err != nil {
+		return "", err
+	}
+	return formatPHash(dHashImage(img)), nil
+}
+
+// fillMissingPHashes 为当前库中未删除且缺少 phash 的图片计算哈希；progress 可为 nil，每算完一张调用一次
+func fillMissingPHashes(dbPath, projectRoot string, progress func(done, total int)) (int, error) {
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT id, original_rel_path FROM image_index WHERE deleted_in_project = 0 AND phash = '';`)
+	if err != nil {
+		return 0, err
+	}
+	type pendingImage struct {
+		ID   int64
+		Path string
+	}
+	var pending []pendingImage
+	for rows.Next() {
+		var p pendingImage
+		var rel string
+		if err := rows.Scan(&p.ID, &rel); err != nil {
+			continue
+		}
+		p.Path = resolveProjectImagePath(projectRoot, rel)
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	type hashed struct {
+		ID    int64
+		PHash string
+	}
+	jobsCh := make(chan pendingImage)
+	resultsCh := make(chan hashed, len(pending))
+	var wg sync.WaitGroup
+	var done int64
+	workerCount := runtime.NumCPU()
+	if workerCount < 2 {
+		workerCount = 2
+	}
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobsCh {
+				h, err := computeImagePHash(p.Path)
+				if n := atomic.AddInt64(&done, 1); progress != nil {
+					progress(int(n), len(pending))
+				}
+				if err != nil {
+					continue
+				}
+				resultsCh <- hashed{ID: p.ID, PHash: h}
+			}
+		}()
+	}
+	for _, p := range pending {
+		jobsCh <- p
+	}
+	close(jobsCh)
+	wg.Wait()
+	close(resultsCh)
+
+	updated := 0
+	for r := range resultsCh {
+		if _, err := db.Exec(`UPDATE image_index SET phash = ? WHERE id = ?;`, r.PHash, r.ID); err != nil {
+			log.Printf("[ImageMeta] %s: update phash for image %d failed: %v", dbPath, r.ID, err)
+			continue
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// duplicateImageItem 近似重复分组中的图片
+type duplicateImageItem struct {
+	ID           int64  `json:"id"`
+	Filename     string `json:"filename"`
+	ThumbPath    string `json:"thumbPath"`
+	OriginalPath string `json:"originalPath"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	FileSize     int64  `json:"fileSize"`
+	Distance     int    `json:"distance"` // 与保留图片的汉明距离
+
+	hash uint64
+}
+
+// duplicateImageGroup 一组近似重复图片，KeepID 为建议保留的图片
+type duplicateImageGroup struct {
+	KeepID int64                `json:"keepId"`
+	Images []duplicateImageItem `json:"images"`
+}
+
+// findDuplicateGroups 加载当前库已有 phash 的未删除图片并按汉明距离分组，pending 为尚未计算 phash 的图片数
+// 按建议保留的优先级（分辨率最高，其次最早导入）依次取未分组的图片作为组的保留图片，
+// 与它距离不超过阈值的其余未分组图片归入该组；只和保留图片比较，不会经由中间图片把不相似的图片串成一组
+func findDuplicateGroups(dbPath string, threshold int) ([]duplicateImageGroup, int, int, error) {
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT id, filename, original_rel_path, thumb_rel_path, width, height, file_size, phash FROM image_index WHERE deleted_in_project = 0 ORDER BY id ASC;`)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	items := make([]duplicateImageItem, 0, 256)
+	pending := 0
+	for rows.Next() {
+		var it duplicateImageItem
+		var phash string
+		if err := rows.Scan(&it.ID, &it.Filename, &it.OriginalPath, &it.ThumbPath, &it.Width, &it.Height, &it.FileSize, &phash); err != nil {
+			continue
+		}
+		if phash == "" {
+			pending++
+			continue
+		}
+		h, ok := parsePHash(phash)
+		if !ok {
+			continue
+		}
+		if it.ThumbPath == "" {
+			it.ThumbPath = it.OriginalPath
+		}
+		it.hash = h
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+	return groupDuplicateImages(items, threshold), len(items), pending, nil
+}
+
+// groupDuplicateImages 以保留图片为中心分组，只返回两张及以上的组
+func groupDuplicateImages(items []duplicateImageItem, threshold int) []duplicateImageGroup {
+	sort.SliceStable(items, func(a, b int) bool {
+		pa, pb := items[a].Width*items[a].Height, items[b].Width*items[b].Height
+		if pa != pb {
+			return pa > pb
+		}
+		return items[a].ID < items[b].ID
+	})
+	assigned := make([]bool, len(items))
+	groups := make([]duplicateImageGroup, 0)
+	for i := range items {
+		if assigned[i] {
+			continue
+		}
+		assigned[i] = true
+		keep := items[i]
+		members := []duplicateImageItem{keep}
+		for j := i + 1; j < len(items); j++ {
+			if assigned[j] {
+				continue
+			}
+			if d := bits.OnesCount64(items[j].hash ^ keep.hash); d <= threshold {
+				assigned[j] = true
+				m := items[j]
+				m.Distance = d
+				members = append(members, m)
+			}
+		}
+		if len(members) < 2 {
+			continue
+		}
+		sort.SliceStable(members[1:], func(a, b int) bool {
+			return members[1+a].Distance < members[1+b].Distance
+		})
+		groups = append(groups, duplicateImageGroup{KeepID: keep.ID, Images: members})
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a].KeepID < groups[b].KeepID })
+	return groups
+}
+
+// parseDuplicateThreshold 解析阈值，超出范围时截断
+func parseDuplicateThreshold(raw string) int {
+	threshold := defaultDuplicateThreshold
+	if v, err := strconv.Atoi(strings.TrimSpace(raw)); err == nil {
+		threshold = v
+	}
+	return clampDuplicateThreshold(threshold)
+}
+
+// clampDuplicateThreshold 把阈值截断到 [0, maxDuplicateThreshold]
+func clampDuplicateThreshold(threshold int) int {
+	if threshold < 0 {
+		threshold = 0
+	}
+	if threshold > maxDuplicateThreshold {
+		threshold = maxDuplicateThreshold
+	}
+	return threshold
+}
+
+// handleProjectImageDuplicates 按感知哈希列出近似重复的图片分组
+func handleProjectImageDuplicates(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSpace(r.URL.Query().Get("projectId"))
+	if projectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	threshold := parseDuplicateThreshold(r.URL.Query().Get("threshold"))
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	dbPath := filepath.Join(cfg.DataPath, "project_item", projectID, "db", "project.db")
+	groups, scanned, pending, err := findDuplicateGroups(dbPath, threshold)
+	if err != nil {
+		log.Printf("project images: find duplicates failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	// 缺少 phash 的图片交给后台任务计算，本次只用已有的哈希分组，任务完成后再次请求即可
+	taskID := ""
+	if pending > 0 {
+		if taskID, err = startPHashTask(cfg.DataPath, projectID); err != nil {
+			log.Printf("project images: start phash task failed: %v", err)
+		}
+	}
+
+	duplicateCount := 0
+	for _, g := range groups {
+		duplicateCount += len(g.Images) - 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"threshold":      threshold,
+		"scanned":        scanned,
+		"groups":         groups,
+		"groupCount":     len(groups),
+		"duplicateCount": duplicateCount,
+		"pending":        pending,
+		"taskId":         taskID,
+	})
+}
+
+// phashTasks 项目 ID -> 正在运行的 phash 补算任务，同一项目只运行一个
+var (
+	phashTasksMu sync.Mutex
+	phashTasks   = make(map[string]string)
+)
+
+// startPHashTask 启动项目的 phash 补算任务，已有任务在运行时返回它的 ID
+// 只写 phash 字段，与 check_links 一样不占用 IO 锁
+func startPHashTask(dataPath, projectID string) (string, error) {
+	phashTasksMu.Lock()
+	defer phashTasksMu.Unlock()
+	if taskID, ok := phashTasks[projectID]; ok {
+		return taskID, nil
+	}
+	taskID, err := generateProjectID()
+	if err != nil {
+		return "", err
+	}
+	// 任务结束前持有写入读锁；迁移期间不启动，之后再次请求时补算
+	if !projectWriteGate.TryRLock() {
+		return "", errDataRelocating
+	}
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: projectID,
+		TaskType:  taskTypeHashImages,
+		Phase:     importPhaseIndexing,
+	}
+	importTasksMu.Unlock()
+	phashTasks[projectID] = taskID
+	go runPHashTask(dataPath, projectID, taskID)
+	return taskID, nil
+}
+
+// runPHashTask 计算当前库中缺少的 phash，并更新任务进度
+func runPHashTask(dataPath, projectID, taskID string) {
+	defer endProjectWrite()
+	defer func() {
+		phashTasksMu.Lock()
+		delete(phashTasks, projectID)
+		phashTasksMu.Unlock()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ImageMeta] Task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_hash_task")
+		}
+	}()
+
+	projectRoot := filepath.Join(dataPath, "project_item", projectID)
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	n, err := fillMissingPHashes(dbPath, projectRoot, func(done, total int) {
+		updateImportTask(taskID, func(task *ImportTaskStatus) {
+			task.Total = total
+			task.Imported = done
+			task.Progress = done * 100 / total
+		})
+	})
+	if err != nil {
+		log.Printf("[ImageMeta] Task %s phash failed: %v", taskID, err)
+		failImportTask(taskID, "query_failed")
+		return
+	}
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+		task.Imported = task.Total
+	})
+	log.Printf("[ImageMeta] Task %s project %s: computed phash for %d images", taskID, projectID, n)
+}
+
+// duplicateResolveGroup 一组去重决定：保留 keep，删除 remove
+type duplicateResolveGroup struct {
+	Keep   int64   `json:"keep"`
+	Remove []int64 `json:"remove"`
+}
+
+// resolveDuplicateRemoval 按服务端当前分组确定要删除的图片，返回错误码时附带出错的图片
+// groups 模式下每张删除的图片都必须与其保留图片同组，且不能是任何一组的保留图片，因此每组至少留下一张；
+// keepIds 模式删除含保留图片的组中其余的图片，每个保留图片都必须属于某个组
+func resolveDuplicateRemoval(current []duplicateImageGroup, groups []duplicateResolveGroup, keepIDs []int64) ([]int64, string, []int64) {
+	groupOf := make(map[int64]int)
+	for i, g := range current {
+		for _, img := range g.Images {
+			groupOf[img.ID] = i
+		}
+	}
+	keep := make(map[int64]bool)
+	remove := make([]int64, 0)
+
+	if len(groups) > 0 {
+		for _, g := range groups {
+			keep[g.Keep] = true
+		}
+		seen := make(map[int64]bool)
+		var kept, outside []int64
+		for _, g := range groups {
+			keepGroup, keepOK := groupOf[g.Keep]
+			for _, id := range g.Remove {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				if keep[id] {
+					kept = append(kept, id)
+					continue
+				}
+				if i, ok := groupOf[id]; !keepOK || !ok || i != keepGroup {
+					outside = append(outside, id)
+					continue
+				}
+				remove = append(remove, id)
+			}
+		}
+		if len(kept) > 0 {
+			return nil, "keep_image_removed", kept
+		}
+		if len(outside) > 0 {
+			return nil, "not_in_keep_group", outside
+		}
+		return remove, "", nil
+	}
+
+	keepGroups := make(map[int]bool)
+	var outside []int64
+	for _, id := range keepIDs {
+		i, ok := groupOf[id]
+		if !ok {
+			outside = append(outside, id)
+			continue
+		}
+		keep[id] = true
+		keepGroups[i] = true
+	}
+	if len(outside) > 0 {
+		return nil, "not_in_duplicate_group", outside
+	}
+	for i, g := range current {
+		if !keepGroups[i] {
+			continue
+		}
+		for _, img := range g.Images {
+			if !keep[img.ID] {
+				remove = append(remove, img.ID)
+			}
+		}
+	}
+	return remove, "", nil
+}
+
+// handleResolveImageDuplicates 按用户的去重决定通过删除任务把重复图片标记为 deleted_in_project
+// 请求体 {projectId, threshold, groups:[{keep, remove}]} 或 {projectId, threshold, keepIds}；
+// 服务端用同一阈值重新分组并校验删除的图片都与保留图片同组，分组已变化时返回 409，不存在或已删除的图片返回 404
+func handleResolveImageDuplicates(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProjectID string                  `json:"projectId"`
+		Threshold *int                    `json:"threshold"`
+		Groups    []duplicateResolveGroup `json:"groups"`
+		KeepIDs   []int64                 `json:"keepIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" || (len(req.Groups) == 0) == (len(req.KeepIDs) == 0) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_and_groups_required"}`))
+		return
+	}
+	threshold := defaultDuplicateThreshold
+	if req.Threshold != nil {
+		threshold = clampDuplicateThreshold(*req.Threshold)
+	}
+	ids := append([]int64(nil), req.KeepIDs...)
+	for _, g := range req.Groups {
+		ids = append(ids, g.Keep)
+		ids = append(ids, g.Remove...)
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	_, missing, err := activeImageIDs(dbPath, ids)
+	if err != nil {
+		log.Printf("project images: check duplicate ids failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	if len(missing) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "images_not_found",
+			"imageIds": missing,
+		})
+		return
+	}
+
+	current, _, _, err := findDuplicateGroups(dbPath, threshold)
+	if err != nil {
+		log.Printf("project images: find duplicates failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	removeIDs, code, bad := resolveDuplicateRemoval(current, req.Groups, req.KeepIDs)
+	if code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    code,
+			"imageIds": bad,
+		})
+		return
+	}
+	if len(removeIDs) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"taskId":   "",
+			"imageIds": removeIDs,
+		})
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: req.ProjectID,
+		TaskType:  taskTypeDeleteImages,
+		Phase:     importPhaseDeleting,
+		Progress:  0,
+		Imported:  0,
+		Total:     len(removeIDs),
+	}
+	importTasksMu.Unlock()
+
+	log.Printf("project images: removing %d duplicate images (task %s)", len(removeIDs), taskID)
+	go runDeleteImagesTask(cfg.DataPath, req.ProjectID, taskID, removeIDs)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"taskId":   taskID,
+		"imageIds": removeIDs,
+	})
+}
+
+// activeImageIDs 去重后按未删除和不存在拆分图片 ID，保持请求中的顺序
+func activeImageIDs(dbPath string, ids []int64) ([]int64, []int64, error) {
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Release()
+	seen := make(map[int64]bool, len(ids))
+	active := make([]int64, 0, len(ids))
+	var missing []int64
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		var deleted int
+		err := db.QueryRow(`SELECT deleted_in_project FROM image_index WHERE id = ?;`, id).Scan(&deleted)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && deleted != 0) {
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		active = append(active, id)
+	}
+	return active, missing, nil
+}
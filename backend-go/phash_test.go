@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupDuplicateImages(t *testing.T) {
+	item := func(id int64, hash uint64, w, h int) duplicateImageItem {
+		return duplicateImageItem{ID: id, hash: hash, Width: w, Height: h}
+	}
+	tests := []struct {
+		name      string
+		items     []duplicateImageItem
+		threshold int
+		want      map[int64][]int64 // keepId -> 组内图片，保留图片在前
+	}{
+		{
+			name:      "no duplicates",
+			items:     []duplicateImageItem{item(1, 0x0, 10, 10), item(2, 0xff, 10, 10)},
+			threshold: 2,
+			want:      map[int64][]int64{},
+		},
+		{
+			name:      "keeps the largest image",
+			items:     []duplicateImageItem{item(1, 0x0, 10, 10), item(2, 0x1, 20, 20)},
+			threshold: 2,
+			want:      map[int64][]int64{2: {2, 1}},
+		},
+		{
+			name:      "ties keep the earliest image",
+			items:     []duplicateImageItem{item(2, 0x1, 10, 10), item(1, 0x0, 10, 10)},
+			threshold: 2,
+			want:      map[int64][]int64{1: {1, 2}},
+		},
+		{
+			// 1-2、2-3 的距离都是 2，1-3 为 4；2 不能把 1 和 3 串成一组
+			name:      "no transitive chaining",
+			items:     []duplicateImageItem{item(1, 0x0, 10, 10), item(2, 0x3, 10, 10), item(3, 0xf, 10, 10)},
+			threshold: 2,
+			want:      map[int64][]int64{1: {1, 2}},
+		},
+		{
+			name:      "members sorted by distance",
+			items:     []duplicateImageItem{item(1, 0x0, 30, 30), item(2, 0x3, 10, 10), item(3, 0x1, 10, 10)},
+			threshold: 2,
+			want:      map[int64][]int64{1: {1, 3, 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[int64][]int64{}
+			for _, g := range groupDuplicateImages(tt.items, tt.threshold) {
+				for _, img := range g.Images {
+					got[g.KeepID] = append(got[g.KeepID], img.ID)
+				}
+				if g.Images[0].ID != g.KeepID {
+					t.Errorf("group %d: keep image is not first", g.KeepID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDuplicateRemoval(t *testing.T) {
+	group := func(ids ...int64) duplicateImageGroup {
+		g := duplicateImageGroup{KeepID: ids[0]}
+		for _, id := range ids {
+			g.Images = append(g.Images, duplicateImageItem{ID: id})
+		}
+		return g
+	}
+	current := []duplicateImageGroup{group(1, 2, 3), group(4, 5)}
+	tests := []struct {
+		name     string
+		groups   []duplicateResolveGroup
+		keepIDs  []int64
+		want     []int64
+		wantCode string
+		wantBad  []int64
+	}{
+		{
+			name:   "remove within the keep group",
+			groups: []duplicateResolveGroup{{Keep: 2, Remove: []int64{1, 3}}, {Keep: 4, Remove: []int64{5}}},
+			want:   []int64{1, 3, 5},
+		},
+		{
+			name:   "partial removal",
+			groups: []duplicateResolveGroup{{Keep: 1, Remove: []int64{3, 3}}},
+			want:   []int64{3},
+		},
+		{
+			name:     "removed image from another group",
+			groups:   []duplicateResolveGroup{{Keep: 1, Remove: []int64{2, 5}}},
+			wantCode: "not_in_keep_group",
+			wantBad:  []int64{5},
+		},
+		{
+			name:     "keep image outside any group",
+			groups:   []duplicateResolveGroup{{Keep: 9, Remove: []int64{1}}},
+			wantCode: "not_in_keep_group",
+			wantBad:  []int64{1},
+		},
+		{
+			// 两组互相删除对方的保留图片会把整组删光
+			name:     "keep image removed by another group",
+			groups:   []duplicateResolveGroup{{Keep: 1, Remove: []int64{2}}, {Keep: 2, Remove: []int64{1, 3}}},
+			wantCode: "keep_image_removed",
+			wantBad:  []int64{2, 1},
+		},
+		{
+			name:    "keep ids remove the rest of their groups",
+			keepIDs: []int64{3},
+			want:    []int64{1, 2},
+		},
+		{
+			name:    "several keep ids in one group",
+			keepIDs: []int64{4, 5, 2},
+			want:    []int64{1, 3},
+		},
+		{
+			name:     "keep id outside any group",
+			keepIDs:  []int64{1, 9},
+			wantCode: "not_in_duplicate_group",
+			wantBad:  []int64{9},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, code, bad := resolveDuplicateRemoval(current, tt.groups, tt.keepIDs)
+			if code != tt.wantCode || !reflect.DeepEqual(bad, tt.wantBad) {
+				t.Fatalf("code = %q %v, want %q %v", code, bad, tt.wantCode, tt.wantBad)
+			}
+			if code == "" && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remove = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
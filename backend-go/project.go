@@ -14,41 +14,18 @@ import (
 	"time"
 )
 
-// ProjectMeta 项目元数据
+// ProjectMeta 项目元数据，保存在数据根目录的 catalog.db 中
 type ProjectMeta struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"createdAt"`
-}
-
-// loadProjects 加载项目列表
-func loadProjects(dataPath string) ([]ProjectMeta, error) {
-	projectsPath := filepath.Join(dataPath, "projects.json")
-	data, err := os.ReadFile(projectsPath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return []ProjectMeta{}, nil
-		}
-		return nil, err
-	}
-	if len(data) == 0 {
-		return []ProjectMeta{}, nil
-	}
-	var projects []ProjectMeta
-	if err := json.Unmarshal(data, &projects); err != nil {
-		return nil, err
-	}
-	return projects, nil
-}
-
-// saveProjects 保存项目列表
-func saveProjects(dataPath string, projects []ProjectMeta) error {
-	projectsPath := filepath.Join(dataPath, "projects.json")
-	encoded, err := json.MarshalIndent(projects, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(projectsPath, encoded, 0o644)
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	CreatedAt       string   `json:"createdAt"`
+	Description     string   `json:"description"`
+	TaskType        string   `json:"taskType"`
+	Tags            []string `json:"tags"`
+	Archived        bool     `json:"archived"`
+	LastOpenedAt    string   `json:"lastOpenedAt,omitempty"`
+	ImageCount      int64    `json:"imageCount"`      // 缓存值，数量变化后刷新
+	AnnotationCount int64    `json:"annotationCount"` // 缓存值，数量变化后刷新
 }
 
 // initProjectStorage 初始化项目存储
@@ -73,6 +50,7 @@ func initProjectStorage(dataPath string, project ProjectMeta) (int, error) {
 		return 0, err
 	}
 
+	// 表结构由 openProjectDB 中的 schema 迁移创建
 	dbPath := filepath.Join(dbDir, "project.db")
 	db, err := openProjectDB(dbPath)
 	if err != nil {
@@ -90,70 +68,6 @@ func initProjectStorage(dataPath string, project ProjectMeta) (int, error) {
 		return 0, err
 	}
 
-	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS image_index (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	filename TEXT NOT NULL,
-	original_rel_path TEXT NOT NULL,
-	thumb_rel_path TEXT NOT NULL,
-	deleted_in_project INTEGER NOT NULL DEFAULT 0,
-	annotation_status TEXT NOT NULL DEFAULT 'none',
-	created_at TEXT NOT NULL
-);
-`)
-	if err != nil {
-		return 0, err
-	}
-
-	// 图片引用计数表
-	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS image_ref_count (
-	image_id INTEGER PRIMARY KEY,
-	ref_count INTEGER NOT NULL DEFAULT 1,
-	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
-);
-`)
-	if err != nil {
-		return 0, err
-	}
-
-	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS annotations (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	image_id INTEGER NOT NULL,
-	category_id INTEGER NOT NULL,
-	type TEXT NOT NULL,
-	data TEXT NOT NULL,
-	created_at TEXT NOT NULL,
-	updated_at TEXT NOT NULL,
-	FOREIGN KEY(image_id) REFERENCES image_index(id) ON DELETE CASCADE
-);
-`)
-	if err != nil {
-		return 0, err
-	}
-
-	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS categories (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	name TEXT NOT NULL,
-	type TEXT NOT NULL,
-	color TEXT NOT NULL,
-	sort_order INTEGER NOT NULL DEFAULT 0,
-	mate TEXT NOT NULL DEFAULT ''
-);
-`)
-	if err != nil {
-		return 0, err
-	}
-
-	_, err = db.Exec(`
-CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_name ON categories(name);
-`)
-	if err != nil {
-		return 0, err
-	}
-
 	// 初始图片数量为 0
 	return 0, nil
 }
@@ -174,7 +88,7 @@ func isDriveRoot(p string) bool {
 func isImagePath(p string) bool {
 	ext := strings.ToLower(filepath.Ext(p))
 	switch ext {
-	case ".jpg", ".jpeg", ".png", ".bmp", ".gif", ".webp":
+	case ".jpg", ".jpeg", ".png", ".bmp", ".gif", ".webp", ".tif", ".tiff":
 		return true
 	default:
 		return false
@@ -332,6 +246,11 @@ func handleProjects(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
 	// DELETE 删除项目
 	if r.Method == http.MethodDelete {
 		projectID := r.URL.Query().Get("id")
@@ -342,57 +261,47 @@ func handleProjects(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		projects, err := loadProjects(cfg.DataPath)
-		if err != nil {
+		if _, err := getProject(cfg.DataPath, projectID); err != nil {
+			if errors.Is(err, errProjectNotFound) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"projects_unavailable"}`))
 			return
 		}
 
-		// 查找项目
-		var foundIndex = -1
-		for i, p := range projects {
-			if p.ID == projectID {
-				foundIndex = i
-				break
-			}
-		}
-		if foundIndex == -1 {
+		// 先移除记录再删目录，删除目录失败也不会留下指向残缺目录的项目
+		if err := deleteProjectRecord(cfg.DataPath, projectID); err != nil {
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
 			return
 		}
 
 		// 删除项目目录
 		projectDir := filepath.Join(cfg.DataPath, "project_item", projectID)
+		closeProjectDBsUnder(projectDir)
 		if err := os.RemoveAll(projectDir); err != nil {
 			log.Printf("[Project] Failed to remove project directory: %v", err)
 		}
 
-		// 从列表中移除
-		projects = append(projects[:foundIndex], projects[foundIndex+1:]...)
-		if err := saveProjects(cfg.DataPath, projects); err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
-			return
-		}
-
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(`{"success":true}`))
 		return
 	}
 
-	// PUT 重命名项目
+	// PUT 重命名项目或修改描述、任务类型、标签、归档状态（未提供的字段保持不变）
 	if r.Method == http.MethodPut {
-		type renameRequest struct {
-			ID   string `json:"id"`
-			Name string `json:"name"`
+		type updateRequest struct {
+			ID string `json:"id"`
+			projectMetaPatch
 		}
-		var body renameRequest
+		var body updateRequest
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
@@ -400,59 +309,37 @@ func handleProjects(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if body.ID == "" || strings.TrimSpace(body.Name) == "" {
+		if body.ID == "" || (body.Name != nil && strings.TrimSpace(*body.Name) == "") {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = w.Write([]byte(`{"error":"id_and_name_required"}`))
 			return
 		}
 
-		newName := strings.TrimSpace(body.Name)
-		if strings.ContainsAny(newName, "<>:\"/\\|?*") {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte(`{"error":"name_invalid"}`))
-			return
-		}
-
-		projects, err := loadProjects(cfg.DataPath)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"projects_unavailable"}`))
-			return
-		}
-
-		// 检查是否存在同名项目
-		for _, p := range projects {
-			if p.ID != body.ID && strings.EqualFold(p.Name, newName) {
+		if body.Name != nil {
+			newName := strings.TrimSpace(*body.Name)
+			if strings.ContainsAny(newName, "<>:\"/\\|?*") {
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				_, _ = w.Write([]byte(`{"error":"project_exists"}`))
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"name_invalid"}`))
 				return
 			}
+			body.Name = &newName
 		}
 
-		// 查找并更新项目
-		var found bool
-		for i, p := range projects {
-			if p.ID == body.ID {
-				projects[i].Name = newName
-				found = true
-				break
-			}
-		}
-		if !found {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
-			return
-		}
-
-		if err := saveProjects(cfg.DataPath, projects); err != nil {
+		if err := updateProject(cfg.DataPath, body.ID, body.projectMetaPatch); err != nil {
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
+			switch {
+			case errors.Is(err, errProjectNameExists):
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"error":"project_exists"}`))
+			case errors.Is(err, errProjectNotFound):
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
+			}
 			return
 		}
 
@@ -468,7 +355,10 @@ func handleProjects(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type createProjectRequest struct {
-		Name string `json:"name"`
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		TaskType    string   `json:"taskType"`
+		Tags        []string `json:"tags"`
 	}
 
 	var body createProjectRequest
@@ -493,53 +383,45 @@ func handleProjects(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projects, err := loadProjects(cfg.DataPath)
+	id, err := generateProjectID()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"projects_unavailable"}`))
+		_, _ = w.Write([]byte(`{"error":"id_generation_failed"}`))
 		return
 	}
 
-	for _, p := range projects {
-		if strings.EqualFold(p.Name, name) {
-			w.Header().Set("Content-Type", "application/json")
+	projectMeta := ProjectMeta{
+		ID:          id,
+		Name:        name,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		Description: strings.TrimSpace(body.Description),
+		TaskType:    strings.TrimSpace(body.TaskType),
+		Tags:        body.Tags,
+	}
+
+	// 先写记录占住名称（唯一索引保证并发创建不会重名），再初始化目录
+	if err := createProject(cfg.DataPath, projectMeta); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errProjectNameExists) {
 			w.WriteHeader(http.StatusConflict)
 			_, _ = w.Write([]byte(`{"error":"project_exists"}`))
 			return
 		}
-	}
-
-	id, err := generateProjectID()
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"id_generation_failed"}`))
+		_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
 		return
 	}
 
-	projectMeta := ProjectMeta{
-		ID:        id,
-		Name:      name,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-	}
-
 	imageCount, err := initProjectStorage(cfg.DataPath, projectMeta)
 	if err != nil {
+		_ = deleteProjectRecord(cfg.DataPath, projectMeta.ID)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(`{"error":"create_failed"}`))
 		return
 	}
 
-	projects = append(projects, projectMeta)
-	if err := saveProjects(cfg.DataPath, projects); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	type createProjectResponse struct {
@@ -553,3 +435,50 @@ func handleProjects(w http.ResponseWriter, r *http.Request) {
 		ImageCount: imageCount,
 	})
 }
+
+// handleProjectOpened 记录项目最近打开时间
+func handleProjectOpened(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.ID) == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"id_required"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	if err := touchProjectOpened(cfg.DataPath, strings.TrimSpace(body.ID)); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errProjectNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"projects_persist_failed"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 数据目录迁移阶段
+const (
+	relocatePhaseScanning  = "scanning"
+	relocatePhaseCopying   = "copying"
+	relocatePhaseVerifying = "verifying"
+	relocatePhaseRewriting = "rewriting"
+	relocatePhaseCompleted = "completed" // 配置已切换，旧目录等待确认后删除
+	relocatePhaseConfirmed = "confirmed" // 用户已确认，旧目录已处理
+	relocatePhaseFailed    = "failed"
+)
+
+// RelocationTask 数据目录迁移任务（同一时间只有一个）
+type RelocationTask struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Mode        string `json:"mode"` // copy：保留旧目录；move：确认后删除旧目录
+	Phase       string `json:"phase"`
+	Progress    int    `json:"progress"`
+	FilesTotal  int    `json:"filesTotal"`
+	FilesDone   int    `json:"filesDone"`
+	BytesTotal  int64  `json:"bytesTotal"`
+	BytesDone   int64  `json:"bytesDone"`
+	PathsFixed  int    `json:"pathsFixed"` // 改写的数据库路径数
+	Error       string `json:"error,omitempty"`
+	FailedFile  string `json:"failedFile,omitempty"`
+	StartedAt   string `json:"startedAt"`
+	CompletedAt string `json:"completedAt,omitempty"`
+}
+
+var (
+	relocationMu   sync.Mutex
+	relocationTask *RelocationTask
+)
+
+// projectWriteGate 不经 IO 锁的项目数据写入（标注、类别、标签、元数据、项目和版本的增删改、后台补算）持有读锁，
+// 迁移从快照到切换配置期间持有写锁；迁移开始时等待进行中的写入完成，迁移期间的写入直接返回 423，
+// 避免快照之后的修改在切换目录后丢失。经 IO 锁的任务与迁移本来就互斥
+var projectWriteGate sync.RWMutex
+
+// beginProjectWrite 迁移进行中时写入 423 并返回 false；返回 true 时调用方须 endProjectWrite
+func beginProjectWrite(w http.ResponseWriter) bool {
+	if projectWriteGate.TryRLock() {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+	_, _ = w.Write([]byte(`{"error":"data_relocating"}`))
+	return false
+}
+
+// errDataRelocating 后台写入在迁移期间放弃本次写入
+var errDataRelocating = errors.New("data directory relocation in progress")
+
+func endProjectWrite() {
+	projectWriteGate.RUnlock()
+}
+
+// relocationFile 待迁移的文件；数据库以快照方式复制，用 integrity_check 代替逐字节校验
+type relocationFile struct {
+	Rel    string
+	Size   int64
+	IsDB   bool
+	SHA256 string
+}
+
+// snapshotRelocation 返回任务状态副本
+func snapshotRelocation() *RelocationTask {
+	relocationMu.Lock()
+	defer relocationMu.Unlock()
+	if relocationTask == nil {
+		return nil
+	}
+	t := *relocationTask
+	return &t
+}
+
+// updateRelocation 修改任务状态并通过全局 WebSocket 推送
+func updateRelocation(fn func(t *RelocationTask)) {
+	relocationMu.Lock()
+	if relocationTask == nil {
+		relocationMu.Unlock()
+		return
+	}
+	fn(relocationTask)
+	t := *relocationTask
+	relocationMu.Unlock()
+
+	msgType := "data_relocation_progress"
+	switch t.Phase {
+	case relocatePhaseCompleted:
+		msgType = "data_relocation_done"
+	case relocatePhaseFailed:
+		msgType = "data_relocation_error"
+	}
+	broadcastGlobalWS(GlobalWSMessage{
+		Type:    msgType,
+		TaskID:  t.ID,
+		Message: t.Error,
+		Data:    t,
+		Success: t.Phase == relocatePhaseCompleted,
+	})
+}
+
+// handleRelocateDataPath 启动数据目录迁移
+func handleRelocateDataPath(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"task": snapshotRelocation()})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TargetPath string `json:"targetPath"`
+		Mode       string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.TargetPath = strings.TrimSpace(req.TargetPath)
+	req.Mode = strings.TrimSpace(strings.ToLower(req.Mode))
+	if req.Mode == "" {
+		req.Mode = "copy"
+	}
+	if req.Mode != "copy" && req.Mode != "move" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"mode_invalid"}`))
+		return
+	}
+	if req.TargetPath == "" || !filepath.IsAbs(req.TargetPath) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"target_invalid"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+	source := filepath.Clean(cfg.DataPath)
+	target := filepath.Clean(req.TargetPath)
+	if isPathWithin(source, target) || isPathWithin(target, source) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"target_overlaps_source"}`))
+		return
+	}
+	if entries, err := os.ReadDir(target); err == nil && len(entries) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"target_not_empty"}`))
+		return
+	}
+
+	relocationMu.Lock()
+	if relocationTask != nil && relocationTask.Phase == relocatePhaseCompleted {
+		relocationMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"relocation_awaiting_confirm","taskId":"` + relocationTask.ID + `"}`))
+		return
+	}
+	relocationMu.Unlock()
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	relocationMu.Lock()
+	relocationTask = &RelocationTask{
+		ID:        taskID,
+		Source:    source,
+		Target:    target,
+		Mode:      req.Mode,
+		Phase:     relocatePhaseScanning,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	relocationMu.Unlock()
+
+	go runRelocation(taskID, source, target)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"taskId": taskID})
+}
+
+// runRelocation 复制、校验、改写路径，全部成功后才切换配置
+// 任何一步失败都会删除目标目录中已复制的内容，配置保持不变
+func runRelocation(taskID, source, target string) {
+	defer releaseIOLock(taskID)
+	projectWriteGate.Lock()
+	defer projectWriteGate.Unlock()
+	fail := func(code, file string, err error) {
+		log.Printf("[Relocate] Task %s failed at %s (%s): %v", taskID, code, file, err)
+		_ = os.RemoveAll(target)
+		updateRelocation(func(t *RelocationTask) {
+			t.Phase = relocatePhaseFailed
+			t.Error = code
+			t.FailedFile = file
+		})
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Relocate] Task %s panic: %v", taskID, r)
+			fail("panic_in_relocation", "", nil)
+		}
+	}()
+
+	// 1. 扫描
+	var files []relocationFile
+	var totalBytes int64
+	err := filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name := d.Name()
+		// WAL/SHM 已包含在数据库快照里
+		if strings.HasSuffix(name, ".db-wal") || strings.HasSuffix(name, ".db-shm") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, relocationFile{Rel: rel, Size: info.Size(), IsDB: strings.HasSuffix(name, ".db")})
+		totalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		fail("scan_failed", "", err)
+		return
+	}
+	updateRelocation(func(t *RelocationTask) {
+		t.Phase = relocatePhaseCopying
+		t.FilesTotal = len(files)
+		t.BytesTotal = totalBytes
+	})
+
+	// 2. 复制（记录源文件摘要）
+	var bytesDone int64
+	lastReport := time.Now()
+	for i := range files {
+		f := &files[i]
+		src := filepath.Join(source, f.Rel)
+		dst := filepath.Join(target, f.Rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			fail("target_unavailable", f.Rel, err)
+			return
+		}
+		if f.IsDB {
+			err = snapshotSQLiteFile(src, dst)
+		} else {
+			f.SHA256, err = copyFileWithSHA256(src, dst)
+		}
+		if err != nil {
+			fail("copy_failed", f.Rel, err)
+			return
+		}
+		bytesDone += f.Size
+		if time.Since(lastReport) >= 500*time.Millisecond || i == len(files)-1 {
+			lastReport = time.Now()
+			done, bytes := i+1, bytesDone
+			updateRelocation(func(t *RelocationTask) {
+				t.FilesDone = done
+				t.BytesDone = bytes
+				if t.BytesTotal > 0 {
+					t.Progress = int(float64(bytes) * 80 / float64(t.BytesTotal))
+				}
+			})
+		}
+	}
+
+	// 3. 校验：文件数量与摘要一致，数据库通过完整性检查
+	updateRelocation(func(t *RelocationTask) {
+		t.Phase = relocatePhaseVerifying
+	})
+	copied := 0
+	_ = filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			copied++
+		}
+		return nil
+	})
+	if copied != len(files) {
+		fail("verify_count_mismatch", "", errors.New("file count differs"))
+		return
+	}
+	for i, f := range files {
+		dst := filepath.Join(target, f.Rel)
+		if f.IsDB {
+			if err := checkSQLiteIntegrity(dst); err != nil {
+				fail("verify_db_failed", f.Rel, err)
+				return
+			}
+		} else {
+			sum, err := fileSHA256(dst)
+			if err != nil || sum != f.SHA256 {
+				fail("verify_checksum_mismatch", f.Rel, err)
+				return
+			}
+		}
+		if i%200 == 0 {
+			progress := 80 + int(float64(i)*15/float64(len(files)))
+			updateRelocation(func(t *RelocationTask) {
+				t.Progress = progress
+			})
+		}
+	}
+
+	// 4. 改写项目数据库中指向旧目录的绝对路径
+	updateRelocation(func(t *RelocationTask) {
+		t.Phase = relocatePhaseRewriting
+		t.Progress = 95
+	})
+	fixed := 0
+	for _, f := range files {
+		if !f.IsDB || !strings.HasPrefix(filepath.ToSlash(f.Rel), "project_item/") {
+			continue
+		}
+		n, err := rewriteImagePathPrefix(filepath.Join(target, f.Rel), source, target)
+		if err != nil {
+			fail("rewrite_failed", f.Rel, err)
+			return
+		}
+		fixed += n
+	}
+
+	// 5. 切换配置
+	if err := savePathsConfig(&PathsConfig{DataPath: target}); err != nil {
+		fail("config_save_failed", "", err)
+		return
+	}
+
+	log.Printf("[Relocate] Task %s moved data root %s -> %s (%d files, %d paths rewritten)", taskID, source, target, len(files), fixed)
+	updateRelocation(func(t *RelocationTask) {
+		t.Phase = relocatePhaseCompleted
+		t.Progress = 100
+		t.PathsFixed = fixed
+		t.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+	})
+}
+
+// handleConfirmRelocation 确认迁移结果；move 模式此时才删除旧目录
+func handleConfirmRelocation(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TaskID string `json:"taskId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+
+	t := snapshotRelocation()
+	if t == nil || t.ID != req.TaskID {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"task_not_found"}`))
+		return
+	}
+	if t.Phase != relocatePhaseCompleted {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"relocation_not_completed"}`))
+		return
+	}
+	// 配置已被改回旧目录时不能删除
+	if cfg, err := loadPathsConfig(); err != nil || filepath.Clean(cfg.DataPath) != t.Target {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"config_changed"}`))
+		return
+	}
+
+	if t.Mode == "move" {
+		if !tryAcquireIOLock(t.ID) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+			return
+		}
+		closeProjectDBsUnder(t.Source)
+		err := os.RemoveAll(t.Source)
+		releaseIOLock(t.ID)
+		if err != nil {
+			log.Printf("[Relocate] Remove old data root %s failed: %v", t.Source, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"remove_old_root_failed"}`))
+			return
+		}
+		log.Printf("[Relocate] Removed old data root %s", t.Source)
+	}
+
+	updateRelocation(func(t *RelocationTask) {
+		t.Phase = relocatePhaseConfirmed
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"success":true}`))
+}
+
+// copyFileWithSHA256 复制文件并返回源内容的 SHA-256
+func copyFileWithSHA256(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, copyErr := io.Copy(io.MultiWriter(out, h), in)
+	closeErr := out.Close()
+	if copyErr != nil {
+		return "", copyErr
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	if info, err := in.Stat(); err == nil {
+		_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileSHA256 计算文件的 SHA-256
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshotSQLiteFile 用 VACUUM INTO 复制数据库，包含尚未合并的 WAL 内容
+func snapshotSQLiteFile(src, dst string) error {
+	db, err := openSQLiteRaw(src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_ = os.Remove(dst)
+	_, err = db.Exec(`VACUUM INTO ?;`, dst)
+	return err
+}
+
+// checkSQLiteIntegrity 数据库完整性检查
+func checkSQLiteIntegrity(path string) error {
+	db, err := openSQLiteRaw(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check;`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New(result)
+	}
+	return nil
+}
+
+// rewriteImagePathPrefix 将 image_index 中位于旧数据目录下的绝对路径改到新目录
+func rewriteImagePathPrefix(dbPath, oldRoot, newRoot string) (int, error) {
+	db, err := openSQLiteRaw(dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var hasTable int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'image_index';`).Scan(&hasTable); err != nil || hasTable == 0 {
+		return 0, err
+	}
+
+	rows, err := db.Query(`SELECT id, original_rel_path, thumb_rel_path FROM image_index;`)
+	if err != nil {
+		return 0, err
+	}
+	type change struct {
+		ID       int64
+		Original string
+		Thumb    string
+	}
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.ID, &c.Original, &c.Thumb); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		o, okO := rebasePath(c.Original, oldRoot, newRoot)
+		t, okT := rebasePath(c.Thumb, oldRoot, newRoot)
+		if okO || okT {
+			changes = append(changes, change{ID: c.ID, Original: o, Thumb: t})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, c := range changes {
+		if _, err := tx.Exec(`UPDATE image_index SET original_rel_path = ?, thumb_rel_path = ? WHERE id = ?;`, c.Original, c.Thumb, c.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(changes), tx.Commit()
+}
+
+// rebasePath 绝对路径位于 oldRoot 下时换成 newRoot，返回是否改动
+func rebasePath(p, oldRoot, newRoot string) (string, bool) {
+	native := filepath.FromSlash(p)
+	if p == "" || !filepath.IsAbs(native) || !isPathWithin(oldRoot, native) {
+		return p, false
+	}
+	rel, err := filepath.Rel(oldRoot, native)
+	if err != nil {
+		return p, false
+	}
+	return filepath.ToSlash(filepath.Join(newRoot, rel)), true
+}
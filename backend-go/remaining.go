@@ -9,6 +9,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	jpeg "image/jpeg"
@@ -398,7 +399,7 @@ func handlePluginReadme(w http.ResponseWriter, r *http.Request) {
 	pluginID := parts[2]
 
 	// 瀹夊叏妫€鏌?
-	if strings.ContainsAny(pluginID, "/\\") {
+	if !validPathSegment(pluginID) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -448,7 +449,7 @@ func handlePluginLogo(w http.ResponseWriter, r *http.Request) {
 	pluginID := parts[2]
 
 	// 瀹夊叏妫€鏌?
-	if strings.ContainsAny(pluginID, "/\\") {
+	if !validPathSegment(pluginID) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -513,20 +514,18 @@ func handlePluginUI(w http.ResponseWriter, r *http.Request) {
 	// 构建完整文件路径
 	fullPath := filepath.Join(pluginsDir, pluginID, "ui", filePath)
 
-	// 安全检查：确保路径在插件目录内
-	pluginsAbsDir, _ := filepath.Abs(pluginsDir)
-	fullAbsPath, _ := filepath.Abs(fullPath)
-	if !strings.HasPrefix(fullAbsPath, pluginsAbsDir) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	// 检查文件是否存在
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	// 安全检查：确保路径（解析符号链接后）在该插件的 ui 目录内
+	if !validPathSegment(pluginID) || !isRealPathWithin(filepath.Join(pluginsDir, pluginID, "ui"), fullPath) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	// 设置 Content-Type
 	ext := strings.ToLower(filepath.Ext(filePath))
 	contentTypes := map[string]string{
@@ -662,6 +661,13 @@ func handlePluginDownloadModel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 文件名只能是单级名称，不能写到插件目录之外
+	if !validPathSegment(req.Filename) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"filename_invalid"}`))
+		return
+	}
 	targetPath := filepath.Join(pluginDir, req.Filename)
 
 	// 启动后台下载
@@ -1410,6 +1416,7 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 		Filename    string
 		OriginalRel string
 		ThumbRel    string
+		Meta        imageFileMeta
 	}
 
 	// Check existing files
@@ -1497,44 +1504,50 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 							}
 						}
 
-						// Generate thumbnail if file > 3MB
-						if srcInfo.Size() > thumbSizeThresholdBytes {
+						// Generate thumbnail if file > 3MB or the browser cannot show it as is
+						if srcInfo.Size() > thumbSizeThresholdBytes || imageFileNeedsDisplayCopy(originalPath) {
 							ext := strings.ToLower(filepath.Ext(job.BaseName))
 							thumbBase := strings.TrimSuffix(job.BaseName, ext) + ".jpg"
 							thumbPath := filepath.Join(thumbsDir, thumbBase)
-							srcFile, err := os.Open(originalPath)
-							if err == nil {
-								srcImg, _, decErr := image.Decode(srcFile)
-								_ = srcFile.Close()
-								if decErr == nil {
-									b := srcImg.Bounds()
-									w, h := b.Dx(), b.Dy()
-									newW, newH := w, h
-									if w >= h && w > thumbMaxDimension {
-										newW = thumbMaxDimension
-										newH = int(float64(h) * float64(newW) / float64(w))
-									} else if h > w && h > thumbMaxDimension {
-										newH = thumbMaxDimension
-										newW = int(float64(w) * float64(newH) / float64(h))
-									}
-									thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
-									draw.ApproxBiLinear.Scale(thumbImg, thumbImg.Bounds(), srcImg, b, draw.Over, nil)
-									if out, err := os.Create(thumbPath); err == nil {
-										_ = jpeg.Encode(out, thumbImg, &jpeg.Options{Quality: 75})
-										_ = out.Close()
-										thumbRel = filepath.ToSlash(filepath.Join("images", "thumbs", thumbBase))
-									}
+							if srcImg, decErr := decodeDisplayImage(originalPath, displayWindowPercentile); decErr == nil {
+								b := srcImg.Bounds()
+								w, h := b.Dx(), b.Dy()
+								newW, newH := w, h
+								if w >= h && w > thumbMaxDimension {
+									newW = thumbMaxDimension
+									newH = int(float64(h) * float64(newW) / float64(w))
+								} else if h > w && h > thumbMaxDimension {
+									newH = thumbMaxDimension
+									newW = int(float64(w) * float64(newH) / float64(h))
+								}
+								thumbImg := image.NewRGBA(image.Rect(0, 0, newW, newH))
+								draw.ApproxBiLinear.Scale(thumbImg, thumbImg.Bounds(), srcImg, b, draw.Over, nil)
+								if out, err := os.Create(thumbPath); err == nil {
+									_ = jpeg.Encode(out, thumbImg, &jpeg.Options{Quality: 75})
+									_ = out.Close()
+									thumbRel = filepath.ToSlash(filepath.Join("images", "thumbs", thumbBase))
 								}
 							}
 						}
 					}
 				}
 
+				var meta imageFileMeta
+				if originalRel != "" {
+					physicalPath := resolveProjectImagePath(projectRoot, originalRel)
+					if m, err := probeImageFile(physicalPath); err == nil {
+						meta = m
+					} else {
+						log.Printf("[DatasetImport] Task %s probe image failed: %s: %v", taskID, physicalPath, err)
+					}
+				}
+
 				resultsCh <- importedImage{
 					Key:         job.Key,
 					Filename:    job.BaseName,
 					OriginalRel: originalRel,
 					ThumbRel:    thumbRel,
+					Meta:        meta,
 				}
 
 				// Update progress
@@ -1644,9 +1657,16 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 	}
 	for _, cat := range result.Categories {
 		var existingID int64
-		err := tx.QueryRow(`SELECT id FROM categories WHERE name = ?;`, cat.Name).Scan(&existingID)
+		var existingMate sql.NullString
+		err := tx.QueryRow(`SELECT id, mate FROM categories WHERE name = ?;`, cat.Name).Scan(&existingID, &existingMate)
 		if err == nil {
 			categoryKeyToID[cat.Key] = existingID
+			// 同名类别沿用已有的，插件推断出的属性定义并入其 mate
+			if merged, changed := mergeImportedAttributes(existingMate.String, cat.Meta); changed {
+				if _, err := tx.Exec(`UPDATE categories SET mate = ? WHERE id = ?;`, merged, existingID); err != nil {
+					log.Printf("[DatasetImport] Task %s merge attributes into category %d error: %v", taskID, existingID, err)
+				}
+			}
 			continue
 		}
 		mateJSON := "{}"
@@ -1690,10 +1710,16 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 			log.Printf("[DatasetImport] Task %s bbox category key %s not found in categoryKeyToID", taskID, cat.Key)
 			continue
 		}
-		// Update the bbox category's mate to include keypointCategoryId
-		newMate := map[string]interface{}{"keypointCategoryId": kpCatID}
-		mateJSON, _ := json.Marshal(newMate)
-		_, err := tx.Exec(`UPDATE categories SET mate = ? WHERE id = ?;`, string(mateJSON), bboxCatID)
+		// Update the bbox category's mate to include keypointCategoryId, keeping its other fields
+		var currentMate sql.NullString
+		err := tx.QueryRow(`SELECT mate FROM categories WHERE id = ?;`, bboxCatID).Scan(&currentMate)
+		var mateJSON string
+		if err == nil {
+			mateJSON, err = setCategoryMateField(currentMate.String, "keypointCategoryId", kpCatID)
+		}
+		if err == nil {
+			_, err = tx.Exec(`UPDATE categories SET mate = ? WHERE id = ?;`, mateJSON, bboxCatID)
+		}
 		if err != nil {
 			log.Printf("[DatasetImport] Task %s update bbox category mate error: %v", taskID, err)
 		} else {
@@ -1714,8 +1740,9 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 		if err == nil {
 			imageKeyToID[img.Key] = existingID
 		} else {
-			res, err := tx.Exec(`INSERT INTO image_index (filename, original_rel_path, thumb_rel_path, deleted_in_project, annotation_status, created_at) VALUES (?, ?, ?, 0, 'none', ?);`,
-				img.Filename, img.OriginalRel, img.ThumbRel, now)
+			res, err := tx.Exec(`INSERT INTO image_index (filename, original_rel_path, thumb_rel_path, deleted_in_project, annotation_status, created_at, width, height, file_size, format, sha256, orientation, bit_depth) VALUES (?, ?, ?, 0, 'none', ?, ?, ?, ?, ?, ?, ?, ?);`,
+				img.Filename, img.OriginalRel, img.ThumbRel, now,
+				img.Meta.Width, img.Meta.Height, img.Meta.FileSize, img.Meta.Format, img.Meta.SHA256, img.Meta.Orientation, img.Meta.BitDepth)
 			if err != nil {
 				log.Printf("[DatasetImport] Task %s insert image error: %v", taskID, err)
 				continue
@@ -1739,6 +1766,7 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 
 	// Import annotations
 	importedAnnotations := 0
+	changes := newAnnotationChangeBatch(annChangeSourceImport)
 	for _, ann := range result.Annotations {
 		imageID, ok := imageKeyToID[ann.ImageKey]
 		if !ok {
@@ -1758,6 +1786,11 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 			delete(annData, "keypointCategoryKey") // Remove the key, keep only the ID
 		}
 
+		// 按文件名匹配到的已有图片记下导入前的标注，写入变更日志
+		if err := changes.capture(tx, imageID); err != nil {
+			log.Printf("[DatasetImport] Task %s load annotations for image %d failed: %v", taskID, imageID, err)
+			continue
+		}
 		dataJSON, _ := json.Marshal(annData)
 		_, err := tx.Exec(`INSERT INTO annotations (image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?);`,
 			imageID, categoryID, ann.Type, string(dataJSON), now, now)
@@ -1785,6 +1818,17 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 	if err != nil {
 		log.Printf("[DatasetImport] Task %s update annotation status failed: %v", taskID, err)
 	}
+	// 写入了标注的图片逐张记录变更（revision 随之加一），已有图片上打开的编辑会收到 409
+	if err := changes.record(tx); err != nil {
+		log.Printf("[DatasetImport] Task %s record annotation changes failed: %v", taskID, err)
+		importTasksMu.Lock()
+		if task, ok := importTasks[taskID]; ok {
+			task.Phase = importPhaseFailed
+			task.Error = "record_changes_failed"
+		}
+		importTasksMu.Unlock()
+		return
+	}
 
 	log.Printf("[DatasetImport] Task %s committing transaction...", taskID)
 	if err := tx.Commit(); err != nil {
@@ -1798,6 +1842,8 @@ func runDatasetImportTask(dataPath, projectID, taskID, importMode, rootPath stri
 		return
 	}
 	tx = nil // Prevent defer from rolling back
+	refreshProjectCounts(dataPath, projectID)
+	go scheduleProjectTiles(projectRoot)
 
 	// Mark as completed
 	importTasksMu.Lock()
@@ -1939,6 +1985,9 @@ type DatasetVersionMeta struct {
 	LabeledImageCount int    `json:"labeledImageCount"` // 宸叉爣娉ㄧ殑鍥剧墖鏁?
 	CategoryCount     int    `json:"categoryCount"`
 	AnnotationCount   int    `json:"annotationCount"`
+	SchemaVersion     int    `json:"schemaVersion,omitempty"` // 快照数据库的 schema 版本
+	// Tags 快照中各标签的图片数；标签本身随数据库一起保存在快照里
+	Tags map[string]int `json:"tags,omitempty"`
 }
 
 // handleDatasetVersions 鑾峰彇椤圭洰鐨勬墍鏈夌増鏈垪琛?
@@ -2048,6 +2097,10 @@ func handleCreateDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 
 	var req struct {
 		ProjectID string `json:"projectId"`
@@ -2088,10 +2141,10 @@ func handleCreateDatasetVersion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 鈽?閲嶈锛氬湪澶嶅埗鍓嶆墽琛?checkpoint锛岀‘淇?WAL 涓殑鏁版嵁鍐欏叆涓绘暟鎹簱鏂囦欢
-	srcDb, err := openProjectDB(dbPath)
+	srcDb, err := acquireProjectDB(dbPath)
 	if err == nil {
 		srcDb.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
-		srcDb.Close()
+		srcDb.Release()
 	}
 
 	// Ensure versions directory exists
@@ -2172,6 +2225,7 @@ func handleCreateDatasetVersion(w http.ResponseWriter, r *http.Request) {
 	db.QueryRow("SELECT COUNT(DISTINCT image_key) FROM annotations").Scan(&labeledImageCount)
 	db.QueryRow("SELECT COUNT(*) FROM categories").Scan(&categoryCount)
 	db.QueryRow("SELECT COUNT(*) FROM annotations").Scan(&annotationCount)
+	schemaVersion, _ := readSchemaVersion(db)
 
 	// Create metadata file
 	now := time.Now().Format("2006-01-02 15:04:05")
@@ -2183,6 +2237,8 @@ func handleCreateDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		LabeledImageCount: labeledImageCount,
 		CategoryCount:     categoryCount,
 		AnnotationCount:   annotationCount,
+		SchemaVersion:     schemaVersion,
+		Tags:              snapshotTagCounts(db),
 	}
 	metaData, _ := json.MarshalIndent(meta, "", "  ")
 	metaPath := filepath.Join(versionDir, "version_meta.json")
@@ -2215,6 +2271,10 @@ func handleDeleteDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 
 	var req struct {
 		ProjectID string `json:"projectId"`
@@ -2254,6 +2314,7 @@ func handleDeleteDatasetVersion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete version directory
+	closeProjectDBsUnder(versionDir)
 	if err := os.RemoveAll(versionDir); err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -2278,6 +2339,10 @@ func handleUpdateDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
 
 	var req struct {
 		ProjectID string `json:"projectId"`
@@ -2399,18 +2464,23 @@ func handleRollbackDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 打开版本数据库和当前数据库
-	versionDb, err := openProjectDB(versionDbPath)
+	// 打开版本数据库和当前数据库（打开时两者都会迁移到同一 schema 版本）
+	versionDb, err := acquireProjectDB(versionDbPath)
 	if err != nil {
 		releaseIOLock("rollback_" + req.ProjectID)
+		log.Printf("[DatasetVersion] Open version db failed: %v", err)
+		code := "open_version_db_failed"
+		if errors.Is(err, errSchemaTooNew) {
+			code = "version_schema_unsupported"
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "open_version_db_failed"})
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
 		return
 	}
-	defer versionDb.Close()
+	defer versionDb.Release()
 
-	currentDb, err := openProjectDB(currentDbPath)
+	currentDb, err := acquireProjectDB(currentDbPath)
 	if err != nil {
 		releaseIOLock("rollback_" + req.ProjectID)
 		w.Header().Set("Content-Type", "application/json")
@@ -2418,7 +2488,7 @@ func handleRollbackDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "open_current_db_failed"})
 		return
 	}
-	defer currentDb.Close()
+	defer currentDb.Release()
 
 	// 开始事务
 	tx, err := currentDb.Begin()
@@ -2430,21 +2500,31 @@ func handleRollbackDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 回滚后的 revision 要大于回滚前发出过的任何 revision，旧窗口的保存才会被识别为过期
+	var maxRevision int64
+	tx.QueryRow(`SELECT COALESCE(MAX(annotation_revision), 0) FROM image_index`).Scan(&maxRevision)
+
 	// 清空当前数据库的索引表、类别表、标注表（不清空引用次数表）
 	tx.Exec(`DELETE FROM annotations`)
 	tx.Exec(`DELETE FROM categories`)
 	tx.Exec(`DELETE FROM image_index`)
+	tx.Exec(`DELETE FROM trashed_annotations`)
+	tx.Exec(`DELETE FROM image_metadata`)
+	tx.Exec(`DELETE FROM image_tags`)
+	tx.Exec(`DELETE FROM annotation_change_items`)
+	tx.Exec(`DELETE FROM annotation_changes`)
 
 	// 从版本数据库复制 image_index 表
-	rows, err := versionDb.Query(`SELECT id, filename, original_rel_path, thumb_rel_path, deleted_in_project, annotation_status, created_at FROM image_index`)
+	rows, err := versionDb.Query(`SELECT id, filename, original_rel_path, thumb_rel_path, deleted_in_project, annotation_status, created_at, width, height, file_size, format, sha256, phash, deleted_at, orientation, bit_depth FROM image_index`)
 	if err == nil {
 		for rows.Next() {
 			var id int64
-			var filename, originalRel, thumbRel, annotationStatus, createdAt string
+			var filename, originalRel, thumbRel, annotationStatus, createdAt, deletedAt string
 			var deletedInProject int
-			if rows.Scan(&id, &filename, &originalRel, &thumbRel, &deletedInProject, &annotationStatus, &createdAt) == nil {
-				tx.Exec(`INSERT INTO image_index (id, filename, original_rel_path, thumb_rel_path, deleted_in_project, annotation_status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-					id, filename, originalRel, thumbRel, deletedInProject, annotationStatus, createdAt)
+			var meta imageFileMeta
+			if rows.Scan(&id, &filename, &originalRel, &thumbRel, &deletedInProject, &annotationStatus, &createdAt, &meta.Width, &meta.Height, &meta.FileSize, &meta.Format, &meta.SHA256, &meta.PHash, &deletedAt, &meta.Orientation, &meta.BitDepth) == nil {
+				tx.Exec(`INSERT INTO image_index (id, filename, original_rel_path, thumb_rel_path, deleted_in_project, annotation_status, created_at, width, height, file_size, format, sha256, phash, deleted_at, orientation, bit_depth) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					id, filename, originalRel, thumbRel, deletedInProject, annotationStatus, createdAt, meta.Width, meta.Height, meta.FileSize, meta.Format, meta.SHA256, meta.PHash, deletedAt, meta.Orientation, meta.BitDepth)
 			}
 		}
 		rows.Close()
@@ -2479,6 +2559,73 @@ func handleRollbackDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		rows.Close()
 	}
 
+	// 从版本数据库复制回收站中的标注
+	rows, err = versionDb.Query(`SELECT id, image_id, category_id, type, data, created_at, updated_at FROM trashed_annotations`)
+	if err == nil {
+		for rows.Next() {
+			var id, imageID, categoryID int64
+			var annType, data, createdAt, updatedAt string
+			if rows.Scan(&id, &imageID, &categoryID, &annType, &data, &createdAt, &updatedAt) == nil {
+				tx.Exec(`INSERT INTO trashed_annotations (id, image_id, category_id, type, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+					id, imageID, categoryID, annType, data, createdAt, updatedAt)
+			}
+		}
+		rows.Close()
+	}
+
+	// 从版本数据库复制图片标签
+	rows, err = versionDb.Query(`SELECT image_id, tag, created_at FROM image_tags`)
+	if err == nil {
+		for rows.Next() {
+			var imageID int64
+			var tag, createdAt string
+			if rows.Scan(&imageID, &tag, &createdAt) == nil {
+				tx.Exec(`INSERT INTO image_tags (image_id, tag, created_at) VALUES (?, ?, ?)`, imageID, tag, createdAt)
+			}
+		}
+		rows.Close()
+	}
+
+	// 从版本数据库复制图片元数据
+	rows, err = versionDb.Query(`SELECT image_id, key, value FROM image_metadata`)
+	if err == nil {
+		for rows.Next() {
+			var imageID int64
+			var key, value string
+			if rows.Scan(&imageID, &key, &value) == nil {
+				tx.Exec(`INSERT INTO image_metadata (image_id, key, value) VALUES (?, ?, ?)`, imageID, key, value)
+			}
+		}
+		rows.Close()
+	}
+
+	tx.Exec(`UPDATE image_index SET annotation_revision = annotation_revision + ?`, maxRevision+1)
+
+	// 从版本数据库复制标注变更日志，回滚后的历史与标注保持一致
+	rows, err = versionDb.Query(`SELECT id, image_id, actor, source, status_before, status_after, reverts, undone_by, revision, created_at FROM annotation_changes`)
+	if err == nil {
+		for rows.Next() {
+			var c annotationChange
+			if rows.Scan(&c.ID, &c.ImageID, &c.Actor, &c.Source, &c.StatusBefore, &c.StatusAfter, &c.Reverts, &c.UndoneBy, &c.Revision, &c.CreatedAt) == nil {
+				tx.Exec(`INSERT INTO annotation_changes (id, image_id, actor, source, status_before, status_after, reverts, undone_by, revision, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					c.ID, c.ImageID, c.Actor, c.Source, c.StatusBefore, c.StatusAfter, c.Reverts, c.UndoneBy, c.Revision, c.CreatedAt)
+			}
+		}
+		rows.Close()
+	}
+	rows, err = versionDb.Query(`SELECT change_id, annotation_id, before_json, after_json FROM annotation_change_items`)
+	if err == nil {
+		for rows.Next() {
+			var changeID, annID int64
+			var beforeJSON, afterJSON sql.NullString
+			if rows.Scan(&changeID, &annID, &beforeJSON, &afterJSON) == nil {
+				tx.Exec(`INSERT INTO annotation_change_items (change_id, annotation_id, before_json, after_json) VALUES (?, ?, ?, ?)`,
+					changeID, annID, beforeJSON, afterJSON)
+			}
+		}
+		rows.Close()
+	}
+
 	if err := tx.Commit(); err != nil {
 		tx.Rollback()
 		releaseIOLock("rollback_" + req.ProjectID)
@@ -2488,7 +2635,13 @@ func handleRollbackDatasetVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 归还并关闭缓存的句柄：合并 WAL，丢弃回滚前的预编译语句
+	versionDb.Release()
+	currentDb.Release()
+	closeProjectDBsUnder(filepath.Join(projectRoot, "db"))
+
 	releaseIOLock("rollback_" + req.ProjectID)
+	refreshProjectCounts(cfg.DataPath, req.ProjectID)
 
 	log.Printf("[DatasetVersion] Rolled back project %s to version v%d (no backup created)",
 		req.ProjectID, req.Version)
@@ -2638,7 +2791,11 @@ type ExportDatasetRequest struct {
 	OutputPath string `json:"outputPath"`
 	Format     string `json:"format"`
 	PluginID   string `json:"pluginId"`
-	Split      struct {
+	// BakeOrientation 为 true 时带 EXIF 旋转方向的图片导出为转正后的像素（重新编码），否则原样复制
+	BakeOrientation bool `json:"bakeOrientation"`
+	// Filter 只导出符合条件的图片，语法同图片列表的查询参数，如 "tags=night&excludeTags=blurry"
+	Filter string `json:"filter"`
+	Split  struct {
 		Train int `json:"train"`
 		Val   int `json:"val"`
 		Test  int `json:"test"`
@@ -2669,6 +2826,12 @@ func handleDatasetExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("[Export] Request decoded: categories=%d, format=%s, plugin=%s", len(req.Categories), req.Format, req.PluginID)
+	if _, _, code := parseImageFilter(req.Filter); code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+		return
+	}
 
 	cfg, err := loadPathsConfig()
 	if err != nil {
@@ -2762,7 +2925,8 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 	var images []ExportImage
 	var categories []ExportCategory
 	var annotations []ExportAnnotation
-	imageSet := make(map[string]bool) // 鍘婚噸
+	imageSet := make(map[string]bool)    // 鍘婚噸
+	orientations := make(map[string]int) // 原图路径 -> EXIF 方向，复制时按需转正
 
 	// 閬嶅巻閫変腑鐨勭被鍒紝鍔犺浇鏁版嵁
 	for _, cat := range req.Categories {
@@ -2780,7 +2944,7 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 			continue
 		}
 
-		db, err := openProjectDB(versionDbPath)
+		db, err := acquireProjectDB(versionDbPath)
 		if err != nil {
 			log.Printf("[Export] ERROR: Failed to open database: %v", err)
 			continue
@@ -2792,7 +2956,7 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 		err = db.QueryRow("SELECT type, color, mate FROM categories WHERE id = ?", cat.CategoryID).Scan(&catType, &catColor, &catMate)
 		if err != nil {
 			log.Printf("[Export] WARNING: Category not found in db: %v", err)
-			db.Close()
+			db.Release()
 			continue
 		}
 
@@ -2806,15 +2970,10 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 		log.Printf("[Export] Found category: id=%d, type=%s, mate=%s", cat.CategoryID, catType, catMate.String)
 
 		// 鑾峰彇璇ョ被鍒殑鎵€鏈夋爣娉?
-		rows, err := db.Query(`
-			SELECT a.id, a.image_id, a.type, a.data, i.original_rel_path
-			FROM annotations a
-			JOIN image_index i ON a.image_id = i.id
-			WHERE a.category_id = ?
-		`, cat.CategoryID)
+		rows, err := queryCategoryAnnotations(db, cat.CategoryID, req.Filter)
 		if err != nil {
 			log.Printf("[Export] ERROR: Failed to query annotations: %v", err)
-			db.Close()
+			db.Release()
 			continue
 		}
 
@@ -2822,7 +2981,8 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 		for rows.Next() {
 			var annID, imageID int
 			var annType, dataJSON, relativePath string
-			if err := rows.Scan(&annID, &imageID, &annType, &dataJSON, &relativePath); err != nil {
+			var imgWidth, imgHeight, orientation int
+			if err := rows.Scan(&annID, &imageID, &annType, &dataJSON, &relativePath, &imgWidth, &imgHeight, &orientation); err != nil {
 				log.Printf("[Export] WARNING: Failed to scan row: %v", err)
 				continue
 			}
@@ -2834,13 +2994,18 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 				imageSet[imageKey] = true
 				absPath := filepath.Join(cfg.DataPath, "project_item", cat.ProjectID, relativePath)
 
-				// 璇诲彇鍥剧墖灏哄
-				imgWidth, imgHeight := 0, 0
-				if f, err := os.Open(absPath); err == nil {
-					if imgCfg, _, err := image.DecodeConfig(f); err == nil {
-						imgWidth, imgHeight = imgCfg.Width, imgCfg.Height
+				// 方向尚未补全的旧数据：记录的是原始像素尺寸，按方向转换
+				if orientation == exifOrientationUnknown {
+					orientation = readEXIFOrientation(absPath)
+					if orientationSwapsAxes(orientation) {
+						imgWidth, imgHeight = imgHeight, imgWidth
 					}
-					f.Close()
+				}
+				orientations[filepath.Clean(absPath)] = orientation
+
+				// 尺寸在导入时已记录，只有尚未补全的旧数据才读取文件头
+				if imgWidth <= 0 || imgHeight <= 0 {
+					imgWidth, imgHeight = readImageDimensions(absPath)
 				}
 
 				images = append(images, ExportImage{
@@ -2866,7 +3031,7 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 			}
 		}
 		rows.Close()
-		db.Close()
+		db.Release()
 
 		log.Printf("[Export] Loaded %d annotations for category %s", annCount, cat.CategoryName)
 	}
@@ -3003,7 +3168,13 @@ func runExportTask(taskID string, req ExportDatasetRequest, cfg *PathsConfig, pl
 	copiedCount := 0
 	for _, cp := range pluginResp.Structure.CopyImages {
 		destPath := filepath.Join(req.OutputPath, cp.To)
-		if err := copyFile(cp.From, destPath); err != nil {
+		copyFn := copyFile
+		if req.BakeOrientation {
+			// 写出转正后的像素，导出的标注坐标与不读 EXIF 的训练框架看到的图片一致
+			o := orientations[filepath.Clean(cp.From)]
+			copyFn = func(src, dst string) error { return copyImageOriented(src, dst, o) }
+		}
+		if err := copyFn(cp.From, destPath); err != nil {
 			log.Printf("[Export] Task %s WARNING: Failed to copy image %s -> %s: %v", taskID, cp.From, destPath, err)
 		} else {
 			copiedCount++
@@ -3045,12 +3216,26 @@ func handleOpenFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 妫€鏌ヨ矾寰勬槸鍚﹀瓨鍦?
-	if _, err := os.Stat(req.Path); os.IsNotExist(err) {
+	if info, err := os.Stat(req.Path); err != nil || !info.IsDir() {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "path_not_found"})
 		return
 	}
+	// 只允许打开数据目录内的文件夹；传给 explorer 的若是可执行文件会被直接运行
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "config_error"})
+		return
+	}
+	if !isRealPathWithin(cfg.DataPath, req.Path) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "path_forbidden"})
+		return
+	}
 
 	// Windows: 浣跨敤 explorer 鎵撳紑
 	cmd := exec.Command("explorer", req.Path)
@@ -3630,8 +3815,10 @@ type Trainset struct {
 	ID         string                 `json:"id"`
 	Name       string                 `json:"name"`
 	Categories []TrainsetCategoryItem `json:"categories"`
-	CreatedAt  string                 `json:"createdAt"`
-	UpdatedAt  string                 `json:"updatedAt"`
+	// Filter 只使用符合条件的图片，语法同图片列表的查询参数
+	Filter    string `json:"filter,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
 }
 
 type TrainsetCategoryItem struct {
@@ -3711,6 +3898,7 @@ func handleTrainsetSave(w http.ResponseWriter, r *http.Request) {
 		ID         string                 `json:"id"`
 		Name       string                 `json:"name"`
 		Categories []TrainsetCategoryItem `json:"categories"`
+		Filter     string                 `json:"filter"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.Categories) == 0 {
 		w.Header().Set("Content-Type", "application/json")
@@ -3718,6 +3906,13 @@ func handleTrainsetSave(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
 		return
 	}
+	req.Filter = strings.TrimSpace(req.Filter)
+	if _, _, code := parseImageFilter(req.Filter); code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+		return
+	}
 
 	dir := getTrainsetsDir()
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -3732,6 +3927,7 @@ func handleTrainsetSave(w http.ResponseWriter, r *http.Request) {
 		ID:         req.ID,
 		Name:       req.Name,
 		Categories: req.Categories,
+		Filter:     req.Filter,
 		UpdatedAt:  now,
 	}
 
@@ -5122,6 +5318,7 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 	var categories []ExportCategory
 	var annotations []ExportAnnotation
 	imageSet := make(map[string]bool)
+	orientations := make(map[string]int) // 原图路径 -> EXIF 方向，复制时转正
 
 	// 涓虹被鍒噸鏂板垎閰嶈繛缁殑ID锛圷OLO闇€瑕佷粠0寮€濮嬬殑杩炵画绱㈠紩锛?
 	catIDMap := make(map[int]int) // 鍘熷categoryId -> 鏂扮殑杩炵画绱㈠紩
@@ -5139,7 +5336,7 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 			continue
 		}
 
-		db, err := openProjectDB(versionDbPath)
+		db, err := acquireProjectDB(versionDbPath)
 		if err != nil {
 			log.Printf("[PrepareDataset] Failed to open db: %v", err)
 			continue
@@ -5165,15 +5362,10 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 		}
 
 		// 鑾峰彇璇ョ被鍒殑鎵€鏈夋爣娉?
-		rows, err := db.Query(`
-			SELECT a.id, a.image_id, a.type, a.data, i.original_rel_path
-			FROM annotations a
-			JOIN image_index i ON a.image_id = i.id
-			WHERE a.category_id = ?
-		`, cat.CategoryID)
+		rows, err := queryCategoryAnnotations(db, cat.CategoryID, trainset.Filter)
 		if err != nil {
 			log.Printf("[PrepareDataset] Query failed: %v", err)
-			db.Close()
+			db.Release()
 			continue
 		}
 
@@ -5181,7 +5373,8 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 		for rows.Next() {
 			var annID, imageID int
 			var annoType, dataJSON, relativePath string
-			if err := rows.Scan(&annID, &imageID, &annoType, &dataJSON, &relativePath); err != nil {
+			var imgWidth, imgHeight, orientation int
+			if err := rows.Scan(&annID, &imageID, &annoType, &dataJSON, &relativePath, &imgWidth, &imgHeight, &orientation); err != nil {
 				continue
 			}
 
@@ -5192,13 +5385,18 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 				imageSet[imageKey] = true
 				absPath := filepath.Join(cfg.DataPath, "project_item", cat.ProjectID, relativePath)
 
-				// 璇诲彇鍥剧墖灏哄
-				imgWidth, imgHeight := 0, 0
-				if f, err := os.Open(absPath); err == nil {
-					if imgCfg, _, err := image.DecodeConfig(f); err == nil {
-						imgWidth, imgHeight = imgCfg.Width, imgCfg.Height
+				// 方向尚未补全的旧数据：记录的是原始像素尺寸，按方向转换
+				if orientation == exifOrientationUnknown {
+					orientation = readEXIFOrientation(absPath)
+					if orientationSwapsAxes(orientation) {
+						imgWidth, imgHeight = imgHeight, imgWidth
 					}
-					f.Close()
+				}
+				orientations[filepath.Clean(absPath)] = orientation
+
+				// 尺寸在导入时已记录，只有尚未补全的旧数据才读取文件头
+				if imgWidth <= 0 || imgHeight <= 0 {
+					imgWidth, imgHeight = readImageDimensions(absPath)
 				}
 
 				images = append(images, ExportImage{
@@ -5244,7 +5442,7 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 			annCount++
 		}
 		rows.Close()
-		db.Close()
+		db.Release()
 		log.Printf("[PrepareDataset] Category %s: %d annotations", cat.CategoryName, annCount)
 	}
 
@@ -5358,7 +5556,10 @@ func prepareTrainingDataset(trainsetID, taskID string, trainRatio float64) (stri
 	// 澶嶅埗鍥剧墖
 	for _, cp := range pluginResp.Structure.CopyImages {
 		destPath := filepath.Join(outputDir, cp.To)
-		copyFile(cp.From, destPath)
+		// 训练数据集总是写出转正后的像素，标注坐标不依赖训练框架是否处理 EXIF
+		if err := copyImageOriented(cp.From, destPath, orientations[filepath.Clean(cp.From)]); err != nil {
+			log.Printf("[PrepareDataset] Copy image failed: %s -> %s: %v", cp.From, destPath, err)
+		}
 	}
 
 	log.Printf("[PrepareDataset] Dataset exported to: %s (train: %d, val: %d)",
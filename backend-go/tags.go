@@ -0,0 +1,470 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTagLength 单个标签的最大字符数
+const maxTagLength = 64
+
+// imageTagCount 标签及使用它的图片数
+type imageTagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// normalizeTag 去掉首尾空白；逗号用作筛选语法中的分隔符，不能出现在标签里
+func normalizeTag(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, ",") || utf8.RuneCountInString(s) > maxTagLength {
+		return "", false
+	}
+	return s, true
+}
+
+// parseTagList 解析逗号分隔的标签列表
+func parseTagList(s string) ([]string, bool) {
+	var tags []string
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		tag, ok := normalizeTag(part)
+		if !ok {
+			return nil, false
+		}
+		tags = append(tags, tag)
+	}
+	return tags, len(tags) > 0
+}
+
+// attachImageTags 为当前页的图片附上标签；失败只记录日志
+func attachImageTags(db *projectDBHandle, items []projectImageListItem) {
+	if len(items) == 0 {
+		return
+	}
+	index := make(map[int64]int, len(items))
+	args := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		index[item.ID] = i
+		args = append(args, item.ID)
+	}
+	rows, err := db.Query(fmt.Sprintf(`SELECT image_id, tag FROM image_tags WHERE image_id IN (%s) ORDER BY tag;`, placeholders(len(args))), args...)
+	if err != nil {
+		log.Printf("project images: tags query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var imageID int64
+		var tag string
+		if err := rows.Scan(&imageID, &tag); err != nil {
+			continue
+		}
+		item := &items[index[imageID]]
+		item.Tags = append(item.Tags, tag)
+	}
+}
+
+// imageSelection 批量操作的目标图片：直接给出 ID，或给出筛选条件（语法同图片列表）
+type imageSelection struct {
+	ProjectID string  `json:"projectId"`
+	ImageIDs  []int64 `json:"imageIds"`
+	Filter    string  `json:"filter"`
+}
+
+// resolveImageSelection 得到未删除的目标图片 ID；失败时返回错误码
+func resolveImageSelection(db *projectDBHandle, sel imageSelection) ([]int64, string) {
+	if len(sel.ImageIDs) > 0 {
+		args := make([]interface{}, len(sel.ImageIDs))
+		for i, id := range sel.ImageIDs {
+			args[i] = id
+		}
+		return queryImageIDs(db, fmt.Sprintf(`SELECT id FROM image_index WHERE deleted_in_project = 0 AND id IN (%s);`, placeholders(len(args))), args)
+	}
+	cond, args, code := parseImageFilter(sel.Filter)
+	if code != "" {
+		return nil, code
+	}
+	if cond == "" {
+		// 不允许不带任何条件地作用于整个项目
+		return nil, "images_required"
+	}
+	return queryImageIDs(db, `SELECT i.id FROM image_index i WHERE i.deleted_in_project = 0 AND `+cond+`;`, args)
+}
+
+func queryImageIDs(db *projectDBHandle, query string, args []interface{}) ([]int64, string) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("image selection: query failed: %v", err)
+		return nil, "query_failed"
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, ""
+}
+
+// handleProjectImageTags 列出项目中使用过的标签及图片数
+func handleProjectImageTags(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSpace(r.URL.Query().Get("projectId"))
+	if projectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	dbPath := filepath.Join(cfg.DataPath, "project_item", projectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT t.tag, COUNT(*) FROM image_tags t JOIN image_index i ON i.id = t.image_id
+WHERE i.deleted_in_project = 0 GROUP BY t.tag ORDER BY t.tag;`)
+	if err != nil {
+		log.Printf("image tags: query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	defer rows.Close()
+	tags := make([]imageTagCount, 0)
+	for rows.Next() {
+		var t imageTagCount
+		if err := rows.Scan(&t.Tag, &t.Count); err == nil {
+			tags = append(tags, t)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"tags": tags})
+}
+
+// handleAddImageTags 批量给图片加标签
+func handleAddImageTags(w http.ResponseWriter, r *http.Request) {
+	handleUpdateImageTags(w, r, true)
+}
+
+// handleRemoveImageTags 批量移除图片的标签
+func handleRemoveImageTags(w http.ResponseWriter, r *http.Request) {
+	handleUpdateImageTags(w, r, false)
+}
+
+// handleUpdateImageTags 请求体 {projectId, imageIds | filter, tags}
+func handleUpdateImageTags(w http.ResponseWriter, r *http.Request, add bool) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req struct {
+		imageSelection
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	var tags []string
+	for _, t := range req.Tags {
+		tag, ok := normalizeTag(t)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"tag_invalid"}`))
+			return
+		}
+		tags = appendUniqueStrings(tags, tag)
+	}
+	if len(tags) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"tags_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	ids, code := resolveImageSelection(db, req.imageSelection)
+	if code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		status := http.StatusBadRequest
+		if code == "query_failed" {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"error":"` + code + `"}`))
+		return
+	}
+
+	var changed int64
+	err = withProjectTx(db, func(tx *sql.Tx) error {
+		now := time.Now().UTC().Format(time.RFC3339)
+		query := `INSERT OR IGNORE INTO image_tags (image_id, tag, created_at) VALUES (?, ?, ?);`
+		if !add {
+			query = `DELETE FROM image_tags WHERE image_id = ? AND tag = ?;`
+		}
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, id := range ids {
+			for _, tag := range tags {
+				args := []interface{}{id, tag}
+				if add {
+					args = append(args, now)
+				}
+				res, err := stmt.Exec(args...)
+				if err != nil {
+					return err
+				}
+				n, _ := res.RowsAffected()
+				changed += n
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("image tags: update failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"update_failed"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "images": len(ids), "changed": changed})
+}
+
+// handleUpdateImageMetadata 批量设置或删除图片元数据
+// 请求体 {projectId, imageIds | filter, set: {key: value}, unset: [key]}；键名规范化规则与导入清单相同
+func handleUpdateImageMetadata(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req struct {
+		imageSelection
+		Set   map[string]string `json:"set"`
+		Unset []string          `json:"unset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	set := make(map[string]string, len(req.Set))
+	for k, v := range req.Set {
+		key := normalizeManifestKey(k)
+		v = strings.TrimSpace(v)
+		if key == "" || key == manifestKeyPath || key == manifestKeyLabel || v == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"metadata_invalid"}`))
+			return
+		}
+		if key == manifestKeyCaptureTime {
+			t, ok := parseCaptureTime(v)
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"date_invalid"}`))
+				return
+			}
+			v = t
+		}
+		set[key] = v
+	}
+	var unset []string
+	for _, k := range req.Unset {
+		if key := normalizeManifestKey(k); key != "" {
+			unset = append(unset, key)
+		}
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"metadata_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	dbPath := filepath.Join(cfg.DataPath, "project_item", req.ProjectID, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	ids, code := resolveImageSelection(db, req.imageSelection)
+	if code != "" {
+		w.Header().Set("Content-Type", "application/json")
+		status := http.StatusBadRequest
+		if code == "query_failed" {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"error":"` + code + `"}`))
+		return
+	}
+
+	err = withProjectTx(db, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			for k, v := range set {
+				if _, err := tx.Exec(`INSERT INTO image_metadata (image_id, key, value) VALUES (?, ?, ?)
+ON CONFLICT(image_id, key) DO UPDATE SET value = excluded.value;`, id, k, v); err != nil {
+					return err
+				}
+			}
+			for _, k := range unset {
+				if _, err := tx.Exec(`DELETE FROM image_metadata WHERE image_id = ? AND key = ?;`, id, k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("image metadata: update failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"update_failed"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "images": len(ids)})
+}
+
+// withProjectTx 在事务中执行 fn，出错时回滚
+func withProjectTx(db *projectDBHandle, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// snapshotTagCounts 统计快照中各标签的图片数，写入版本信息
+func snapshotTagCounts(db *sql.DB) map[string]int {
+	rows, err := db.Query(`SELECT t.tag, COUNT(*) FROM image_tags t JOIN image_index i ON i.id = t.image_id
+WHERE i.deleted_in_project = 0 GROUP BY t.tag;`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if rows.Scan(&tag, &n) == nil {
+			counts[tag] = n
+		}
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+	return counts
+}
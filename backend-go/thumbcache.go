@@ -0,0 +1,380 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// 按需生成的多尺寸缩略图，缓存在 <dataPath>/cache/thumbs/<projectId>/ 下，总大小按 LRU 限制
+const (
+	thumbCacheMaxBytes = 1 << 30 // 1GB
+	thumbCacheQuality  = 80
+)
+
+// thumbCacheSizes 支持的边长，请求的尺寸向上取到最近的一档以限制缓存变体数量
+var thumbCacheSizes = []int{64, 128, 256, 512, 1024}
+
+var (
+	// 生成缩略图需要完整解码原图，限制并发
+	thumbGenSem = make(chan struct{}, runtime.NumCPU())
+
+	thumbGenMu  sync.Mutex
+	thumbGenRun = map[string]*thumbGen{}
+
+	thumbLRU = &thumbCacheLRU{items: map[string]*list.Element{}, order: list.New()}
+)
+
+// thumbGen 进行中的生成，同一缓存文件的并发请求共享结果
+type thumbGen struct {
+	done chan struct{}
+	err  error
+}
+
+// thumbCacheEntry LRU 中的一个缓存文件
+type thumbCacheEntry struct {
+	path string
+	size int64
+}
+
+// thumbCacheLRU 缓存文件的最近使用顺序，首次使用时按文件修改时间从磁盘载入
+type thumbCacheLRU struct {
+	mu    sync.Mutex
+	dir   string
+	total int64
+	order *list.List // 前端为最近使用
+	items map[string]*list.Element
+}
+
+// load 载入（或在数据目录变化后重新载入）缓存目录
+func (c *thumbCacheLRU) load(dir string) {
+	if c.dir == dir {
+		return
+	}
+	c.dir = dir
+	c.total = 0
+	c.order.Init()
+	c.items = map[string]*list.Element{}
+
+	type found struct {
+		entry thumbCacheEntry
+		mod   time.Time
+	}
+	var files []found
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, found{thumbCacheEntry{p, info.Size()}, info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	for _, f := range files {
+		c.items[f.entry.path] = c.order.PushFront(f.entry)
+		c.total += f.entry.size
+	}
+}
+
+// touch 标记缓存文件刚被使用
+func (c *thumbCacheLRU) touch(dir, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load(dir)
+	if el, ok := c.items[path]; ok {
+		c.order.MoveToFront(el)
+	}
+}
+
+// add 记录新生成的文件，超出上限时删除最久未用的文件
+func (c *thumbCacheLRU) add(dir, path string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load(dir)
+	if el, ok := c.items[path]; ok {
+		c.total -= el.Value.(thumbCacheEntry).size
+		c.order.Remove(el)
+	}
+	c.items[path] = c.order.PushFront(thumbCacheEntry{path, size})
+	c.total += size
+
+	for c.total > thumbCacheMaxBytes && c.order.Len() > 1 {
+		el := c.order.Back()
+		e := el.Value.(thumbCacheEntry)
+		c.order.Remove(el)
+		delete(c.items, e.path)
+		c.total -= e.size
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[ThumbCache] evict %s failed: %v", e.path, err)
+		}
+	}
+}
+
+// snapThumbSize 把请求尺寸取到支持的档位
+func snapThumbSize(n int) int {
+	for _, s := range thumbCacheSizes {
+		if n <= s {
+			return s
+		}
+	}
+	return thumbCacheSizes[len(thumbCacheSizes)-1]
+}
+
+// thumbFingerprint 原图指纹：内容摘要、EXIF 方向加文件大小和修改时间，原图被替换后缓存自然失效
+func thumbFingerprint(sha string, orientation int, info os.FileInfo) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d", sha, orientation, info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(h[:8])
+}
+
+// handleProjectImageThumb 返回指定边长的缩略图（带 ETag）
+func handleProjectImageThumb(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSpace(r.URL.Query().Get("projectId"))
+	imageID, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("imageId")), 10, 64)
+	if projectID == "" || err != nil || imageID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_and_image_required"}`))
+		return
+	}
+	size := 256
+	if s := strings.TrimSpace(r.URL.Query().Get("size")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"size_invalid"}`))
+			return
+		}
+		size = n
+	}
+	size = snapThumbSize(size)
+	// 16 位/浮点图片映射到 8 位的窗口
+	window, ok := parseDisplayWindow(strings.TrimSpace(r.URL.Query().Get("window")))
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"window_invalid"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
+	db, err := acquireProjectDB(filepath.Join(projectRoot, "db", "project.db"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	var originalRel, thumbRel, sha string
+	var orientation int
+	err = db.QueryRow(`SELECT original_rel_path, thumb_rel_path, sha256, orientation FROM image_index WHERE id = ?;`, imageID).Scan(&originalRel, &thumbRel, &sha, &orientation)
+	db.Release()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"image_not_found"}`))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		}
+		return
+	}
+
+	srcPath := resolveProjectImagePath(projectRoot, filepath.FromSlash(originalRel))
+	info, err := os.Stat(srcPath)
+	if err != nil || info.IsDir() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"file_not_found"}`))
+		return
+	}
+	// 已有的小缩略图足够时直接用它作为源，避免解码大原图（导入时的缩略图按默认窗口映射）
+	if size <= thumbMaxDimension && window == displayWindowPercentile && thumbRel != "" && thumbRel != originalRel {
+		if p := resolveProjectImagePath(projectRoot, filepath.FromSlash(thumbRel)); fileExists(p) {
+			srcPath = p
+		}
+	}
+
+	fp := thumbFingerprint(sha, orientation, info)
+	etag := `"` + fp + "-" + strconv.Itoa(size) + displayWindowSuffix(window) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	cacheDir := filepath.Join(cfg.DataPath, "cache", "thumbs")
+	cachePath := filepath.Join(cacheDir, projectID, fmt.Sprintf("%d_%d%s_%s.jpg", imageID, size, displayWindowSuffix(window), fp))
+	if fileExists(cachePath) {
+		thumbLRU.touch(cacheDir, cachePath)
+	} else if err := generateCachedThumb(cacheDir, cachePath, srcPath, size, window); err != nil {
+		log.Printf("[ThumbCache] image %d size %d: %v", imageID, size, err)
+		w.Header().Del("ETag")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"thumb_failed"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, cachePath)
+}
+
+// generateCachedThumb 生成缩略图写入缓存；同一文件的并发请求只生成一次
+func generateCachedThumb(cacheDir, cachePath, srcPath string, size int, window string) error {
+	thumbGenMu.Lock()
+	if g, ok := thumbGenRun[cachePath]; ok {
+		thumbGenMu.Unlock()
+		<-g.done
+		return g.err
+	}
+	g := &thumbGen{done: make(chan struct{})}
+	thumbGenRun[cachePath] = g
+	thumbGenMu.Unlock()
+
+	thumbGenSem <- struct{}{}
+	g.err = writeThumbFile(cachePath, srcPath, size, window)
+	<-thumbGenSem
+	if g.err == nil {
+		if info, err := os.Stat(cachePath); err == nil {
+			thumbLRU.add(cacheDir, cachePath, info.Size())
+		}
+	}
+
+	thumbGenMu.Lock()
+	delete(thumbGenRun, cachePath)
+	thumbGenMu.Unlock()
+	close(g.done)
+	return g.err
+}
+
+// writeThumbFile 解码、高位深按窗口映射到 8 位、按 EXIF 方向转正、按长边缩放（不放大，size <= 0 时保持原尺寸）并写出 JPEG
+func writeThumbFile(cachePath, srcPath string, size int, window string) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	orientation := exifOrientation(head[:n])
+	src, _, err := decodeImageFile(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	src = toDisplayImage(src, window)
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientationSwapsAxes(orientation) {
+		w, h = h, w
+	}
+	if size > 0 && w >= h && w > size {
+		w, h = size, max(1, int(float64(h)*float64(size)/float64(w)))
+	} else if size > 0 && h > w && h > size {
+		w, h = max(1, int(float64(w)*float64(size)/float64(h))), size
+	}
+	// 先在原始方向上缩放，再转正缩小后的图
+	if orientationSwapsAxes(orientation) {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// JPEG 没有透明通道，先铺白底
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	} else {
+		draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	}
+	out := applyEXIFOrientation(dst, orientation)
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+	tmp := cachePath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	quality := thumbCacheQuality
+	if size <= 0 {
+		quality = orientedImageQuality
+	}
+	if err := jpeg.Encode(file, out, &jpeg.Options{Quality: quality}); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, cachePath)
+}
+
+// serveDisplayOriginal 浏览器不能直接正确显示的原图（见 needsDisplayCopy）返回转正并映射到 8 位的副本，
+// 与缩略图共用缓存目录和 LRU；原图文件不做任何修改
+func serveDisplayOriginal(w http.ResponseWriter, r *http.Request, dataPath, projectID string, imageID int64, sha string, orientation int, window, srcPath string, info os.FileInfo) error {
+	cacheDir := filepath.Join(dataPath, "cache", "thumbs")
+	cachePath := filepath.Join(cacheDir, projectID, fmt.Sprintf("%d_full%s_%s.jpg", imageID, displayWindowSuffix(window), thumbFingerprint(sha, orientation, info)))
+	if fileExists(cachePath) {
+		thumbLRU.touch(cacheDir, cachePath)
+	} else if err := generateCachedThumb(cacheDir, cachePath, srcPath, 0, window); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, cachePath)
+	return nil
+}
+
+// displayWindowSuffix 非默认窗口在缓存文件名和 ETag 中的后缀
+func displayWindowSuffix(window string) string {
+	if window == displayWindowPercentile {
+		return ""
+	}
+	return "_" + window
+}
+
+// fileExists 判断普通文件是否存在
+func fileExists(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// 深度缩放（DZI）瓦片金字塔
+// 缓存目录：<project>/images/tiles/<imageId>/{meta.json, <level>/<col>_<row>.jpg}
+const (
+	tileSize    = 256
+	tileOverlap = 1
+	tileQuality = 85
+	tileFormat  = "jpg"
+	// 长边超过该值的图片在导入后于后台生成瓦片
+	tilePyramidMinDimension = 4096
+	tileMetaFile            = "meta.json"
+	// 不能逐段解码的格式需要整体解码，像素数超过该值（约 23000x23000）的图片不生成
+	tileMaxDecodePixels = 1 << 29
+)
+
+// errTileSourceTooLarge 需要整体解码的原图像素数超过 tileMaxDecodePixels
+var errTileSourceTooLarge = errors.New("tile source image too large")
+
+// tilePyramidMeta 瓦片金字塔描述，同时记录原图指纹用于失效判断
+type tilePyramidMeta struct {
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	TileSize   int    `json:"tileSize"`
+	Overlap    int    `json:"overlap"`
+	Format     string `json:"format"`
+	MaxLevel   int    `json:"maxLevel"`
+	SourceSize int64  `json:"sourceSize"`
+	SourceMod  int64  `json:"sourceMod"` // 原图修改时间（UnixNano）
+}
+
+// tileBuild 进行中的金字塔生成，同一图片的并发请求等待同一次生成
+type tileBuild struct {
+	done chan struct{}
+	meta *tilePyramidMeta
+	err  error
+}
+
+var (
+	tileBuildsMu sync.Mutex
+	tileBuilds   = map[string]*tileBuild{}
+	// 解码大图非常占内存，同一时间只生成一个金字塔
+	tileBuildSem = make(chan struct{}, 1)
+
+	tileQueueOnce sync.Once
+	tileQueue     chan tileJob
+)
+
+// tileJob 后台生成任务
+type tileJob struct {
+	TileDir string
+	SrcPath string
+}
+
+// tileDirFor 图片的瓦片缓存目录
+func tileDirFor(projectRoot string, imageID int64) string {
+	return filepath.Join(projectRoot, "images", "tiles", strconv.FormatInt(imageID, 10))
+}
+
+// tileMaxLevel DZI 最高层级：长边缩放到 1 像素需要的层数
+func tileMaxLevel(w, h int) int {
+	m := w
+	if h > m {
+		m = h
+	}
+	if m <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(m))))
+}
+
+// tileLevelSize 层级对应的图片尺寸
+func tileLevelSize(meta *tilePyramidMeta, level int) (int, int) {
+	f := math.Pow(2, float64(meta.MaxLevel-level))
+	return int(math.Ceil(float64(meta.Width) / f)), int(math.Ceil(float64(meta.Height) / f))
+}
+
+// tileRect 瓦片在层级图像中的区域（含重叠像素）
+func tileRect(levelW, levelH, col, row int) image.Rectangle {
+	x0, y0 := col*tileSize, row*tileSize
+	x1, y1 := x0+tileSize+tileOverlap, y0+tileSize+tileOverlap
+	if col > 0 {
+		x0 -= tileOverlap
+	}
+	if row > 0 {
+		y0 -= tileOverlap
+	}
+	if x1 > levelW {
+		x1 = levelW
+	}
+	if y1 > levelH {
+		y1 = levelH
+	}
+	return image.Rect(x0, y0, x1, y1)
+}
+
+// readTileMeta 读取已生成的金字塔描述；原图已变化或不存在时返回 nil
+func readTileMeta(tileDir, srcPath string) *tilePyramidMeta {
+	data, err := os.ReadFile(filepath.Join(tileDir, tileMetaFile))
+	if err != nil {
+		return nil
+	}
+	var meta tilePyramidMeta
+	if json.Unmarshal(data, &meta) != nil {
+		return nil
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil || info.Size() != meta.SourceSize || info.ModTime().UnixNano() != meta.SourceMod {
+		return nil
+	}
+	return &meta
+}
+
+// ensureTilePyramid 返回可用的金字塔，缺失或原图已变化时（重新）生成
+func ensureTilePyramid(tileDir, srcPath string) (*tilePyramidMeta, error) {
+	if meta := readTileMeta(tileDir, srcPath); meta != nil {
+		return meta, nil
+	}
+
+	tileBuildsMu.Lock()
+	if b, ok := tileBuilds[tileDir]; ok {
+		tileBuildsMu.Unlock()
+		<-b.done
+		return b.meta, b.err
+	}
+	b := &tileBuild{done: make(chan struct{})}
+	tileBuilds[tileDir] = b
+	tileBuildsMu.Unlock()
+
+	tileBuildSem <- struct{}{}
+	// 等待期间其他调用可能已生成完毕
+	if meta := readTileMeta(tileDir, srcPath); meta != nil {
+		b.meta = meta
+	} else {
+		b.meta, b.err = buildTilePyramid(tileDir, srcPath)
+	}
+	<-tileBuildSem
+
+	tileBuildsMu.Lock()
+	delete(tileBuilds, tileDir)
+	tileBuildsMu.Unlock()
+	close(b.done)
+	return b.meta, b.err
+}
+
+// checkTileSourceSize 按文件头中的尺寸检查原图是否超过 tileMaxDecodePixels
+func checkTileSourceSize(srcPath string) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg, _, _, err := imageFileConfig(f)
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > tileMaxDecodePixels {
+		return errTileSourceTooLarge
+	}
+	return nil
+}
+
+// writeTileBuildError 原图过大返回 413，其余返回 500
+func writeTileBuildError(w http.ResponseWriter, imageID int64, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if errors.Is(err, errTileSourceTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte(`{"error":"image_too_large"}`))
+		return
+	}
+	log.Printf("[Tiles] image %d: build pyramid failed: %v", imageID, err)
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.Write([]byte(`{"error":"tile_build_failed"}`))
+}
+
+// buildTilePyramid 按行带读取原图，逐层减半并生成全部层级的瓦片
+func buildTilePyramid(tileDir, srcPath string) (*tilePyramidMeta, error) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	// 瓦片坐标与标注一致，映射到 8 位并按 EXIF 方向转正后再切
+	src, err := openTileBandSource(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+
+	// 旧瓦片先整体删除，避免新旧混杂
+	if err := os.RemoveAll(tileDir); err != nil {
+		return nil, err
+	}
+	w, h := src.size()
+	meta := &tilePyramidMeta{
+		Width:      w,
+		Height:     h,
+		TileSize:   tileSize,
+		Overlap:    tileOverlap,
+		Format:     tileFormat,
+		MaxLevel:   tileMaxLevel(w, h),
+		SourceSize: info.Size(),
+		SourceMod:  info.ModTime().UnixNano(),
+	}
+
+	// 最高层级接收原图的行，每层把减半后的行交给下一层
+	var top *tileLevelWriter
+	for level := 0; level <= meta.MaxLevel; level++ {
+		lw, lh := tileLevelSize(meta, level)
+		dir := filepath.Join(tileDir, strconv.Itoa(level))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		top = &tileLevelWriter{dir: dir, w: lw, h: lh, next: top}
+	}
+	for {
+		band, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := top.pushBand(band); err != nil {
+			return nil, err
+		}
+	}
+	for l := top; l != nil; l = l.next {
+		if l.received != l.h || l.tileRow != (l.h+tileSize-1)/tileSize {
+			return nil, fmt.Errorf("tile level %s incomplete: %d of %d rows", l.dir, l.received, l.h)
+		}
+	}
+
+	// meta.json 最后写入，存在即表示金字塔完整
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(tileDir, tileMetaFile), data, 0o644); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// tileLevelWriter 一个层级的瓦片输出：只保留尚未切完的行，凑齐一行瓦片即写出
+type tileLevelWriter struct {
+	dir      string
+	w, h     int
+	rows     [][]byte // RGBA 行，从 rowStart 开始
+	rowStart int
+	received int
+	tileRow  int    // 下一行待写出的瓦片
+	pending  []byte // 等待与下一行合并减半的偶数行
+	next     *tileLevelWriter
+}
+
+// pushBand 接收原图的一段行
+func (l *tileLevelWriter) pushBand(band image.Image) error {
+	b := band.Bounds()
+	if b.Dx() != l.w || b.Min.Y != l.received || b.Max.Y > l.h {
+		return fmt.Errorf("tile band %v does not continue %dx%d at row %d", b, l.w, l.h, l.received)
+	}
+	rgba, ok := band.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(b)
+		draw.Draw(rgba, b, band, b.Min, draw.Src)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		off := rgba.PixOffset(b.Min.X, y)
+		if err := l.push(rgba.Pix[off : off+l.w*4]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// push 接收本层的下一行
+func (l *tileLevelWriter) push(row []byte) error {
+	y := l.received
+	l.rows = append(l.rows, row)
+	l.received++
+
+	tileRows := (l.h + tileSize - 1) / tileSize
+	for l.tileRow < tileRows && l.received >= min(l.h, (l.tileRow+1)*tileSize+tileOverlap) {
+		if err := l.writeTileRow(l.tileRow); err != nil {
+			return err
+		}
+		l.tileRow++
+		// 下一行瓦片从 tileRow*tileSize-tileOverlap 开始，之前的行不再需要
+		if drop := min(l.tileRow*tileSize-tileOverlap-l.rowStart, len(l.rows)); drop > 0 {
+			l.rows = l.rows[drop:]
+			l.rowStart += drop
+		}
+	}
+
+	if l.next == nil {
+		return nil
+	}
+	switch {
+	case y%2 == 1:
+		half := halveRows(l.pending, row, l.w)
+		l.pending = nil
+		return l.next.push(half)
+	case y == l.h-1:
+		// 奇数高度的最后一行单独减半
+		return l.next.push(halveRows(row, row, l.w))
+	default:
+		l.pending = row
+		return nil
+	}
+}
+
+// writeTileRow 写出第 r 行的全部瓦片
+func (l *tileLevelWriter) writeTileRow(r int) error {
+	cols := (l.w + tileSize - 1) / tileSize
+	for col := 0; col < cols; col++ {
+		rect := tileRect(l.w, l.h, col, r)
+		tile := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			copy(tile.Pix[(y-rect.Min.Y)*tile.Stride:], l.rows[y-l.rowStart][rect.Min.X*4:rect.Max.X*4])
+		}
+		if err := writeTileJPEG(filepath.Join(l.dir, fmt.Sprintf("%d_%d.%s", col, r, tileFormat)), tile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// halveRows 两行 RGBA 按 2x2 取平均，宽度减半（奇数宽度的最后一列单独计算）
+func halveRows(a, b []byte, w int) []byte {
+	nw := (w + 1) / 2
+	out := make([]byte, nw*4)
+	for x := 0; x < nw; x++ {
+		x0, x1 := 2*x*4, min(2*x+1, w-1)*4
+		for c := 0; c < 4; c++ {
+			sum := int(a[x0+c]) + int(a[x1+c]) + int(b[x0+c]) + int(b[x1+c])
+			out[x*4+c] = uint8((sum + 2) / 4)
+		}
+	}
+	return out
+}
+
+// writeTileJPEG 写出单个瓦片
+func writeTileJPEG(path string, img image.Image) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, img, &jpeg.Options{Quality: tileQuality}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// enqueueTilePyramid 后台生成瓦片；队列满时丢弃，首次访问时再按需生成
+func enqueueTilePyramid(job tileJob) {
+	tileQueueOnce.Do(func() {
+		tileQueue = make(chan tileJob, 1024)
+		go func() {
+			for j := range tileQueue {
+				if _, err := ensureTilePyramid(j.TileDir, j.SrcPath); err != nil {
+					log.Printf("[Tiles] build %s failed: %v", j.TileDir, err)
+				}
+			}
+		}()
+	})
+	select {
+	case tileQueue <- job:
+	default:
+	}
+}
+
+// scheduleProjectTiles 为项目中尚无瓦片的大图排队生成
+func scheduleProjectTiles(projectRoot string) {
+	db, err := acquireProjectDB(filepath.Join(projectRoot, "db", "project.db"))
+	if err != nil {
+		return
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT id, original_rel_path FROM image_index WHERE deleted_in_project = 0 AND (width > ? OR height > ?);`, tilePyramidMinDimension, tilePyramidMinDimension)
+	if err != nil {
+		log.Printf("[Tiles] query large images failed: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var originalRel string
+		if rows.Scan(&id, &originalRel) != nil {
+			continue
+		}
+		tileDir := tileDirFor(projectRoot, id)
+		srcPath := resolveProjectImagePath(projectRoot, filepath.FromSlash(originalRel))
+		if readTileMeta(tileDir, srcPath) == nil {
+			enqueueTilePyramid(tileJob{TileDir: tileDir, SrcPath: srcPath})
+		}
+	}
+}
+
+// lookupTileSource 解析请求中的项目与图片，返回瓦片目录和原图路径；失败时已写出响应
+func lookupTileSource(w http.ResponseWriter, r *http.Request) (string, string, int64, bool) {
+	projectID := strings.TrimSpace(r.URL.Query().Get("projectId"))
+	imageID, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("imageId")), 10, 64)
+	if projectID == "" || err != nil || imageID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_and_image_required"}`))
+		return "", "", 0, false
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return "", "", 0, false
+	}
+	projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
+	db, err := acquireProjectDB(filepath.Join(projectRoot, "db", "project.db"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return "", "", 0, false
+	}
+	defer db.Release()
+
+	var originalRel string
+	if err := db.QueryRow(`SELECT original_rel_path FROM image_index WHERE id = ?;`, imageID).Scan(&originalRel); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"image_not_found"}`))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		}
+		return "", "", 0, false
+	}
+	return tileDirFor(projectRoot, imageID), resolveProjectImagePath(projectRoot, filepath.FromSlash(originalRel)), imageID, true
+}
+
+// handleProjectImageDZI 返回图片的 DZI 描述（必要时先生成瓦片）
+func handleProjectImageDZI(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	tileDir, srcPath, imageID, ok := lookupTileSource(w, r)
+	if !ok {
+		return
+	}
+	meta, err := ensureTilePyramid(tileDir, srcPath)
+	if err != nil {
+		writeTileBuildError(w, imageID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"width":    meta.Width,
+		"height":   meta.Height,
+		"tileSize": meta.TileSize,
+		"overlap":  meta.Overlap,
+		"format":   meta.Format,
+		"maxLevel": meta.MaxLevel,
+	})
+}
+
+// handleProjectImageTile 按 level/col/row 返回单个瓦片
+func handleProjectImageTile(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	level, errL := strconv.Atoi(q.Get("level"))
+	col, errC := strconv.Atoi(q.Get("col"))
+	row, errR := strconv.Atoi(q.Get("row"))
+	if errL != nil || errC != nil || errR != nil || level < 0 || col < 0 || row < 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"tile_invalid"}`))
+		return
+	}
+
+	tileDir, srcPath, imageID, ok := lookupTileSource(w, r)
+	if !ok {
+		return
+	}
+	meta, err := ensureTilePyramid(tileDir, srcPath)
+	if err != nil {
+		writeTileBuildError(w, imageID, err)
+		return
+	}
+
+	if level > meta.MaxLevel {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"tile_not_found"}`))
+		return
+	}
+	tilePath := filepath.Join(tileDir, strconv.Itoa(level), fmt.Sprintf("%d_%d.%s", col, row, meta.Format))
+	if _, err := os.Stat(tilePath); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"tile_not_found"}`))
+		return
+	}
+
+	// 原图变化后瓦片会重建，依靠 Last-Modified 协商缓存
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, tilePath)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// testTileImage 带渐变和透明度的测试图，宽高不是分段行数的整数倍
+func testTileImage(kind string, w, h int) image.Image {
+	r := image.Rect(0, 0, w, h)
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch kind {
+	case "gray":
+		img = image.NewGray(r)
+	case "gray16":
+		img = image.NewGray16(r)
+	case "rgb":
+		img = image.NewRGBA(r)
+	case "nrgba":
+		img = image.NewNRGBA(r)
+	case "rgb16":
+		img = image.NewRGBA64(r)
+	case "nrgba64":
+		img = image.NewNRGBA64(r)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint16(0xffff)
+			if kind == "nrgba" || kind == "nrgba64" {
+				a = uint16((x + y) * 0xffff / (w + h))
+			}
+			img.Set(x, y, color.NRGBA64{R: uint16(x * 997), G: uint16(y * 641), B: uint16((x ^ y) * 313), A: a})
+		}
+	}
+	return img
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// checkBandSource 逐段读出的像素与完整解码的结果一致，rewind 后可再读一遍
+func checkBandSource(t *testing.T, src tileBandSource, want image.Image) {
+	t.Helper()
+	defer src.close()
+	b := want.Bounds()
+	if w, h := src.size(); w != b.Dx() || h != b.Dy() {
+		t.Fatalf("size = %dx%d, want %dx%d", w, h, b.Dx(), b.Dy())
+	}
+	for pass := 0; pass < 2; pass++ {
+		y := 0
+		for {
+			band, err := src.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			bb := band.Bounds()
+			if bb.Min.Y != y || bb.Dx() != b.Dx() {
+				t.Fatalf("band %v does not continue at row %d", bb, y)
+			}
+			for ; y < bb.Max.Y; y++ {
+				for x := 0; x < b.Dx(); x++ {
+					gr, gg, gb, ga := band.At(x, y).RGBA()
+					wr, wg, wb, wa := want.At(x, y).RGBA()
+					if gr != wr || gg != wg || gb != wb || ga != wa {
+						t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, band.At(x, y), want.At(x, y))
+					}
+				}
+			}
+		}
+		if y != b.Dy() {
+			t.Fatalf("read %d rows, want %d", y, b.Dy())
+		}
+		if err := src.rewind(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPNGBandSource(t *testing.T) {
+	for _, kind := range []string{"gray", "gray16", "rgb", "nrgba", "rgb16", "nrgba64"} {
+		t.Run(kind, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, testTileImage(kind, 75, 150)); err != nil {
+				t.Fatal(err)
+			}
+			want, err := png.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			src, err := newPNGBandSource(writeTestFile(t, "a.png", buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBandSource(t, src, want)
+		})
+	}
+}
+
+func TestTIFFBandSource(t *testing.T) {
+	for _, kind := range []string{"gray", "gray16", "rgb", "rgb16"} {
+		for _, opts := range []tiff.Options{
+			{Compression: tiff.Uncompressed},
+			{Compression: tiff.Deflate, Predictor: true},
+		} {
+			t.Run(fmt.Sprintf("%s/%d", kind, opts.Compression), func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := tiff.Encode(&buf, testTileImage(kind, 75, 150), &opts); err != nil {
+					t.Fatal(err)
+				}
+				want, err := tiff.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				src, err := newTIFFBandSource(writeTestFile(t, "a.tif", buf.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkBandSource(t, src, want)
+			})
+		}
+	}
+}
+
+func TestBuildTilePyramid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testTileImage("rgb", 600, 301)); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "a.png")
+	if err := os.WriteFile(srcPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tileDir := filepath.Join(dir, "tiles")
+	meta, err := buildTilePyramid(tileDir, srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Width != 600 || meta.Height != 301 || meta.MaxLevel != 10 {
+		t.Fatalf("meta = %+v", meta)
+	}
+	if readTileMeta(tileDir, srcPath) == nil {
+		t.Fatal("meta.json not readable")
+	}
+
+	for level := 0; level <= meta.MaxLevel; level++ {
+		lw, lh := tileLevelSize(meta, level)
+		cols, rows := (lw+tileSize-1)/tileSize, (lh+tileSize-1)/tileSize
+		entries, err := os.ReadDir(filepath.Join(tileDir, strconv.Itoa(level)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != cols*rows {
+			t.Fatalf("level %d: %d tiles, want %d", level, len(entries), cols*rows)
+		}
+		for row := 0; row < rows; row++ {
+			for col := 0; col < cols; col++ {
+				f, err := os.Open(filepath.Join(tileDir, strconv.Itoa(level), fmt.Sprintf("%d_%d.jpg", col, row)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg, err := jpeg.DecodeConfig(f)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r := tileRect(lw, lh, col, row); cfg.Width != r.Dx() || cfg.Height != r.Dy() {
+					t.Errorf("level %d tile %d_%d = %dx%d, want %dx%d", level, col, row, cfg.Width, cfg.Height, r.Dx(), r.Dy())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// 生成瓦片金字塔时按行带读取原图，内存只与图片宽度成正比：
+// 非隔行 PNG 和常见的条带/分块 TIFF 直接逐段解码，其余格式整体解码后再按行带切分
+
+// tileBandRows 每段最多的行数（分块 TIFF 按分块高度）
+const tileBandRows = 64
+
+// errTileStreamUnsupported 格式或编码方式不能逐段解码，改为整体解码
+var errTileStreamUnsupported = errors.New("image cannot be decoded in bands")
+
+// tileBandSource 从上到下逐段提供图片像素
+type tileBandSource interface {
+	// size 图片宽高
+	size() (int, int)
+	// next 返回接下来的若干行，Bounds 为 (0, y0)-(w, y1)；全部读完后返回 io.EOF
+	next() (image.Image, error)
+	// rewind 回到第一行
+	rewind() error
+	close() error
+}
+
+// openTileBandSource 能逐段解码时直接读取文件，否则整体解码（受 tileMaxDecodePixels 限制）
+// 两种方式得到的都是映射到 8 位、按 EXIF 方向转正后的图像，与缩略图一致
+func openTileBandSource(srcPath string) (tileBandSource, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, exifScanLimit)
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+	// 需要旋转的图片不能按行输出
+	if exifOrientation(head) == exifOrientationNormal {
+		var src tileBandSource
+		switch {
+		case string(head[:min(len(head), len(pngHeader))]) == pngHeader:
+			src, err = newPNGBandSource(f)
+		case isTIFFHeader(head):
+			src, err = newTIFFBandSource(f)
+		default:
+			err = errTileStreamUnsupported
+		}
+		if err == nil {
+			return newDisplayBandSource(src, displayWindowPercentile)
+		}
+		if !errors.Is(err, errTileStreamUnsupported) {
+			f.Close()
+			return nil, err
+		}
+	}
+	f.Close()
+
+	if err := checkTileSourceSize(srcPath); err != nil {
+		return nil, err
+	}
+	img, err := decodeDisplayImage(srcPath, displayWindowPercentile)
+	if err != nil {
+		return nil, err
+	}
+	return &imageBandSource{img: img}, nil
+}
+
+// imageBandSource 已整体解码的图片
+type imageBandSource struct {
+	img image.Image
+	y   int
+}
+
+func (s *imageBandSource) size() (int, int) {
+	b := s.img.Bounds()
+	return b.Dx(), b.Dy()
+}
+
+func (s *imageBandSource) next() (image.Image, error) {
+	b := s.img.Bounds()
+	if s.y >= b.Dy() {
+		return nil, io.EOF
+	}
+	y1 := min(s.y+tileBandRows, b.Dy())
+	band := image.NewRGBA(image.Rect(0, s.y, b.Dx(), y1))
+	draw.Draw(band, band.Bounds(), s.img, image.Pt(b.Min.X, b.Min.Y+s.y), draw.Src)
+	s.y = y1
+	return band, nil
+}
+
+func (s *imageBandSource) rewind() error {
+	s.y = 0
+	return nil
+}
+
+func (s *imageBandSource) close() error {
+	return nil
+}
+
+// displayBandSource 把 16 位来源按整幅图片的窗口映射到 8 位：先读一遍统计直方图，再逐段映射
+type displayBandSource struct {
+	tileBandSource
+	lut []uint8
+}
+
+// newDisplayBandSource 8 位来源原样返回
+func newDisplayBandSource(src tileBandSource, window string) (tileBandSource, error) {
+	band, err := src.next()
+	if err != nil {
+		src.close()
+		return nil, err
+	}
+	bits := imageBitDepth(band.ColorModel())
+	if bits <= 8 {
+		if err := src.rewind(); err != nil {
+			src.close()
+			return nil, err
+		}
+		return src, nil
+	}
+	hist := make([]int, 1<<bits)
+	total := 0
+	for err == nil {
+		total += displayHistogram(band, hist)
+		band, err = src.next()
+	}
+	if err == io.EOF {
+		err = src.rewind()
+	}
+	if err != nil {
+		src.close()
+		return nil, err
+	}
+	return &displayBandSource{tileBandSource: src, lut: displayLUT(hist, total, window)}, nil
+}
+
+func (s *displayBandSource) next() (image.Image, error) {
+	band, err := s.tileBandSource.next()
+	if err != nil {
+		return nil, err
+	}
+	return mapDisplayImage(band, s.lut), nil
+}
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// pngBandSource 非隔行的 8/16 位灰度、真彩色 PNG（可带 alpha），调色板和低位深图片整体解码
+type pngBandSource struct {
+	f         *os.File
+	width     int
+	height    int
+	depth     int // 8 或 16
+	colorType int
+	bpp       int // 每像素字节数
+	zr        io.ReadCloser
+	prev      []byte // 上一行（已还原过滤），过滤计算需要
+	cur       []byte // 过滤类型字节 + 当前行
+	y         int
+}
+
+func newPNGBandSource(f *os.File) (*pngBandSource, error) {
+	s := &pngBandSource{f: f}
+	if err := s.rewind(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pngBandSource) size() (int, int) {
+	return s.width, s.height
+}
+
+// rewind 重新解析文件头并定位到第一个 IDAT
+func (s *pngBandSource) rewind() error {
+	if s.zr != nil {
+		s.zr.Close()
+		s.zr = nil
+	}
+	br := bufio.NewReader(io.NewSectionReader(s.f, 0, math.MaxInt64))
+	hdr := make([]byte, len(pngHeader)+8+13+4)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return err
+	}
+	if string(hdr[:8]) != pngHeader || string(hdr[12:16]) != "IHDR" || binary.BigEndian.Uint32(hdr[8:]) != 13 {
+		return errTileStreamUnsupported
+	}
+	ihdr := hdr[16:29]
+	s.width = int(binary.BigEndian.Uint32(ihdr[0:]))
+	s.height = int(binary.BigEndian.Uint32(ihdr[4:]))
+	s.depth = int(ihdr[8])
+	s.colorType = int(ihdr[9])
+	if s.width <= 0 || s.height <= 0 || ihdr[12] != 0 || (s.depth != 8 && s.depth != 16) {
+		return errTileStreamUnsupported
+	}
+	channels := 0
+	switch s.colorType {
+	case 0:
+		channels = 1
+	case 2:
+		channels = 3
+	case 4:
+		channels = 2
+	case 6:
+		channels = 4
+	default:
+		return errTileStreamUnsupported
+	}
+	s.bpp = channels * s.depth / 8
+
+	zr, err := zlib.NewReader(&pngIDATReader{r: br})
+	if err != nil {
+		return err
+	}
+	s.zr = zr
+	s.prev = make([]byte, s.width*s.bpp)
+	s.cur = make([]byte, 1+s.width*s.bpp)
+	s.y = 0
+	return nil
+}
+
+func (s *pngBandSource) next() (image.Image, error) {
+	if s.y >= s.height {
+		return nil, io.EOF
+	}
+	y1 := min(s.y+tileBandRows, s.height)
+	rect := image.Rect(0, s.y, s.width, y1)
+	// 与 image/png 解码得到的类型一致
+	var img image.Image
+	var pix []byte
+	var stride int
+	switch {
+	case s.colorType == 0 && s.depth == 8:
+		m := image.NewGray(rect)
+		img, pix, stride = m, m.Pix, m.Stride
+	case s.colorType == 0:
+		m := image.NewGray16(rect)
+		img, pix, stride = m, m.Pix, m.Stride
+	case s.colorType == 2 && s.depth == 8:
+		m := image.NewRGBA(rect)
+		img, pix, stride = m, m.Pix, m.Stride
+	case s.depth == 8:
+		m := image.NewNRGBA(rect)
+		img, pix, stride = m, m.Pix, m.Stride
+	default:
+		m := image.NewNRGBA64(rect)
+		img, pix, stride = m, m.Pix, m.Stride
+	}
+
+	size := s.depth / 8
+	for y := s.y; y < y1; y++ {
+		if _, err := io.ReadFull(s.zr, s.cur); err != nil {
+			return nil, fmt.Errorf("png: row %d: %w", y, err)
+		}
+		row := s.cur[1:]
+		if err := pngUnfilter(s.cur[0], row, s.prev, s.bpp); err != nil {
+			return nil, err
+		}
+		out := pix[(y-s.y)*stride:]
+		switch s.colorType {
+		case 0, 6:
+			copy(out, row)
+		case 2:
+			// RGB 补上不透明的 alpha
+			for x := 0; x < s.width; x++ {
+				copy(out[x*4*size:], row[x*3*size:(x+1)*3*size])
+				for c := 3 * size; c < 4*size; c++ {
+					out[x*4*size+c] = 0xff
+				}
+			}
+		case 4:
+			// 灰度 + alpha 展开为 RGBA
+			for x := 0; x < s.width; x++ {
+				g, a := row[x*2*size:(x*2+1)*size], row[(x*2+1)*size:(x+1)*2*size]
+				o := out[x*4*size:]
+				copy(o, g)
+				copy(o[size:], g)
+				copy(o[2*size:], g)
+				copy(o[3*size:], a)
+			}
+		}
+		copy(s.prev, row)
+	}
+	s.y = y1
+	return img, nil
+}
+
+func (s *pngBandSource) close() error {
+	if s.zr != nil {
+		s.zr.Close()
+	}
+	return s.f.Close()
+}
+
+// pngUnfilter 按过滤类型就地还原一行
+func pngUnfilter(filter byte, cur, prev []byte, bpp int) error {
+	switch filter {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			left := 0
+			if i >= bpp {
+				left = int(cur[i-bpp])
+			}
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			var a, c uint8
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+			cur[i] += pngPaeth(a, prev[i], c)
+		}
+	default:
+		return fmt.Errorf("png: invalid filter %d", filter)
+	}
+	return nil
+}
+
+func pngPaeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// pngIDATReader 把连续的 IDAT 块拼成一个数据流，之前的其他块跳过
+type pngIDATReader struct {
+	r      *bufio.Reader
+	remain uint32 // 当前 IDAT 块剩余字节
+	inIDAT bool
+	done   bool
+}
+
+func (p *pngIDATReader) Read(buf []byte) (int, error) {
+	for p.remain == 0 {
+		if p.done {
+			return 0, io.EOF
+		}
+		if p.inIDAT {
+			// 上一个 IDAT 的 CRC
+			if _, err := p.r.Discard(4); err != nil {
+				return 0, err
+			}
+		}
+		var hdr [8]byte
+		if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+			return 0, err
+		}
+		length, typ := binary.BigEndian.Uint32(hdr[:4]), string(hdr[4:])
+		switch {
+		case typ == "IDAT":
+			p.inIDAT = true
+			p.remain = length
+		case p.inIDAT || typ == "IEND":
+			p.done = true
+		default:
+			if _, err := p.r.Discard(int(length) + 4); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if uint32(len(buf)) > p.remain {
+		buf = buf[:p.remain]
+	}
+	n, err := p.r.Read(buf)
+	p.remain -= uint32(n)
+	return n, err
+}
+
+// tiffBandSource 单页、交错存储（PlanarConfiguration=1）的条带或分块 TIFF：
+// 8/16 位无符号整数或 32/64 位浮点，灰度或 RGB（多余的通道忽略），压缩方式见 tiffChunkReader
+type tiffBandSource struct {
+	f           *os.File
+	order       binary.ByteOrder
+	width       int
+	height      int
+	bits        int
+	float       bool
+	samples     int
+	whiteIsZero bool
+	compression uint32
+	predictor   uint32
+	tiled       bool
+	chunkW      int // 条带宽度即图片宽度
+	chunkH      int
+	offsets     []uint32
+	counts      []uint32
+
+	y          int
+	strip      io.ReadCloser // 正在读取的条带
+	stripRows  int           // 条带中剩余的行数
+	lo, hi     float64       // 浮点值的有限范围，线性归一化到 16 位
+	floatScale float64
+}
+
+func newTIFFBandSource(f *os.File) (*tiffBandSource, error) {
+	// 文件头解析不了（如 BigTIFF）时交给完整的解码器处理
+	order, tags, err := readTIFFTags(f)
+	if err != nil {
+		return nil, errTileStreamUnsupported
+	}
+	first := func(tag uint16, def uint32) uint32 {
+		if v := tags[tag]; len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+	s := &tiffBandSource{
+		f:           f,
+		order:       order,
+		width:       int(first(tiffTagImageWidth, 0)),
+		height:      int(first(tiffTagImageLength, 0)),
+		bits:        int(first(tiffTagBitsPerSample, 1)),
+		samples:     int(first(tiffTagSamplesPerPixel, 1)),
+		compression: first(tiffTagCompression, 1),
+		predictor:   first(tiffTagPredictor, 1),
+	}
+	switch sampleFormat := first(tiffTagSampleFormat, 1); {
+	case sampleFormat == 1 && (s.bits == 8 || s.bits == 16):
+	case sampleFormat == 3 && (s.bits == 32 || s.bits == 64):
+		s.float = true
+	default:
+		return nil, errTileStreamUnsupported
+	}
+	switch photometric := first(tiffTagPhotometric, 1); {
+	case s.samples == 1 && (photometric == 0 || photometric == 1):
+		s.whiteIsZero = photometric == 0
+	case s.samples >= 3 && photometric == 2:
+	default:
+		return nil, errTileStreamUnsupported
+	}
+	if first(tiffTagPlanarConfig, 1) != 1 || (s.predictor != 1 && (s.predictor != 2 || s.float)) {
+		return nil, errTileStreamUnsupported
+	}
+	switch s.compression {
+	case 1, 5, 8, 32946, 32773:
+	default:
+		return nil, errTileStreamUnsupported
+	}
+
+	chunks := 0
+	if len(tags[tiffTagTileWidth]) > 0 {
+		s.tiled = true
+		s.chunkW = int(first(tiffTagTileWidth, 0))
+		s.chunkH = int(first(tiffTagTileLength, 0))
+		s.offsets, s.counts = tags[tiffTagTileOffsets], tags[tiffTagTileByteCounts]
+		if s.chunkW > 0 && s.chunkH > 0 {
+			chunks = ((s.width + s.chunkW - 1) / s.chunkW) * ((s.height + s.chunkH - 1) / s.chunkH)
+		}
+	} else {
+		s.chunkW = s.width
+		s.chunkH = int(first(tiffTagRowsPerStrip, uint32(s.height)))
+		if s.chunkH <= 0 || s.chunkH > s.height {
+			s.chunkH = s.height
+		}
+		s.offsets, s.counts = tags[tiffTagStripOffsets], tags[tiffTagStripByteCounts]
+		if s.chunkH > 0 {
+			chunks = (s.height + s.chunkH - 1) / s.chunkH
+		}
+	}
+	if s.width <= 0 || s.height <= 0 || chunks == 0 || len(s.offsets) < chunks || len(s.counts) < chunks {
+		return nil, errTileStreamUnsupported
+	}
+
+	if s.float {
+		if err := s.scanFloatRange(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *tiffBandSource) size() (int, int) {
+	return s.width, s.height
+}
+
+func (s *tiffBandSource) rewind() error {
+	if s.strip != nil {
+		s.strip.Close()
+		s.strip = nil
+	}
+	s.stripRows = 0
+	s.y = 0
+	return nil
+}
+
+func (s *tiffBandSource) close() error {
+	s.rewind()
+	return s.f.Close()
+}
+
+// chunkReader 打开第 i 个条带或分块的解压流
+func (s *tiffBandSource) chunkReader(i int) (io.ReadCloser, error) {
+	return tiffChunkReader(io.NewSectionReader(s.f, int64(s.offsets[i]), int64(s.counts[i])), s.compression)
+}
+
+// nextRaw 读取接下来若干行的原始样本（文件字节序），返回起始行和行数
+func (s *tiffBandSource) nextRaw() ([]byte, int, int, error) {
+	if s.y >= s.height {
+		return nil, 0, 0, io.EOF
+	}
+	y0 := s.y
+	bps := s.bits / 8
+	rowBytes := s.width * s.samples * bps
+	if !s.tiled {
+		// 条带按流读取，单条带的大图也不会整条读入内存
+		rows := min(tileBandRows, s.height-y0)
+		raw := make([]byte, rows*rowBytes)
+		for done := 0; done < rows; {
+			if s.stripRows == 0 {
+				if s.strip != nil {
+					s.strip.Close()
+				}
+				i := (y0 + done) / s.chunkH
+				r, err := s.chunkReader(i)
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				s.strip = r
+				s.stripRows = min(s.chunkH, s.height-i*s.chunkH)
+			}
+			n := min(rows-done, s.stripRows)
+			part := raw[done*rowBytes : (done+n)*rowBytes]
+			if _, err := io.ReadFull(s.strip, part); err != nil {
+				return nil, 0, 0, fmt.Errorf("tiff: strip at row %d: %w", y0+done, err)
+			}
+			s.undoPredictor(part, s.width)
+			done += n
+			s.stripRows -= n
+		}
+		s.y += rows
+		return raw, y0, rows, nil
+	}
+
+	// 分块按整行分块读取，每块还原后拷入对应的列
+	rows := min(s.chunkH, s.height-y0)
+	raw := make([]byte, rows*rowBytes)
+	across := (s.width + s.chunkW - 1) / s.chunkW
+	chunkRowBytes := s.chunkW * s.samples * bps
+	tile := make([]byte, s.chunkH*chunkRowBytes)
+	for c := 0; c < across; c++ {
+		i := (y0/s.chunkH)*across + c
+		r, err := s.chunkReader(i)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		_, err = io.ReadFull(r, tile)
+		r.Close()
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("tiff: tile %d: %w", i, err)
+		}
+		s.undoPredictor(tile, s.chunkW)
+		x0 := c * s.chunkW * s.samples * bps
+		n := min(s.chunkW, s.width-c*s.chunkW) * s.samples * bps
+		for y := 0; y < rows; y++ {
+			copy(raw[y*rowBytes+x0:y*rowBytes+x0+n], tile[y*chunkRowBytes:])
+		}
+	}
+	s.y += rows
+	return raw, y0, rows, nil
+}
+
+// undoPredictor 还原水平差分（Predictor=2），每行宽 width 像素
+func (s *tiffBandSource) undoPredictor(data []byte, width int) {
+	if s.predictor != 2 {
+		return
+	}
+	bps := s.bits / 8
+	rowBytes := width * s.samples * bps
+	for off := 0; off+rowBytes <= len(data); off += rowBytes {
+		row := data[off : off+rowBytes]
+		if bps == 1 {
+			for i := s.samples; i < len(row); i++ {
+				row[i] += row[i-s.samples]
+			}
+			continue
+		}
+		step := s.samples * 2
+		for i := step; i+1 < len(row); i += 2 {
+			s.order.PutUint16(row[i:], s.order.Uint16(row[i:])+s.order.Uint16(row[i-step:]))
+		}
+	}
+}
+
+// floatSample 第 i 个浮点样本
+func (s *tiffBandSource) floatSample(raw []byte, i int) float64 {
+	if s.bits == 32 {
+		return float64(math.Float32frombits(s.order.Uint32(raw[i*4:])))
+	}
+	return math.Float64frombits(s.order.Uint64(raw[i*8:]))
+}
+
+// scanFloatRange 先读一遍浮点数据求有限值的范围，归一化方式与 floatTIFF.decode 相同
+func (s *tiffBandSource) scanFloatRange() error {
+	channels := min(s.samples, 3)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for {
+		raw, _, rows, err := s.nextRaw()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for p := 0; p < s.width*rows; p++ {
+			for c := 0; c < channels; c++ {
+				if v := s.floatSample(raw, p*s.samples+c); !math.IsNaN(v) && !math.IsInf(v, 0) {
+					lo, hi = math.Min(lo, v), math.Max(hi, v)
+				}
+			}
+		}
+	}
+	if lo > hi {
+		lo, hi = 0, 1
+	}
+	s.lo, s.hi = lo, hi
+	if hi > lo {
+		s.floatScale = 65535 / (hi - lo)
+	}
+	return s.rewind()
+}
+
+// sample16 第 i 个样本映射到 16 位
+func (s *tiffBandSource) sample16(raw []byte, i int) uint16 {
+	var v uint16
+	switch {
+	case s.float:
+		f := s.floatSample(raw, i)
+		switch {
+		case math.IsNaN(f) || f <= s.lo:
+			v = 0
+		case f >= s.hi:
+			v = 0xffff
+		default:
+			v = uint16((f - s.lo) * s.floatScale)
+		}
+	default:
+		v = s.order.Uint16(raw[i*2:])
+	}
+	if s.whiteIsZero {
+		v = 0xffff - v
+	}
+	return v
+}
+
+func (s *tiffBandSource) next() (image.Image, error) {
+	raw, y0, rows, err := s.nextRaw()
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(0, y0, s.width, y0+rows)
+	n := s.width * rows
+	if s.bits == 8 {
+		if s.samples == 1 {
+			m := &image.Gray{Pix: raw, Stride: s.width, Rect: rect}
+			if s.whiteIsZero {
+				for i := range raw {
+					raw[i] = 0xff - raw[i]
+				}
+			}
+			return m, nil
+		}
+		m := image.NewRGBA(rect)
+		for p := 0; p < n; p++ {
+			copy(m.Pix[p*4:p*4+3], raw[p*s.samples:])
+			m.Pix[p*4+3] = 0xff
+		}
+		return m, nil
+	}
+	// 16 位和浮点都转为大端 16 位，与 image/png、x/image/tiff 解码得到的类型一致
+	if s.samples == 1 {
+		m := image.NewGray16(rect)
+		for p := 0; p < n; p++ {
+			binary.BigEndian.PutUint16(m.Pix[p*2:], s.sample16(raw, p))
+		}
+		return m, nil
+	}
+	m := image.NewNRGBA64(rect)
+	for p := 0; p < n; p++ {
+		for c := 0; c < 3; c++ {
+			binary.BigEndian.PutUint16(m.Pix[p*8+c*2:], s.sample16(raw, p*s.samples+c))
+		}
+		m.Pix[p*8+6], m.Pix[p*8+7] = 0xff, 0xff
+	}
+	return m, nil
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// trashImageItem 回收站中的图片
+type trashImageItem struct {
+	ID              int64  `json:"id"`
+	Filename        string `json:"filename"`
+	ThumbPath       string `json:"thumbPath"`
+	OriginalPath    string `json:"originalPath"`
+	IsExternal      bool   `json:"isExternal"`
+	FileSize        int64  `json:"fileSize"`
+	DeletedAt       string `json:"deletedAt"`
+	AnnotationCount int    `json:"annotationCount"` // 删除前的标注数
+	RefCount        int64  `json:"refCount"`
+}
+
+// trashRequest 恢复/清除请求；All 为 true 时作用于整个回收站
+type trashRequest struct {
+	ProjectID string  `json:"projectId"`
+	ImageIDs  []int64 `json:"imageIds"`
+	All       bool    `json:"all"`
+}
+
+// handleProjectImageTrash 列出已删除（deleted_in_project = 1）的图片
+func handleProjectImageTrash(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := strings.TrimSpace(r.URL.Query().Get("projectId"))
+	if projectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	projectRoot := filepath.Join(cfg.DataPath, "project_item", projectID)
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	rows, err := db.Query(`SELECT i.id, i.filename, i.original_rel_path, i.thumb_rel_path, i.file_size, i.deleted_at, COALESCE(r.ref_count, 1),
+		(SELECT COUNT(*) FROM trashed_annotations t WHERE t.image_id = i.id)
+		FROM image_index i LEFT JOIN image_ref_count r ON i.id = r.image_id
+		WHERE i.deleted_in_project = 1 ORDER BY i.deleted_at DESC, i.id DESC;`)
+	if err != nil {
+		log.Printf("[Trash] list query failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"query_failed"}`))
+		return
+	}
+	defer rows.Close()
+
+	items := make([]trashImageItem, 0)
+	var totalBytes int64
+	for rows.Next() {
+		var it trashImageItem
+		if err := rows.Scan(&it.ID, &it.Filename, &it.OriginalPath, &it.ThumbPath, &it.FileSize, &it.DeletedAt, &it.RefCount, &it.AnnotationCount); err != nil {
+			continue
+		}
+		it.IsExternal = !(strings.HasPrefix(it.OriginalPath, "images/") || strings.HasPrefix(it.OriginalPath, "./images/"))
+		if it.ThumbPath == "" {
+			it.ThumbPath = it.OriginalPath
+		}
+		if !it.IsExternal {
+			totalBytes += it.FileSize
+		}
+		items = append(items, it)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"images":     items,
+		"total":      len(items),
+		"totalBytes": totalBytes,
+	})
+}
+
+// handleRestoreTrashImages 从回收站恢复图片及其标注
+func handleRestoreTrashImages(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, ok := decodeTrashRequest(w, r)
+	if !ok {
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+	// 与删除、导入任务互斥，避免恢复到一半的图片被再次删除
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+	defer releaseIOLock(taskID)
+
+	projectRoot := filepath.Join(cfg.DataPath, "project_item", req.ProjectID)
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+
+	where, args := trashSelection(req)
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_begin_failed"}`))
+		return
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		fmt.Sprintf(`INSERT OR IGNORE INTO annotations (id, image_id, category_id, type, data, created_at, updated_at)
+			SELECT id, image_id, category_id, type, data, created_at, updated_at FROM trashed_annotations
+			WHERE image_id IN (SELECT id FROM image_index WHERE %s);`, where),
+		fmt.Sprintf(`DELETE FROM trashed_annotations WHERE image_id IN (SELECT id FROM image_index WHERE %s);`, where),
+		// 旧版本删除时标注已丢失，状态按实际标注修正
+		fmt.Sprintf(`UPDATE image_index SET annotation_status = 'none' WHERE %s AND annotation_status = 'annotated'
+			AND NOT EXISTS (SELECT 1 FROM annotations a WHERE a.image_id = image_index.id);`, where),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			log.Printf("[Trash] restore failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"restore_failed"}`))
+			return
+		}
+	}
+	res, err := tx.Exec(fmt.Sprintf(`UPDATE image_index SET deleted_in_project = 0, deleted_at = '', annotation_revision = annotation_revision + 1 WHERE %s;`, where), args...)
+	if err != nil {
+		log.Printf("[Trash] restore failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"restore_failed"}`))
+		return
+	}
+	restored, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"tx_commit_failed"}`))
+		return
+	}
+
+	refreshProjectCounts(cfg.DataPath, req.ProjectID)
+	log.Printf("[Trash] Restored %d images in project %s", restored, req.ProjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "restored": restored})
+}
+
+// handlePurgeTrashImages 永久清除回收站中的图片（异步任务）
+func handlePurgeTrashImages(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, ok := decodeTrashRequest(w, r)
+	if !ok {
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	taskID, err := generateProjectID()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"task_id_failed"}`))
+		return
+	}
+	if !tryAcquireIOLock(taskID) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"io_task_busy","currentTaskId":"` + getCurrentIOTask() + `"}`))
+		return
+	}
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: req.ProjectID,
+		TaskType:  taskTypePurgeImages,
+		Phase:     importPhaseDeleting,
+		Total:     len(req.ImageIDs),
+	}
+	importTasksMu.Unlock()
+
+	go runPurgeImagesTask(cfg.DataPath, taskID, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"taskId": taskID})
+}
+
+// runPurgeImagesTask 减少引用计数；计数归零的图片删除记录，
+// 当前库和所有版本快照都不再引用的项目内文件才从磁盘删除（外部文件从不删除）
+func runPurgeImagesTask(dataPath, taskID string, req trashRequest) {
+	defer releaseIOLock(taskID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Trash] Task %s panic: %v", taskID, r)
+			failImportTask(taskID, "panic_in_purge_task")
+		}
+	}()
+
+	projectRoot := filepath.Join(dataPath, "project_item", req.ProjectID)
+	dbPath := filepath.Join(projectRoot, "db", "project.db")
+	db, err := acquireProjectDB(dbPath)
+	if err != nil {
+		failImportTask(taskID, "db_unavailable")
+		return
+	}
+	defer db.Release()
+
+	where, args := trashSelection(req)
+	rows, err := db.Query(fmt.Sprintf(`SELECT i.id, i.original_rel_path, i.thumb_rel_path, COALESCE(r.ref_count, 1)
+		FROM image_index i LEFT JOIN image_ref_count r ON i.id = r.image_id WHERE %s;`, where), args...)
+	if err != nil {
+		log.Printf("[Trash] Task %s query failed: %v", taskID, err)
+		failImportTask(taskID, "query_failed")
+		return
+	}
+	type purgeCandidate struct {
+		ID          int64
+		OriginalRel string
+		ThumbRel    string
+		RefCount    int64
+	}
+	var candidates []purgeCandidate
+	for rows.Next() {
+		var c purgeCandidate
+		if rows.Scan(&c.ID, &c.OriginalRel, &c.ThumbRel, &c.RefCount) == nil {
+			candidates = append(candidates, c)
+		}
+	}
+	rows.Close()
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Total = len(candidates)
+		task.Progress = 10
+	})
+
+	tx, err := db.Begin()
+	if err != nil {
+		failImportTask(taskID, "tx_begin_failed")
+		return
+	}
+	var removed []purgeCandidate
+	var stillReferenced []int64
+	for _, c := range candidates {
+		if c.RefCount > 1 {
+			if _, err := tx.Exec(`UPDATE image_ref_count SET ref_count = ref_count - 1 WHERE image_id = ?;`, c.ID); err != nil {
+				tx.Rollback()
+				failImportTask(taskID, "update_failed")
+				return
+			}
+			stillReferenced = append(stillReferenced, c.ID)
+			continue
+		}
+		for _, stmt := range []string{
+			`DELETE FROM trashed_annotations WHERE image_id = ?;`,
+			`DELETE FROM annotations WHERE image_id = ?;`,
+			`DELETE FROM image_metadata WHERE image_id = ?;`,
+			`DELETE FROM image_tags WHERE image_id = ?;`,
+			`DELETE FROM annotation_change_items WHERE change_id IN (SELECT id FROM annotation_changes WHERE image_id = ?);`,
+			`DELETE FROM annotation_changes WHERE image_id = ?;`,
+			`DELETE FROM image_ref_count WHERE image_id = ?;`,
+			`DELETE FROM image_index WHERE id = ?;`,
+		} {
+			if _, err := tx.Exec(stmt, c.ID); err != nil {
+				log.Printf("[Trash] Task %s delete image %d failed: %v", taskID, c.ID, err)
+				tx.Rollback()
+				failImportTask(taskID, "delete_failed")
+				return
+			}
+		}
+		removed = append(removed, c)
+	}
+	if err := tx.Commit(); err != nil {
+		failImportTask(taskID, "tx_commit_failed")
+		return
+	}
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Progress = 40
+	})
+
+	// 记录已删除，之后的文件清理失败只影响磁盘占用
+	referenced, err := collectReferencedImagePaths(projectRoot)
+	if err != nil {
+		log.Printf("[Trash] Task %s collect references failed, keeping files: %v", taskID, err)
+		referenced = nil
+	}
+	imagesDir := filepath.Join(projectRoot, "images")
+	var freed int64
+	for idx, c := range removed {
+		if referenced != nil {
+			for _, rel := range []string{c.OriginalRel, c.ThumbRel} {
+				if rel == "" {
+					continue
+				}
+				if _, ok := referenced[normalizeImageRef(rel)]; ok {
+					continue
+				}
+				p := resolveProjectImagePath(projectRoot, filepath.FromSlash(rel))
+				if !isPathWithin(imagesDir, p) {
+					continue
+				}
+				info, err := os.Stat(p)
+				if err != nil {
+					continue
+				}
+				if err := os.Remove(p); err != nil {
+					log.Printf("[Trash] Task %s remove %s failed: %v", taskID, p, err)
+					continue
+				}
+				freed += info.Size()
+				// 同一文件作为原图和缩略图时只删一次
+				referenced[normalizeImageRef(rel)] = struct{}{}
+			}
+		}
+		// 瓦片是可再生的缓存，直接删除
+		_ = os.RemoveAll(tileDirFor(projectRoot, c.ID))
+		done := idx + 1
+		updateImportTask(taskID, func(task *ImportTaskStatus) {
+			task.Imported = done
+			task.Progress = 40 + int(float64(done)*60/float64(len(removed)))
+		})
+	}
+
+	refreshProjectCounts(dataPath, req.ProjectID)
+
+	updateImportTask(taskID, func(task *ImportTaskStatus) {
+		task.Phase = importPhaseCompleted
+		task.Progress = 100
+		task.Imported = len(removed)
+		task.FreedBytes = freed
+		task.StillReferenced = stillReferenced
+	})
+	log.Printf("[Trash] Task %s purged %d images (%d still referenced), freed %d bytes", taskID, len(removed), len(stillReferenced), freed)
+}
+
+// collectReferencedImagePaths 汇总当前库和所有版本快照引用的原图与缩略图路径
+func collectReferencedImagePaths(projectRoot string) (map[string]struct{}, error) {
+	dbFiles := []string{filepath.Join(projectRoot, "db", "project.db")}
+	// 当前库不存在时不能打开（会新建空库），也就无法判断引用
+	if _, err := os.Stat(dbFiles[0]); err != nil {
+		return nil, err
+	}
+	if matches, err := filepath.Glob(filepath.Join(projectRoot, "db", "versions", "v*", "project.db")); err == nil {
+		dbFiles = append(dbFiles, matches...)
+	}
+
+	refs := make(map[string]struct{})
+	for _, dbPath := range dbFiles {
+		db, err := acquireProjectDB(dbPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dbPath, err)
+		}
+		rows, err := db.Query(`SELECT original_rel_path, thumb_rel_path FROM image_index;`)
+		if err != nil {
+			db.Release()
+			return nil, fmt.Errorf("%s: %w", dbPath, err)
+		}
+		for rows.Next() {
+			var original, thumb string
+			if rows.Scan(&original, &thumb) != nil {
+				continue
+			}
+			refs[normalizeImageRef(original)] = struct{}{}
+			refs[normalizeImageRef(thumb)] = struct{}{}
+		}
+		err = rows.Err()
+		rows.Close()
+		db.Release()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dbPath, err)
+		}
+	}
+	return refs, nil
+}
+
+// normalizeImageRef 统一数据库中路径的写法（./images/a.jpg 与 images/a.jpg 视为相同）
+func normalizeImageRef(rel string) string {
+	return strings.TrimPrefix(filepath.ToSlash(rel), "./")
+}
+
+// decodeTrashRequest 解析并校验回收站请求，失败时已写出响应
+func decodeTrashRequest(w http.ResponseWriter, r *http.Request) (trashRequest, bool) {
+	var req trashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return req, false
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return req, false
+	}
+	ids := make([]int64, 0, len(req.ImageIDs))
+	for _, id := range req.ImageIDs {
+		if id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	req.ImageIDs = ids
+	if !req.All && len(req.ImageIDs) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"image_ids_required"}`))
+		return req, false
+	}
+	return req, true
+}
+
+// trashSelection 生成 image_index 上选择回收站图片的条件
+func trashSelection(req trashRequest) (string, []interface{}) {
+	if req.All {
+		return "deleted_in_project = 1", nil
+	}
+	placeholders := make([]string, len(req.ImageIDs))
+	args := make([]interface{}, len(req.ImageIDs))
+	for i, id := range req.ImageIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return fmt.Sprintf("deleted_in_project = 1 AND id IN (%s)", strings.Join(placeholders, ",")), args
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"database/sql"
 	"net/http"
+	"path/filepath"
+	"strings"
 )
 
 // withCORS 添加 CORS 头（保留在 utils.go，其他函数已移至 remaining.go）
@@ -13,6 +15,7 @@ func withCORS(w http.ResponseWriter) {
 }
 
 // openProjectDB 打开项目数据库并设置 busy_timeout 以避免并发锁定问题
+// 打开时会把 schema 迁移到最新版本（版本快照同样适用）
 // 调用者需要负责 defer db.Close()
 func openProjectDB(dbPath string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", dbPath)
@@ -24,5 +27,31 @@ func openProjectDB(dbPath string) (*sql.DB, error) {
 		db.Close()
 		return nil, err
 	}
+	if err := migrateProjectDB(db, dbPath); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openSQLiteRaw 打开任意 SQLite 文件（不执行项目 schema 迁移），用于复制与校验
+func openSQLiteRaw(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, nil
 }
+
+// isPathWithin 判断 p 是否位于 root 之内（含 root 本身）；Windows 下 filepath.Rel 不区分大小写
+func isPathWithin(root, p string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(p))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,950 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 监视目录轮询参数
+const (
+	watchFolderPollInterval = 5 * time.Second
+	// watchFolderSettle 新文件大小和修改时间保持不变这么久之后才导入，避免读到仍在写入的文件
+	watchFolderSettle = 3 * time.Second
+	// watchFolderRetryDelay 导入任务失败后，同一目录等待这么久再重试
+	watchFolderRetryDelay = time.Minute
+)
+
+var errWatchFolderNotFound = errors.New("watch folder not found")
+
+// WatchFolder 项目的监视目录；新出现的图片按设置的方式自动导入
+// 目录及已处理过的文件保存在项目库中，ID 只在项目内唯一
+type WatchFolder struct {
+	ID              int64  `json:"id"`
+	ProjectID       string `json:"projectId"`
+	Path            string `json:"path"`
+	ImportMode      string `json:"importMode"`      // copy / link / external
+	DuplicatePolicy string `json:"duplicatePolicy"` // skip / rename / link
+	Recursive       bool   `json:"recursive"`
+	Paused          bool   `json:"paused"`
+	CreatedAt       string `json:"createdAt"`
+
+	// 运行状态，不持久化
+	Available    bool   `json:"available"`
+	Pending      int    `json:"pending"` // 等待稳定的新文件
+	Importing    bool   `json:"importing"`
+	LastScanAt   string `json:"lastScanAt,omitempty"`
+	LastImportAt string `json:"lastImportAt,omitempty"`
+	LastImported int    `json:"lastImported"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+// watchFileStamp 判断文件是否变化的依据
+type watchFileStamp struct {
+	Size    int64
+	ModTime int64
+}
+
+// watchPendingFile 已发现但还未稳定的文件
+type watchPendingFile struct {
+	stamp watchFileStamp
+	since time.Time
+}
+
+// watchFolderState 单个监视目录的运行状态
+type watchFolderState struct {
+	seen         map[string]watchFileStamp // 已处理的文件，首次轮询时从项目库加载
+	pending      map[string]watchPendingFile
+	available    bool
+	reported     bool // 不可用状态已经广播过
+	importing    bool
+	lastScanAt   time.Time
+	lastImportAt time.Time
+	lastImported int
+	lastError    string
+	retryAt      time.Time
+}
+
+// watchFolderKey 运行状态按项目和目录 ID 区分
+type watchFolderKey struct {
+	ProjectID string
+	ID        int64
+}
+
+func watchKey(f WatchFolder) watchFolderKey {
+	return watchFolderKey{ProjectID: f.ProjectID, ID: f.ID}
+}
+
+var (
+	watchFoldersMu    sync.Mutex
+	watchFolderStates = make(map[watchFolderKey]*watchFolderState)
+)
+
+// watchFolderStateFor 取得目录的运行状态，调用方持有 watchFoldersMu
+func watchFolderStateFor(key watchFolderKey) *watchFolderState {
+	st, ok := watchFolderStates[key]
+	if !ok {
+		st = &watchFolderState{pending: make(map[string]watchPendingFile), available: true}
+		watchFolderStates[key] = st
+	}
+	return st
+}
+
+// fillWatchFolderStatus 把运行状态填入返回值
+func fillWatchFolderStatus(f *WatchFolder) {
+	watchFoldersMu.Lock()
+	defer watchFoldersMu.Unlock()
+	f.Available = true
+	st, ok := watchFolderStates[watchKey(*f)]
+	if !ok {
+		return
+	}
+	f.Available = st.available
+	f.Pending = len(st.pending)
+	f.Importing = st.importing
+	if !st.lastScanAt.IsZero() {
+		f.LastScanAt = st.lastScanAt.UTC().Format(time.RFC3339)
+	}
+	if !st.lastImportAt.IsZero() {
+		f.LastImportAt = st.lastImportAt.UTC().Format(time.RFC3339)
+	}
+	f.LastImported = st.lastImported
+	f.LastError = st.lastError
+}
+
+const watchFolderSelectColumns = `id, path, import_mode, duplicate_policy, recursive, paused, created_at`
+
+// scanWatchFolder 读取一行监视目录记录
+func scanWatchFolder(projectID string, scan func(dest ...interface{}) error) (WatchFolder, error) {
+	f := WatchFolder{ProjectID: projectID}
+	var recursive, paused int
+	if err := scan(&f.ID, &f.Path, &f.ImportMode, &f.DuplicatePolicy, &recursive, &paused, &f.CreatedAt); err != nil {
+		return f, err
+	}
+	f.Recursive = recursive != 0
+	f.Paused = paused != 0
+	return f, nil
+}
+
+// watchProjectDBPath 项目当前库的路径
+func watchProjectDBPath(dataPath, projectID string) string {
+	return filepath.Join(dataPath, "project_item", projectID, "db", "project.db")
+}
+
+// acquireWatchProjectDB 打开项目库；项目库不存在时返回 errProjectNotFound，不新建文件
+func acquireWatchProjectDB(dataPath, projectID string) (*projectDBHandle, error) {
+	dbPath := watchProjectDBPath(dataPath, projectID)
+	if _, err := os.Stat(dbPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errProjectNotFound
+		}
+		return nil, err
+	}
+	return acquireProjectDB(dbPath)
+}
+
+// loadWatchFolders 列出监视目录；projectID 为空时返回全部项目的
+func loadWatchFolders(dataPath, projectID string) ([]WatchFolder, error) {
+	if projectID != "" {
+		return loadProjectWatchFolders(dataPath, projectID)
+	}
+	projects, err := loadProjects(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	folders := make([]WatchFolder, 0)
+	for _, p := range projects {
+		list, err := loadProjectWatchFolders(dataPath, p.ID)
+		if err != nil {
+			log.Printf("[WatchFolder] load watch folders of project %s failed: %v", p.ID, err)
+			continue
+		}
+		folders = append(folders, list...)
+	}
+	return folders, nil
+}
+
+// loadProjectWatchFolders 列出单个项目的监视目录，项目库不存在时返回空列表
+func loadProjectWatchFolders(dataPath, projectID string) ([]WatchFolder, error) {
+	db, err := acquireWatchProjectDB(dataPath, projectID)
+	if errors.Is(err, errProjectNotFound) {
+		return []WatchFolder{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer db.Release()
+	rows, err := db.Query(`SELECT ` + watchFolderSelectColumns + ` FROM watch_folders ORDER BY id ASC;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	folders := make([]WatchFolder, 0)
+	for rows.Next() {
+		f, err := scanWatchFolder(projectID, rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+// loadWatchFolderSeen 读取目录已处理过的文件
+func loadWatchFolderSeen(dataPath string, f WatchFolder) (map[string]watchFileStamp, error) {
+	db, err := acquireWatchProjectDB(dataPath, f.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Release()
+	rows, err := db.Query(`SELECT path, size, mod_time FROM watch_files WHERE folder_id = ?;`, f.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[string]watchFileStamp)
+	for rows.Next() {
+		var p string
+		var s watchFileStamp
+		if err := rows.Scan(&p, &s.Size, &s.ModTime); err != nil {
+			return nil, err
+		}
+		seen[p] = s
+	}
+	return seen, rows.Err()
+}
+
+// recordWatchFolderSeen 把文件记为已处理，之后内容不变就不再导入
+func recordWatchFolderSeen(db *sql.DB, folderID int64, files map[string]watchFileStamp) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO watch_files (folder_id, path, size, mod_time) VALUES (?, ?, ?, ?)
+ON CONFLICT(folder_id, path) DO UPDATE SET size = excluded.size, mod_time = excluded.mod_time;`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for p, s := range files {
+		if _, err := stmt.Exec(folderID, p, s.Size, s.ModTime); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// scanWatchFolderFiles 列出目录下的图片及其大小、修改时间
+func scanWatchFolderFiles(root string, recursive bool) (map[string]watchFileStamp, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+	files := make(map[string]watchFileStamp)
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 根目录读不到视为目录不可用，子目录出错跳过
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && !recursive {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !isImagePath(p) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[p] = watchFileStamp{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// startWatchFolderPoller 启动监视目录的后台轮询
+// 网络共享目录上的文件系统通知不可靠，这里统一用轮询
+func startWatchFolderPoller() {
+	go func() {
+		ticker := time.NewTicker(watchFolderPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			pollWatchFolders()
+		}
+	}()
+}
+
+// pollWatchFolders 扫描所有未暂停的监视目录，导入已经稳定的新文件
+func pollWatchFolders() {
+	cfg, err := loadPathsConfig()
+	if err != nil || cfg.DataPath == "" {
+		return
+	}
+	folders, err := loadWatchFolders(cfg.DataPath, "")
+	if err != nil {
+		log.Printf("[WatchFolder] load watch folders failed: %v", err)
+		return
+	}
+
+	// 清理已删除目录的状态
+	active := make(map[watchFolderKey]bool, len(folders))
+	for _, f := range folders {
+		active[watchKey(f)] = true
+	}
+	watchFoldersMu.Lock()
+	for key, st := range watchFolderStates {
+		if !active[key] && !st.importing {
+			delete(watchFolderStates, key)
+		}
+	}
+	watchFoldersMu.Unlock()
+
+	for _, f := range folders {
+		if f.Paused {
+			continue
+		}
+		pollWatchFolder(cfg.DataPath, f)
+	}
+}
+
+// pollWatchFolder 扫描一个目录；新文件连续两次扫描不变且超过 watchFolderSettle 后导入
+func pollWatchFolder(dataPath string, f WatchFolder) {
+	files, scanErr := scanWatchFolderFiles(f.Path, f.Recursive)
+	now := time.Now()
+
+	watchFoldersMu.Lock()
+	st := watchFolderStateFor(watchKey(f))
+	st.lastScanAt = now
+	if scanErr != nil {
+		st.available = false
+		st.pending = make(map[string]watchPendingFile)
+		report := !st.reported
+		st.reported = true
+		watchFoldersMu.Unlock()
+		if report {
+			log.Printf("[WatchFolder] folder %d unavailable: %s: %v", f.ID, f.Path, scanErr)
+			broadcastWatchFolder("watch_folder_unavailable", f, scanErr.Error(), false)
+		}
+		return
+	}
+	recovered := st.reported
+	st.available = true
+	st.reported = false
+	needSeen := st.seen == nil
+	watchFoldersMu.Unlock()
+
+	if recovered {
+		log.Printf("[WatchFolder] folder %d available again: %s", f.ID, f.Path)
+		broadcastWatchFolder("watch_folder_available", f, "", true)
+	}
+	if needSeen {
+		seen, err := loadWatchFolderSeen(dataPath, f)
+		if err != nil {
+			log.Printf("[WatchFolder] load seen files for folder %d failed: %v", f.ID, err)
+			return
+		}
+		watchFoldersMu.Lock()
+		st.seen = seen
+		watchFoldersMu.Unlock()
+	}
+
+	ready := make(map[string]watchFileStamp)
+	watchFoldersMu.Lock()
+	for p := range st.pending {
+		if _, ok := files[p]; !ok {
+			delete(st.pending, p)
+		}
+	}
+	for p, stamp := range files {
+		if old, ok := st.seen[p]; ok && old == stamp {
+			continue
+		}
+		pf, ok := st.pending[p]
+		if !ok || pf.stamp != stamp {
+			st.pending[p] = watchPendingFile{stamp: stamp, since: now}
+			continue
+		}
+		if now.Sub(pf.since) >= watchFolderSettle {
+			ready[p] = stamp
+		}
+	}
+	retryAt := st.retryAt
+	importing := st.importing
+	watchFoldersMu.Unlock()
+
+	// 上一批还在导入时先不提交新任务，文件留在等待列表中
+	if len(ready) == 0 || importing || now.Before(retryAt) {
+		return
+	}
+	importWatchFolderFiles(dataPath, f, ready)
+}
+
+// importWatchFolderFiles 提交普通的导入任务导入一批文件，任务在后台执行；IO 锁被占用时留到下次轮询
+func importWatchFolderFiles(dataPath string, f WatchFolder, ready map[string]watchFileStamp) {
+	taskID, err := generateProjectID()
+	if err != nil {
+		return
+	}
+	if !tryAcquireIOLock(taskID) {
+		return
+	}
+
+	paths := make([]string, 0, len(ready))
+	for p := range ready {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	importTasksMu.Lock()
+	importTasks[taskID] = &ImportTaskStatus{
+		ID:        taskID,
+		ProjectID: f.ProjectID,
+		TaskType:  taskTypeImportImages,
+		Phase:     importPhaseCopying,
+	}
+	importTasksMu.Unlock()
+
+	watchFoldersMu.Lock()
+	st := watchFolderStateFor(watchKey(f))
+	st.importing = true
+	watchFoldersMu.Unlock()
+
+	log.Printf("[WatchFolder] folder %d: importing %d new files into project %s (task %s, mode=%s)", f.ID, len(paths), f.ProjectID, taskID, f.ImportMode)
+	broadcastGlobalWS(GlobalWSMessage{
+		Type:    "watch_folder_import_started",
+		TaskID:  taskID,
+		Message: f.Path,
+		Data:    map[string]interface{}{"folderId": f.ID, "projectId": f.ProjectID, "files": len(paths)},
+	})
+
+	// 与手动导入一样在后台执行，任务结束时释放 IO 锁；轮询不等待任务完成
+	go func() {
+		runImportImagesTask(dataPath, f.ProjectID, taskID, f.ImportMode, f.DuplicatePolicy, paths, nil)
+		finishWatchFolderImport(dataPath, f, taskID, ready)
+	}()
+}
+
+// finishWatchFolderImport 导入任务结束后更新目录状态；成功时把文件记为已处理
+func finishWatchFolderImport(dataPath string, f WatchFolder, taskID string, ready map[string]watchFileStamp) {
+	importTasksMu.Lock()
+	var task ImportTaskStatus
+	if t, ok := importTasks[taskID]; ok {
+		task = *t
+	}
+	importTasksMu.Unlock()
+
+	if task.Phase != importPhaseCompleted {
+		reason := task.Error
+		if reason == "" {
+			reason = "import_failed"
+		}
+		watchFoldersMu.Lock()
+		st := watchFolderStateFor(watchKey(f))
+		st.importing = false
+		st.lastError = reason
+		st.retryAt = time.Now().Add(watchFolderRetryDelay)
+		watchFoldersMu.Unlock()
+		log.Printf("[WatchFolder] folder %d: import task %s failed: %s", f.ID, taskID, reason)
+		broadcastGlobalWS(GlobalWSMessage{
+			Type:    "watch_folder_error",
+			TaskID:  taskID,
+			Message: reason,
+			Data:    map[string]interface{}{"folderId": f.ID, "projectId": f.ProjectID},
+		})
+		return
+	}
+
+	// 单个文件导入失败时任务本身仍会完成，这些文件同样记为已处理，改动后才会重试
+	// 迁移期间不记录，这些文件下次轮询会再次导入，按重复策略处理
+	db, err := acquireWatchProjectDB(dataPath, f.ProjectID)
+	if err == nil {
+		if projectWriteGate.TryRLock() {
+			err = recordWatchFolderSeen(db.DB, f.ID, ready)
+			endProjectWrite()
+		} else {
+			err = errDataRelocating
+		}
+		db.Release()
+	}
+	if err != nil {
+		log.Printf("[WatchFolder] folder %d: record seen files failed: %v", f.ID, err)
+	}
+
+	watchFoldersMu.Lock()
+	st := watchFolderStateFor(watchKey(f))
+	st.importing = false
+	st.lastError = ""
+	st.retryAt = time.Time{}
+	st.lastImportAt = time.Now()
+	st.lastImported = task.Imported
+	if st.seen == nil {
+		st.seen = make(map[string]watchFileStamp)
+	}
+	for p, stamp := range ready {
+		st.seen[p] = stamp
+		delete(st.pending, p)
+	}
+	watchFoldersMu.Unlock()
+
+	log.Printf("[WatchFolder] folder %d: task %s imported %d of %d files, %d duplicates", f.ID, taskID, task.Imported, len(ready), len(task.Duplicates))
+	broadcastGlobalWS(GlobalWSMessage{
+		Type:    "watch_folder_imported",
+		TaskID:  taskID,
+		Message: f.Path,
+		Data: map[string]interface{}{
+			"folderId":   f.ID,
+			"projectId":  f.ProjectID,
+			"files":      len(ready),
+			"imported":   task.Imported,
+			"duplicates": len(task.Duplicates),
+		},
+		Success: true,
+	})
+}
+
+// broadcastWatchFolder 广播监视目录状态变化
+func broadcastWatchFolder(msgType string, f WatchFolder, message string, success bool) {
+	broadcastGlobalWS(GlobalWSMessage{
+		Type:    msgType,
+		Message: message,
+		Data:    map[string]interface{}{"folderId": f.ID, "projectId": f.ProjectID, "path": f.Path},
+		Success: success,
+	})
+}
+
+// handleProjectWatchFolders GET 列出项目的监视目录，POST 新增
+func handleProjectWatchFolders(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		projectID := strings.TrimSpace(r.URL.Query().Get("projectId"))
+		if projectID == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"project_required"}`))
+			return
+		}
+		folders, err := loadWatchFolders(cfg.DataPath, projectID)
+		if err != nil {
+			log.Printf("[WatchFolder] list for project %s failed: %v", projectID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+			return
+		}
+		for i := range folders {
+			fillWatchFolderStatus(&folders[i])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"folders": folders})
+		return
+	}
+
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req struct {
+		ProjectID       string `json:"projectId"`
+		Path            string `json:"path"`
+		ImportMode      string `json:"importMode"`
+		DuplicatePolicy string `json:"duplicatePolicy"`
+		Recursive       *bool  `json:"recursive"`
+		// ImportExisting 为 true 时目录里已有的图片也会导入，否则只导入之后新出现的
+		ImportExisting bool `json:"importExisting"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	req.Path = strings.TrimSpace(req.Path)
+	req.ImportMode = strings.TrimSpace(strings.ToLower(req.ImportMode))
+	req.DuplicatePolicy = strings.TrimSpace(strings.ToLower(req.DuplicatePolicy))
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	if req.ImportMode == "" {
+		req.ImportMode = "copy"
+	}
+	if req.ImportMode != "copy" && req.ImportMode != "link" && req.ImportMode != "external" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"import_mode_invalid"}`))
+		return
+	}
+	if req.DuplicatePolicy == "" {
+		req.DuplicatePolicy = duplicatePolicySkip
+	}
+	if req.DuplicatePolicy != duplicatePolicySkip && req.DuplicatePolicy != duplicatePolicyRename && req.DuplicatePolicy != duplicatePolicyLink {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"duplicate_policy_invalid"}`))
+		return
+	}
+	if req.Path == "" || !filepath.IsAbs(req.Path) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"path_invalid"}`))
+		return
+	}
+	req.Path = filepath.Clean(req.Path)
+	if info, err := os.Stat(req.Path); err != nil || !info.IsDir() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"directory_invalid"}`))
+		return
+	}
+	// 监视数据目录本身会把导入的副本再次导入
+	if isPathWithin(cfg.DataPath, req.Path) || isPathWithin(req.Path, cfg.DataPath) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"path_overlaps_data_dir"}`))
+		return
+	}
+	if _, err := getProject(cfg.DataPath, req.ProjectID); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errProjectNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"catalog_unavailable"}`))
+		return
+	}
+	recursive := true
+	if req.Recursive != nil {
+		recursive = *req.Recursive
+	}
+
+	// 不导入已有文件时，先把它们记为已处理
+	var existing map[string]watchFileStamp
+	if !req.ImportExisting {
+		existing, err = scanWatchFolderFiles(req.Path, recursive)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"directory_invalid"}`))
+			return
+		}
+	}
+
+	db, err := acquireWatchProjectDB(cfg.DataPath, req.ProjectID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errProjectNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	defer db.Release()
+	folder := WatchFolder{
+		ProjectID:       req.ProjectID,
+		Path:            req.Path,
+		ImportMode:      req.ImportMode,
+		DuplicatePolicy: req.DuplicatePolicy,
+		Recursive:       recursive,
+		CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+	}
+	res, err := db.Exec(`INSERT INTO watch_folders (path, import_mode, duplicate_policy, recursive, paused, created_at) VALUES (?, ?, ?, ?, 0, ?);`,
+		folder.Path, folder.ImportMode, folder.DuplicatePolicy, boolToInt(recursive), folder.CreatedAt)
+	if isUniqueViolation(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"watch_folder_exists"}`))
+		return
+	}
+	if err == nil {
+		folder.ID, err = res.LastInsertId()
+	}
+	if err == nil && len(existing) > 0 {
+		err = recordWatchFolderSeen(db.DB, folder.ID, existing)
+	}
+	if err != nil {
+		log.Printf("[WatchFolder] add %s for project %s failed: %v", req.Path, req.ProjectID, err)
+		if folder.ID != 0 {
+			_, _ = db.Exec(`DELETE FROM watch_files WHERE folder_id = ?;`, folder.ID)
+			_, _ = db.Exec(`DELETE FROM watch_folders WHERE id = ?;`, folder.ID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+
+	log.Printf("[WatchFolder] watching %s for project %s (folder %d, mode=%s, existing=%d skipped)", folder.Path, folder.ProjectID, folder.ID, folder.ImportMode, len(existing))
+	broadcastWatchFolder("watch_folder_added", folder, "", true)
+	fillWatchFolderStatus(&folder)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"folder": folder})
+}
+
+// handleDeleteWatchFolder 停止监视并删除目录记录；已导入的图片不受影响
+func handleDeleteWatchFolder(w http.ResponseWriter, r *http.Request) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req struct {
+		ProjectID string `json:"projectId"`
+		ID        int64  `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	folder, err := deleteWatchFolder(cfg.DataPath, req.ProjectID, req.ID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errWatchFolderNotFound) || errors.Is(err, errProjectNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"watch_folder_not_found"}`))
+			return
+		}
+		log.Printf("[WatchFolder] delete folder %d of project %s failed: %v", req.ID, req.ProjectID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+
+	watchFoldersMu.Lock()
+	if st, ok := watchFolderStates[watchKey(folder)]; !ok || !st.importing {
+		delete(watchFolderStates, watchKey(folder))
+	}
+	watchFoldersMu.Unlock()
+
+	log.Printf("[WatchFolder] stopped watching %s (folder %d)", folder.Path, folder.ID)
+	broadcastWatchFolder("watch_folder_removed", folder, "", true)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
+// deleteWatchFolder 删除目录及其已处理文件记录，返回被删除的目录
+func deleteWatchFolder(dataPath, projectID string, id int64) (WatchFolder, error) {
+	db, err := acquireWatchProjectDB(dataPath, projectID)
+	if err != nil {
+		return WatchFolder{}, err
+	}
+	defer db.Release()
+	tx, err := db.Begin()
+	if err != nil {
+		return WatchFolder{}, err
+	}
+	defer tx.Rollback()
+	folder, err := scanWatchFolder(projectID, tx.QueryRow(`SELECT `+watchFolderSelectColumns+` FROM watch_folders WHERE id = ?;`, id).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WatchFolder{}, errWatchFolderNotFound
+	}
+	if err != nil {
+		return WatchFolder{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM watch_files WHERE folder_id = ?;`, id); err != nil {
+		return WatchFolder{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM watch_folders WHERE id = ?;`, id); err != nil {
+		return WatchFolder{}, err
+	}
+	return folder, tx.Commit()
+}
+
+// handlePauseWatchFolders 暂停监视；请求体 {projectId, id}，不带 id 时暂停项目的全部目录
+func handlePauseWatchFolders(w http.ResponseWriter, r *http.Request) {
+	handleSetWatchFoldersPaused(w, r, true)
+}
+
+// handleResumeWatchFolders 恢复监视，参数同暂停
+func handleResumeWatchFolders(w http.ResponseWriter, r *http.Request) {
+	handleSetWatchFoldersPaused(w, r, false)
+}
+
+func handleSetWatchFoldersPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	withCORS(w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !beginProjectWrite(w) {
+		return
+	}
+	defer endProjectWrite()
+
+	var req struct {
+		ProjectID string `json:"projectId"`
+		ID        int64  `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_json"}`))
+		return
+	}
+	req.ProjectID = strings.TrimSpace(req.ProjectID)
+	if req.ProjectID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"project_required"}`))
+		return
+	}
+	cfg, err := loadPathsConfig()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"config_unavailable"}`))
+		return
+	}
+
+	db, err := acquireWatchProjectDB(cfg.DataPath, req.ProjectID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, errProjectNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"project_not_found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	if req.ID > 0 {
+		_, err = db.Exec(`UPDATE watch_folders SET paused = ? WHERE id = ?;`, boolToInt(paused), req.ID)
+	} else {
+		_, err = db.Exec(`UPDATE watch_folders SET paused = ?;`, boolToInt(paused))
+	}
+	db.Release()
+	if err != nil {
+		log.Printf("[WatchFolder] set paused=%v failed: %v", paused, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+
+	// 返回受影响的目录；暂停时丢弃等待中的文件，恢复时重新计时
+	all, err := loadWatchFolders(cfg.DataPath, req.ProjectID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"db_unavailable"}`))
+		return
+	}
+	folders := make([]WatchFolder, 0, len(all))
+	for _, f := range all {
+		if req.ID > 0 && f.ID != req.ID {
+			continue
+		}
+		folders = append(folders, f)
+	}
+	if req.ID > 0 && len(folders) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"watch_folder_not_found"}`))
+		return
+	}
+	msgType := "watch_folder_resumed"
+	if paused {
+		msgType = "watch_folder_paused"
+	}
+	watchFoldersMu.Lock()
+	for _, f := range folders {
+		if st, ok := watchFolderStates[watchKey(f)]; ok {
+			st.pending = make(map[string]watchPendingFile)
+			st.retryAt = time.Time{}
+		}
+	}
+	watchFoldersMu.Unlock()
+	for i := range folders {
+		log.Printf("[WatchFolder] folder %d %s: %s", folders[i].ID, strings.TrimPrefix(msgType, "watch_folder_"), folders[i].Path)
+		broadcastWatchFolder(msgType, folders[i], "", true)
+		fillWatchFolderStatus(&folders[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"folders": folders, "paused": paused})
+}
+
+// boolToInt SQLite 用 0/1 存布尔值
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
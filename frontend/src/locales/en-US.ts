@@ -272,7 +272,10 @@ const enUS = {
     },
     save: {
       success: 'Annotations saved',
-      error: 'Failed to save annotations. Please try again'
+      error: 'Failed to save annotations. Please try again',
+      conflict: 'Annotations were changed in another window or task. Your edits were kept but not saved. Please try again',
+      invalid: 'Some annotations are invalid and were not saved: {details}',
+      conflictIds: 'Annotations {ids} were also changed in another window or task. Your version has been saved'
     },
     saveAsNegative: {
       success: 'Saved as negative sample',
@@ -271,7 +271,10 @@ const zhCN = {
     },
     save: {
       success: '标注已保存',
-      error: '保存标注失败，请稍后重试'
+      error: '保存标注失败，请稍后重试',
+      conflict: '标注已在其他窗口或任务中被修改，本地编辑已保留但未能保存，请稍后重试',
+      invalid: '部分标注无效，未保存：{details}',
+      conflictIds: '标注 {ids} 同时在其他窗口或任务中被修改，已保存为本地版本'
     },
     saveAsNegative: {
       success: '已保存为负样本',
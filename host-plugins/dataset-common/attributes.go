@@ -0,0 +1,139 @@
+// Annotation attributes shared by the import and export formats
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ==================== Attribute Schema ====================
+// 类别 mate 中的 attributes 定义属性，标注的属性值在 data.attributes
+// 格式与 EasyMark 后端一致：{"attributes":[{"name":"color","type":"enum","values":["red"]}]}
+
+type AttributeDef struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // bool, enum, string
+	Values   []string `json:"values,omitempty"`
+	Required bool     `json:"required,omitempty"`
+}
+
+// parseAttributeSchema 从类别 mate 读取属性定义，mate 为空或无法解析时返回 nil
+func parseAttributeSchema(mate string) []AttributeDef {
+	if mate == "" {
+		return nil
+	}
+	var m struct {
+		Attributes []AttributeDef `json:"attributes"`
+	}
+	if err := json.Unmarshal([]byte(mate), &m); err != nil {
+		return nil
+	}
+	return m.Attributes
+}
+
+// annotationAttributes 取标注 data 中的属性值
+func annotationAttributes(data map[string]interface{}) map[string]interface{} {
+	attrs, _ := data["attributes"].(map[string]interface{})
+	return attrs
+}
+
+// attributeFlag 布尔属性转为 VOC/CVAT 使用的 0/1
+func attributeFlag(attrs map[string]interface{}, name string) int {
+	if v, ok := attrs[name].(bool); ok && v {
+		return 1
+	}
+	return 0
+}
+
+// validAttributeName 与后端的属性名规则一致
+func validAttributeName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c == '-' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// ==================== Schema Inference (Import) ====================
+
+// attributeSchemaBuilder 导入时根据出现过的属性值推断每个类别的属性定义
+// 只保留布尔和字符串值，名称须为字母、数字、下划线或连字符；同名属性出现过两种类型时按字符串处理
+type attributeSchemaBuilder struct {
+	types map[string]map[string]string // categoryKey -> name -> type
+}
+
+func newAttributeSchemaBuilder() *attributeSchemaBuilder {
+	return &attributeSchemaBuilder{types: make(map[string]map[string]string)}
+}
+
+// add 记录一条标注的属性，返回可以写入 data.attributes 的值；没有可用属性时返回 nil
+func (b *attributeSchemaBuilder) add(categoryKey string, attrs map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for name, v := range attrs {
+		if !validAttributeName(name) {
+			continue
+		}
+		var typ string
+		switch v.(type) {
+		case bool:
+			typ = "bool"
+		case string:
+			typ = "string"
+		default:
+			continue
+		}
+		cat := b.types[categoryKey]
+		if cat == nil {
+			cat = make(map[string]string)
+			b.types[categoryKey] = cat
+		}
+		if prev, ok := cat[name]; ok && prev != typ {
+			typ = "string"
+		}
+		cat[name] = typ
+		out[name] = v
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// schema 返回类别推断出的属性定义，按名称排序
+func (b *attributeSchemaBuilder) schema(categoryKey string) []AttributeDef {
+	cat := b.types[categoryKey]
+	if len(cat) == 0 {
+		return nil
+	}
+	defs := make([]AttributeDef, 0, len(cat))
+	for name, typ := range cat {
+		defs = append(defs, AttributeDef{Name: name, Type: typ})
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// applyTo 把推断出的属性定义写入类别 Meta，并修正已导入标注中类型冲突的值
+func (b *attributeSchemaBuilder) applyTo(resp *ImportResponse) {
+	for i := range resp.Categories {
+		if defs := b.schema(resp.Categories[i].Key); len(defs) > 0 {
+			if resp.Categories[i].Meta == nil {
+				resp.Categories[i].Meta = map[string]interface{}{}
+			}
+			resp.Categories[i].Meta["attributes"] = defs
+		}
+	}
+	for _, ann := range resp.Annotations {
+		attrs, _ := ann.Data["attributes"].(map[string]interface{})
+		for name, v := range attrs {
+			if flag, ok := v.(bool); ok && b.types[ann.CategoryKey][name] == "string" {
+				attrs[name] = fmt.Sprintf("%t", flag)
+			}
+		}
+	}
+}
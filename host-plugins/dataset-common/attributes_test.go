@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeExportFiles 把导出结果写入目录，供导入读取
+func writeExportFiles(t *testing.T, root string, resp ExportResponse) {
+	t.Helper()
+	for _, f := range resp.Structure.Files {
+		p := filepath.Join(root, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(f.Content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVOCAttributeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name:  "all VOC fields",
+			attrs: map[string]interface{}{"pose": "Left", "truncated": true, "difficult": true, "occluded": true},
+			want:  map[string]interface{}{"pose": "Left", "truncated": true, "difficult": true, "occluded": true},
+		},
+		{
+			name:  "no attributes",
+			attrs: nil,
+			want:  map[string]interface{}{"truncated": false, "difficult": false},
+		},
+		{
+			name:  "occluded false is kept",
+			attrs: map[string]interface{}{"occluded": false},
+			want:  map[string]interface{}{"truncated": false, "difficult": false, "occluded": false},
+		},
+		{
+			name:  "unspecified pose is dropped",
+			attrs: map[string]interface{}{"pose": vocPoseUnspecified, "difficult": true},
+			want:  map[string]interface{}{"truncated": false, "difficult": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]interface{}{"x": 0.1, "y": 0.2, "width": 0.3, "height": 0.4}
+			if tt.attrs != nil {
+				data["attributes"] = tt.attrs
+			}
+			var exported ExportResponse
+			ExportVOC(ExportRequest{
+				Images:      []ExportImage{{Key: "1", RelativePath: "a.jpg", Width: 100, Height: 50, Split: "train"}},
+				Categories:  []ExportCategory{{ID: 1, Name: "car", Type: "bbox"}},
+				Annotations: []ExportAnnotation{{ImageKey: "1", CategoryID: 1, Type: "bbox", Data: data}},
+			}, &exported)
+
+			root := t.TempDir()
+			writeExportFiles(t, root, exported)
+			var imported ImportResponse
+			ImportVOC(ImportRequest{RootPath: root, Params: map[string]interface{}{"annotationFile": "train/Annotations"}}, &imported)
+			if len(imported.Errors) > 0 {
+				t.Fatalf("import errors: %+v", imported.Errors)
+			}
+			if len(imported.Annotations) != 1 || len(imported.Categories) != 1 {
+				t.Fatalf("imported %d annotations, %d categories", len(imported.Annotations), len(imported.Categories))
+			}
+			got := annotationAttributes(imported.Annotations[0].Data)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attributes = %v, want %v", got, tt.want)
+			}
+
+			// 类别的属性定义按导入的值推断，全部为布尔或字符串
+			defs, _ := imported.Categories[0].Meta["attributes"].([]AttributeDef)
+			if len(defs) != len(tt.want) {
+				t.Fatalf("schema = %+v, want %d attributes", defs, len(tt.want))
+			}
+			for _, d := range defs {
+				wantType := "bool"
+				if _, ok := tt.want[d.Name].(string); ok {
+					wantType = "string"
+				}
+				if d.Type != wantType {
+					t.Errorf("schema %s type = %s, want %s", d.Name, d.Type, wantType)
+				}
+			}
+		})
+	}
+}
+
+func TestExportCVATAttributes(t *testing.T) {
+	mate := `{"attributes":[{"name":"occluded","type":"bool"},{"name":"parked","type":"bool"},{"name":"color","type":"enum","values":["red","blue"]},{"name":"plate","type":"string"}]}`
+	wantSpecs := []CVATAttributeSpec{
+		{Name: "parked", Mutable: "False", InputType: "checkbox", DefaultValue: "false", Values: "false"},
+		{Name: "color", Mutable: "False", InputType: "select", DefaultValue: "red", Values: "red\nblue"},
+		{Name: "plate", Mutable: "False", InputType: "text"},
+	}
+	tests := []struct {
+		name         string
+		attrs        map[string]interface{}
+		wantOccluded int
+		wantAttrs    []CVATAttribute
+	}{
+		{
+			name:         "occluded goes on the shape",
+			attrs:        map[string]interface{}{"occluded": true, "parked": false, "color": "blue", "plate": "AB 123"},
+			wantOccluded: 1,
+			wantAttrs: []CVATAttribute{
+				{Name: "color", Value: "blue"},
+				{Name: "parked", Value: "false"},
+				{Name: "plate", Value: "AB 123"},
+			},
+		},
+		{
+			name:      "no attributes",
+			attrs:     nil,
+			wantAttrs: nil,
+		},
+		{
+			name:      "unsupported value types are skipped",
+			attrs:     map[string]interface{}{"parked": true, "count": 3.0},
+			wantAttrs: []CVATAttribute{{Name: "parked", Value: "true"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]interface{}{"x": 0.1, "y": 0.2, "width": 0.3, "height": 0.4}
+			if tt.attrs != nil {
+				data["attributes"] = tt.attrs
+			}
+			var resp ExportResponse
+			ExportCVAT(ExportRequest{
+				Images:      []ExportImage{{Key: "1", RelativePath: "a.jpg", Width: 100, Height: 50}},
+				Categories:  []ExportCategory{{ID: 1, Name: "car", Type: "bbox", Mate: mate}},
+				Annotations: []ExportAnnotation{{ImageKey: "1", CategoryID: 1, Type: "bbox", Data: data}},
+			}, &resp)
+			if len(resp.Structure.Files) != 1 {
+				t.Fatalf("files = %d, want 1", len(resp.Structure.Files))
+			}
+			var doc CVATAnnotations
+			content := strings.TrimPrefix(resp.Structure.Files[0].Content, xml.Header)
+			if err := xml.Unmarshal([]byte(content), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if len(doc.Meta.Task.Labels) != 1 || !reflect.DeepEqual(doc.Meta.Task.Labels[0].Attributes, wantSpecs) {
+				t.Errorf("label attributes = %+v, want %+v", doc.Meta.Task.Labels, wantSpecs)
+			}
+			if len(doc.Images) != 1 || len(doc.Images[0].Boxes) != 1 {
+				t.Fatalf("images = %+v", doc.Images)
+			}
+			box := doc.Images[0].Boxes[0]
+			if box.Occluded != tt.wantOccluded {
+				t.Errorf("occluded = %d, want %d", box.Occluded, tt.wantOccluded)
+			}
+			if !reflect.DeepEqual(box.Attributes, tt.wantAttrs) {
+				t.Errorf("attributes = %+v, want %+v", box.Attributes, tt.wantAttrs)
+			}
+		})
+	}
+}
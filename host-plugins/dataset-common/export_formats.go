@@ -462,6 +462,8 @@ func ExportCOCO(req ExportRequest, resp *ExportResponse) {
 		default:
 			continue
 		}
+		// 与 CVAT 导出的 COCO 一致，属性放在 annotation.attributes
+		cocoAnn.Attributes = annotationAttributes(data)
 
 		splitData[imgSplit].Annotations = append(splitData[imgSplit].Annotations, cocoAnn)
 		annID++
@@ -565,11 +567,24 @@ func ExportVOC(req ExportRequest, resp *ExportResponse) {
 			xmax := (x + w) * float64(img.Width)
 			ymax := (y + h) * float64(img.Height)
 
+			// 属性映射为 VOC 字段：pose（字符串）、truncated/difficult/occluded（布尔）
+			attrs := annotationAttributes(data)
+			pose, _ := attrs["pose"].(string)
+			if pose == "" {
+				pose = vocPoseUnspecified
+			}
+			var occluded *int
+			if _, ok := attrs["occluded"].(bool); ok {
+				v := attributeFlag(attrs, "occluded")
+				occluded = &v
+			}
+
 			vocAnn.Objects = append(vocAnn.Objects, VOCObject{
 				Name:      catName,
-				Pose:      "Unspecified",
-				Truncated: 0,
-				Difficult: 0,
+				Pose:      pose,
+				Truncated: attributeFlag(attrs, "truncated"),
+				Difficult: attributeFlag(attrs, "difficult"),
+				Occluded:  occluded,
 				Bndbox: VOCBBox{
 					Xmin: xmin,
 					Ymin: ymin,
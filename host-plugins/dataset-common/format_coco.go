@@ -32,6 +32,8 @@ type COCOAnnotation struct {
 	Segmentation interface{} `json:"segmentation,omitempty"`
 	Area         float64     `json:"area,omitempty"`
 	IsCrowd      int         `json:"iscrowd,omitempty"`
+	// CVAT 等工具导出的扩展字段，如 {"occluded": false, "color": "red"}
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
 type COCOCategory struct {
@@ -265,6 +267,7 @@ func ImportCOCO(req ImportRequest, resp *ImportResponse) {
 	}
 
 	// Process annotations
+	attrSchema := newAttributeSchemaBuilder()
 	for _, ann := range dataset.Annotations {
 		imageKey, ok := imageIDMap[ann.ImageID]
 		if !ok {
@@ -312,6 +315,9 @@ func ImportCOCO(req ImportRequest, resp *ImportResponse) {
 				data["keypointCategoryKey"] = kpKey
 			}
 		}
+		if attrs := attrSchema.add(categoryKey, ann.Attributes); attrs != nil {
+			data["attributes"] = attrs
+		}
 
 		resp.Annotations = append(resp.Annotations, AnnotationDef{
 			ImageKey:    imageKey,
@@ -320,6 +326,7 @@ func ImportCOCO(req ImportRequest, resp *ImportResponse) {
 			Data:        data,
 		})
 	}
+	attrSchema.applyTo(resp)
 	resp.Stats.AnnotationCount = len(resp.Annotations)
 }
 
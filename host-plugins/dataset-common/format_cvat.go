@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ==================== CVAT Format Structures ====================
+// CVAT for images 1.1：单个 annotations.xml，类别及其属性定义在 meta/task/labels 中
+
+type CVATAnnotations struct {
+	XMLName xml.Name    `xml:"annotations"`
+	Version string      `xml:"version"`
+	Meta    CVATMeta    `xml:"meta"`
+	Images  []CVATImage `xml:"image"`
+}
+
+type CVATMeta struct {
+	Task CVATTask `xml:"task"`
+}
+
+type CVATTask struct {
+	Name    string      `xml:"name"`
+	Size    int         `xml:"size"`
+	Mode    string      `xml:"mode"`
+	Subsets string      `xml:"subsets,omitempty"`
+	Labels  []CVATLabel `xml:"labels>label"`
+}
+
+type CVATLabel struct {
+	Name       string              `xml:"name"`
+	Color      string              `xml:"color,omitempty"`
+	Type       string              `xml:"type"`
+	Attributes []CVATAttributeSpec `xml:"attributes>attribute"`
+}
+
+type CVATAttributeSpec struct {
+	Name         string `xml:"name"`
+	Mutable      string `xml:"mutable"`
+	InputType    string `xml:"input_type"`
+	DefaultValue string `xml:"default_value"`
+	Values       string `xml:"values"`
+}
+
+type CVATImage struct {
+	ID       int           `xml:"id,attr"`
+	Name     string        `xml:"name,attr"`
+	Subset   string        `xml:"subset,attr,omitempty"`
+	Width    int           `xml:"width,attr"`
+	Height   int           `xml:"height,attr"`
+	Boxes    []CVATBox     `xml:"box"`
+	Polygons []CVATPolygon `xml:"polygon"`
+	Points   []CVATPolygon `xml:"points"`
+	Tags     []CVATTag     `xml:"tag"`
+}
+
+type CVATBox struct {
+	Label      string          `xml:"label,attr"`
+	Source     string          `xml:"source,attr"`
+	Occluded   int             `xml:"occluded,attr"`
+	Xtl        string          `xml:"xtl,attr"`
+	Ytl        string          `xml:"ytl,attr"`
+	Xbr        string          `xml:"xbr,attr"`
+	Ybr        string          `xml:"ybr,attr"`
+	Rotation   string          `xml:"rotation,attr,omitempty"`
+	ZOrder     int             `xml:"z_order,attr"`
+	Attributes []CVATAttribute `xml:"attribute"`
+}
+
+// CVATPolygon 多边形和点集共用，points 为 "x1,y1;x2,y2"
+type CVATPolygon struct {
+	Label      string          `xml:"label,attr"`
+	Source     string          `xml:"source,attr"`
+	Occluded   int             `xml:"occluded,attr"`
+	Points     string          `xml:"points,attr"`
+	ZOrder     int             `xml:"z_order,attr"`
+	Attributes []CVATAttribute `xml:"attribute"`
+}
+
+type CVATTag struct {
+	Label      string          `xml:"label,attr"`
+	Source     string          `xml:"source,attr"`
+	Attributes []CVATAttribute `xml:"attribute"`
+}
+
+type CVATAttribute struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// cvatOccludedAttribute CVAT 的遮挡是形状自带的字段，不作为普通属性输出
+const cvatOccludedAttribute = "occluded"
+
+// ==================== CVAT Export ====================
+
+func ExportCVAT(req ExportRequest, resp *ExportResponse) {
+	resp.Structure.Directories = []string{"images/train", "images/val", "images/test"}
+
+	catByID := make(map[int]ExportCategory)
+	labels := make([]CVATLabel, 0, len(req.Categories))
+	for _, cat := range req.Categories {
+		catByID[cat.ID] = cat
+		labels = append(labels, CVATLabel{
+			Name:       cat.Name,
+			Color:      cat.Color,
+			Type:       cvatLabelType(cat.Type),
+			Attributes: cvatAttributeSpecs(parseAttributeSchema(cat.Mate)),
+		})
+	}
+
+	imgAnnotations := make(map[string][]ExportAnnotation)
+	for _, ann := range req.Annotations {
+		imgAnnotations[ann.ImageKey] = append(imgAnnotations[ann.ImageKey], ann)
+	}
+
+	doc := CVATAnnotations{
+		Version: "1.1",
+		Meta: CVATMeta{Task: CVATTask{
+			Name:   "easymark",
+			Size:   len(req.Images),
+			Mode:   "annotation",
+			Labels: labels,
+		}},
+	}
+
+	trainCount, valCount, testCount := 0, 0, 0
+	usedSubsets := map[string]bool{}
+	for i, img := range req.Images {
+		split := img.Split
+		if split == "" {
+			split = "train"
+		}
+		switch split {
+		case "train":
+			trainCount++
+		case "val":
+			valCount++
+		case "test":
+			testCount++
+		}
+		usedSubsets[split] = true
+
+		imgName := filepath.Base(img.RelativePath)
+		cvatImg := CVATImage{
+			ID:     i,
+			Name:   imgName,
+			Subset: split,
+			Width:  img.Width,
+			Height: img.Height,
+		}
+		width, height := float64(img.Width), float64(img.Height)
+
+		for _, ann := range imgAnnotations[img.Key] {
+			cat, ok := catByID[ann.CategoryID]
+			if !ok {
+				continue
+			}
+			data := ann.Data
+			attrs := annotationAttributes(data)
+			occluded := attributeFlag(attrs, cvatOccludedAttribute)
+			cvatAttrs := cvatAttributeValues(attrs)
+
+			switch ann.Type {
+			case "bbox":
+				x, _ := toFloat64(data["x"])
+				y, _ := toFloat64(data["y"])
+				w, _ := toFloat64(data["width"])
+				h, _ := toFloat64(data["height"])
+				box := CVATBox{
+					Label:      cat.Name,
+					Source:     "manual",
+					Occluded:   occluded,
+					Xtl:        cvatCoord(x * width),
+					Ytl:        cvatCoord(y * height),
+					Xbr:        cvatCoord((x + w) * width),
+					Ybr:        cvatCoord((y + h) * height),
+					Attributes: cvatAttrs,
+				}
+				// EasyMark 的旋转为绕中心顺时针的弧度，CVAT 为角度
+				if r, ok := toFloat64(data["rotation"]); ok && r != 0 {
+					deg := math.Mod(r*180/math.Pi, 360)
+					if deg < 0 {
+						deg += 360
+					}
+					box.Rotation = cvatCoord(deg)
+				}
+				cvatImg.Boxes = append(cvatImg.Boxes, box)
+
+			case "polygon":
+				points, ok := data["points"].([]interface{})
+				if !ok || len(points) < 3 {
+					continue
+				}
+				cvatImg.Polygons = append(cvatImg.Polygons, CVATPolygon{
+					Label:      cat.Name,
+					Source:     "manual",
+					Occluded:   occluded,
+					Points:     cvatPointList(points, width, height, false),
+					Attributes: cvatAttrs,
+				})
+
+			case "keypoint":
+				// 只输出可见的点；bbox 上附带的关键点没有对应的 CVAT 形状，不导出
+				points, ok := data["points"].([]interface{})
+				if !ok {
+					continue
+				}
+				list := cvatPointList(points, width, height, true)
+				if list == "" {
+					continue
+				}
+				cvatImg.Points = append(cvatImg.Points, CVATPolygon{
+					Label:      cat.Name,
+					Source:     "manual",
+					Occluded:   occluded,
+					Points:     list,
+					Attributes: cvatAttrs,
+				})
+
+			case "category":
+				cvatImg.Tags = append(cvatImg.Tags, CVATTag{
+					Label:      cat.Name,
+					Source:     "manual",
+					Attributes: cvatAttrs,
+				})
+			}
+		}
+		doc.Images = append(doc.Images, cvatImg)
+
+		resp.Structure.CopyImages = append(resp.Structure.CopyImages, CopyTask{
+			From: img.AbsolutePath,
+			To:   fmt.Sprintf("images/%s/%s", split, imgName),
+		})
+	}
+
+	var subsets []string
+	for _, s := range []string{"train", "val", "test"} {
+		if usedSubsets[s] {
+			subsets = append(subsets, s)
+		}
+	}
+	doc.Meta.Task.Subsets = strings.Join(subsets, "\n")
+
+	xmlData, _ := xml.MarshalIndent(doc, "", "  ")
+	resp.Structure.Files = append(resp.Structure.Files, FileOutput{
+		Path:    "annotations.xml",
+		Content: xml.Header + string(xmlData),
+	})
+
+	resp.Stats = ExportStats{
+		ImageCount:      len(req.Images),
+		AnnotationCount: len(req.Annotations),
+		TrainCount:      trainCount,
+		ValCount:        valCount,
+		TestCount:       testCount,
+	}
+}
+
+// cvatLabelType EasyMark 类别类型对应的 CVAT 标签类型
+func cvatLabelType(catType string) string {
+	switch catType {
+	case "bbox":
+		return "rectangle"
+	case "polygon":
+		return "polygon"
+	case "keypoint":
+		return "points"
+	case "category":
+		return "tag"
+	default:
+		return "any"
+	}
+}
+
+// cvatAttributeSpecs 属性定义转为 CVAT 标签的属性：bool -> checkbox，enum -> select，string -> text
+func cvatAttributeSpecs(defs []AttributeDef) []CVATAttributeSpec {
+	specs := make([]CVATAttributeSpec, 0, len(defs))
+	for _, d := range defs {
+		if d.Name == cvatOccludedAttribute && d.Type == "bool" {
+			continue
+		}
+		spec := CVATAttributeSpec{Name: d.Name, Mutable: "False"}
+		switch d.Type {
+		case "bool":
+			spec.InputType = "checkbox"
+			spec.DefaultValue = "false"
+			spec.Values = "false"
+		case "enum":
+			spec.InputType = "select"
+			if len(d.Values) > 0 {
+				spec.DefaultValue = d.Values[0]
+			}
+			spec.Values = strings.Join(d.Values, "\n")
+		default:
+			spec.InputType = "text"
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// cvatAttributeValues 标注的属性值，按名称排序；occluded 已写在形状上
+func cvatAttributeValues(attrs map[string]interface{}) []CVATAttribute {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		if name != cvatOccludedAttribute {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out := make([]CVATAttribute, 0, len(names))
+	for _, name := range names {
+		switch v := attrs[name].(type) {
+		case bool:
+			out = append(out, CVATAttribute{Name: name, Value: fmt.Sprintf("%t", v)})
+		case string:
+			out = append(out, CVATAttribute{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+// cvatPointList 归一化点列表转为 "x1,y1;x2,y2"；visibleOnly 时跳过 v 为 0 的关键点
+func cvatPointList(points []interface{}, width, height float64, visibleOnly bool) string {
+	parts := make([]string, 0, len(points))
+	for _, pt := range points {
+		point, ok := pt.([]interface{})
+		if !ok || len(point) < 2 {
+			continue
+		}
+		if visibleOnly {
+			if len(point) < 3 {
+				continue
+			}
+			if v, _ := toFloat64(point[2]); v <= 0 {
+				continue
+			}
+		}
+		px, _ := toFloat64(point[0])
+		py, _ := toFloat64(point[1])
+		parts = append(parts, cvatCoord(px*width)+","+cvatCoord(py*height))
+	}
+	return strings.Join(parts, ";")
+}
+
+// cvatCoord CVAT 坐标保留两位小数
+func cvatCoord(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
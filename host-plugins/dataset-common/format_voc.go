@@ -30,9 +30,13 @@ type VOCObject struct {
 	Pose      string  `xml:"pose"`
 	Truncated int     `xml:"truncated"`
 	Difficult int     `xml:"difficult"`
+	Occluded  *int    `xml:"occluded,omitempty"` // VOC2010 起部分数据集带有
 	Bndbox    VOCBBox `xml:"bndbox"`
 }
 
+// vocPoseUnspecified VOC 未标注姿态时的取值，导入时不作为属性保存
+const vocPoseUnspecified = "Unspecified"
+
 type VOCBBox struct {
 	Xmin float64 `xml:"xmin"`
 	Ymin float64 `xml:"ymin"`
@@ -211,6 +215,8 @@ func ImportVOC(req ImportRequest, resp *ImportResponse) {
 	}
 
 	// Second pass: build images and annotations
+	// pose/truncated/difficult/occluded 保存为标注属性，类别的属性定义按出现过的字段生成
+	attrSchema := newAttributeSchemaBuilder()
 	imageKeyMap := make(map[string]bool)
 	for _, xmlFile := range xmlFiles {
 		ann, err := parseVOCFile(xmlFile)
@@ -276,19 +282,33 @@ func ImportVOC(req ImportRequest, resp *ImportResponse) {
 			w := (obj.Bndbox.Xmax - obj.Bndbox.Xmin) / imgWidth
 			h := (obj.Bndbox.Ymax - obj.Bndbox.Ymin) / imgHeight
 
+			data := map[string]interface{}{
+				"x":      x,
+				"y":      y,
+				"width":  w,
+				"height": h,
+			}
+			attrs := map[string]interface{}{
+				"truncated": obj.Truncated == 1,
+				"difficult": obj.Difficult == 1,
+			}
+			if pose := strings.TrimSpace(obj.Pose); pose != "" && pose != vocPoseUnspecified {
+				attrs["pose"] = pose
+			}
+			if obj.Occluded != nil {
+				attrs["occluded"] = *obj.Occluded == 1
+			}
+			data["attributes"] = attrSchema.add(catKey, attrs)
+
 			resp.Annotations = append(resp.Annotations, AnnotationDef{
 				ImageKey:    imageKey,
 				CategoryKey: catKey,
 				Type:        "bbox",
-				Data: map[string]interface{}{
-					"x":      x,
-					"y":      y,
-					"width":  w,
-					"height": h,
-				},
+				Data:        data,
 			})
 		}
 	}
+	attrSchema.applyTo(resp)
 
 	resp.Stats.ImageCount = len(resp.Images)
 	resp.Stats.AnnotationCount = len(resp.Annotations)
@@ -347,7 +367,7 @@ func findVOCImage(root, imagesDir, imageName string) string {
 
 	// Try different extensions
 	baseName := strings.TrimSuffix(imageName, filepath.Ext(imageName))
-	exts := []string{".jpg", ".jpeg", ".png", ".bmp", ".webp"}
+	exts := []string{".jpg", ".jpeg", ".png", ".bmp", ".webp", ".tif", ".tiff"}
 	for _, ext := range exts {
 		fullPath := filepath.Join(imagesDir, baseName+ext)
 		if _, err := os.Stat(fullPath); err == nil {
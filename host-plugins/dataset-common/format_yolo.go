@@ -449,7 +449,7 @@ func buildYOLOImageIndex(root, imagesDirAbs string) (map[string]string, []ImageR
 
 	validExts := map[string]bool{
 		".jpg": true, ".jpeg": true, ".png": true,
-		".bmp": true, ".webp": true,
+		".bmp": true, ".webp": true, ".tif": true, ".tiff": true,
 	}
 
 	filepath.WalkDir(searchRoot, func(path string, d fs.DirEntry, err error) error {
@@ -1,5 +1,5 @@
 // Common Dataset Import Plugin for EasyMark
-// Supports COCO, YOLO, and Pascal VOC formats
+// Supports COCO, YOLO, and Pascal VOC formats; exports CVAT XML as well
 package main
 
 import (
@@ -204,6 +204,8 @@ func handleExport(input []byte) {
 		ExportCOCO(req, &resp)
 	case "voc":
 		ExportVOC(req, &resp)
+	case "cvat":
+		ExportCVAT(req, &resp)
 	default:
 		resp.Success = false
 		resp.Errors = append(resp.Errors, ErrorItem{